@@ -1,5 +1,5 @@
 import { useState } from 'react';
-import { CheckCircle2, XCircle, Loader2, type LucideIcon } from 'lucide-react';
+import { CheckCircle2, XCircle, Loader2, AlertTriangle, type LucideIcon } from 'lucide-react';
 import { Card, CardHeader, CardTitle, CardDescription, CardContent } from '@/components/ui/card';
 import { Button } from '@/components/ui/button';
 import { DynamicShadowWrapper } from '@/components/ui/dynamic-shadow-wrapper';
@@ -11,6 +11,7 @@ interface SocialMediaCardProps {
   icon: LucideIcon;
   iconBackgroundClass: string;
   isLinked: boolean | null;
+  needsReconnect?: boolean;
   linkEndpoint: string;
   unlinkEndpoint: string;
   onStatusChange?: () => void;
@@ -22,6 +23,7 @@ export function SocialMediaCard({
   icon: Icon,
   iconBackgroundClass,
   isLinked,
+  needsReconnect = false,
   linkEndpoint,
   unlinkEndpoint,
   onStatusChange,
@@ -58,6 +60,14 @@ export function SocialMediaCard({
       };
     }
     
+    if (needsReconnect) {
+      return {
+        icon: <AlertTriangle className="h-5 w-5" />,
+        text: 'Reconnect needed',
+        className: 'text-destructive',
+      };
+    }
+
     if (isLinked) {
       return {
         icon: <CheckCircle2 className="h-5 w-5" />,
@@ -75,8 +85,8 @@ export function SocialMediaCard({
 
   const status = getStatus();
   const showDisconnectButton = isLinked;
-  const showConnectButton = !showDisconnectButton;
-  const connectButtonText = isLinked ? 'Reauthorize' : 'Connect Account';
+  const showConnectButton = !showDisconnectButton || needsReconnect;
+  const connectButtonText = isLinked || needsReconnect ? 'Reconnect' : 'Connect Account';
 
   return (
     <DynamicShadowWrapper>
@@ -1,7 +1,45 @@
-import { useState, useEffect, useMemo } from 'react';
+import { useState, useEffect, useMemo, useRef } from 'react';
 import axios from 'axios';
 import { toast } from 'sonner';
-import type { FormDataState, TabKey, MediaItemType, MediaOverride } from '@/types/types';
+import type { FormDataState, TabKey, MediaItemType, MediaOverride, PublishProgress } from '@/types/types';
+
+// followPublish reports the progress of a background publish until it is
+// done, and rejects with the error if it fails.
+function followPublish(eventsURL: string, onProgress: (progress: PublishProgress) => void): Promise<PublishProgress> {
+    return new Promise((resolve, reject) => {
+        const source = new EventSource(eventsURL);
+        source.onmessage = (message) => {
+            const progress: PublishProgress = JSON.parse(message.data);
+            onProgress(progress);
+            if (progress.stage === 'done') {
+                source.close();
+                resolve(progress);
+            } else if (progress.stage === 'failed') {
+                source.close();
+                reject(new Error(progress.error));
+            }
+        };
+        source.onerror = () => {
+            // The browser reconnects on its own while the stream is open.
+            if (source.readyState === EventSource.CLOSED) {
+                reject(new Error('Lost track of the post.'));
+            }
+        };
+    });
+}
+
+export function describeProgress(progress: PublishProgress | null): string {
+    switch (progress?.stage) {
+        case 'uploading':
+            return `Uploading ${progress.current ?? 0}/${progress.total ?? 0}...`;
+        case 'processing':
+            return progress.percent ? `Processing ${progress.percent}%...` : 'Processing...';
+        case 'publishing':
+            return 'Publishing...';
+        default:
+            return 'Posting...';
+    }
+}
 
 export function useMediaManager(files: FileList | undefined, activeTab: TabKey) {
     const [isReady, setIsReady] = useState(false);
@@ -15,6 +53,7 @@ export function useMediaManager(files: FileList | undefined, activeTab: TabKey)
         artstation: {},
     });
     const [isSubmitting, setIsSubmitting] = useState(false);
+    const [publishProgress, setPublishProgress] = useState<PublishProgress | null>(null);
     const [originalMediaItems, setOriginalMediaItems] = useState<MediaItemType[]>([]);
     const [originalFileMap, setOriginalFileMap] = useState<Map<string, File>>(new Map());
     const [mediaOverrides, setMediaOverrides] = useState<Record<TabKey, Record<string, MediaOverride>>>({
@@ -92,6 +131,15 @@ export function useMediaManager(files: FileList | undefined, activeTab: TabKey)
         });
     }, [originalMediaItems]);
 
+    // The Idempotency-Key of the post being composed. It is kept across
+    // submits, so a resubmit after a dropped connection is a repeat the
+    // server recognises, and dropped once the post was accepted or the
+    // form changed.
+    const idempotencyKey = useRef<string | null>(null);
+    useEffect(() => {
+        idempotencyKey.current = null;
+    }, [files, activeTab, formData, selectedMedia, orderedMediaByTab, mediaOverrides]);
+
     const carouselMediaItems = useMemo(() => {
         const ordered = orderedMediaByTab?.[activeTab] || [];
         const overridesForTab = mediaOverrides?.[activeTab] || {};
@@ -162,15 +210,24 @@ export function useMediaManager(files: FileList | undefined, activeTab: TabKey)
             if (fileToSubmit) submissionData.append('media', fileToSubmit);
         }
 
+        idempotencyKey.current ??= crypto.randomUUID();
+
         try {
-            await axios.post('/api/create', submissionData, {
-                headers: { 'Content-Type': 'multipart/form-data' },
+            // The key lets the server tell a retried request from a new post.
+            const response = await axios.post('/api/create', submissionData, {
+                headers: {
+                    'Content-Type': 'multipart/form-data',
+                    'Idempotency-Key': idempotencyKey.current,
+                },
             });
+            idempotencyKey.current = null;
+            await followPublish(response.data.events, setPublishProgress);
             toast.success('Posted successfully!');
         } catch (error) {
             toast.error('Failed to create post.');
         } finally {
             setIsSubmitting(false);
+            setPublishProgress(null);
         }
     };
 
@@ -179,6 +236,7 @@ export function useMediaManager(files: FileList | undefined, activeTab: TabKey)
         formData,
         setFormData,
         isSubmitting,
+        publishProgress,
         orderedMediaByTab,
         setOrderedMediaByTab,
         mediaOverrides,
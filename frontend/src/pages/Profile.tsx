@@ -6,6 +6,7 @@ import { SocialMediaCard } from '@/components/ui/social-media-card';
 const Profile: React.FC = () => {
     const [twitterLinked, setTwitterLinked] = useState<boolean | null>(null);
     const [instagramLinked, setInstagramLinked] = useState<boolean | null>(null);
+    const [instagramReconnect, setInstagramReconnect] = useState(false);
     const [blueskyLinked, setBlueskyLinked] = useState<boolean | null>(null);
 
     useEffect(() => {
@@ -22,6 +23,7 @@ const Profile: React.FC = () => {
 
         setTwitterLinked(data.twitter_linked);
         setInstagramLinked(data.instagram_linked);
+        setInstagramReconnect(Boolean(data.instagram_reconnect_needed));
         setBlueskyLinked(data.bluesky_linked);
       } catch (error) {
         toast.error('Error fetching connect status');
@@ -63,10 +65,12 @@ const Profile: React.FC = () => {
                     icon={Instagram}
                     iconBackgroundClass="bg-gradient-to-br from-[var(--color-instagram-from)] via-[var(--color-instagram-via)] to-[var(--color-instagram-to)]"
                     isLinked={instagramLinked}
+                    needsReconnect={instagramReconnect}
                     linkEndpoint="/api/instagram/link/begin"
                     unlinkEndpoint="/api/instagram/unlink"
                     onStatusChange={() => {
                         setInstagramLinked(false);
+                        setInstagramReconnect(false);
                     }}
                 />
 
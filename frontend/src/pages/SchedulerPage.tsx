@@ -14,7 +14,7 @@ import { Tabs, TabsContent, TabsList, TabsTrigger } from '@/components/ui/tabs';
 import { DynamicShadowWrapper } from '@/components/ui/dynamic-shadow-wrapper';
 import Carousel from '@/components/ui/carousel';
 
-import { useMediaManager } from '@/lib/useMediaManager';
+import { useMediaManager, describeProgress } from '@/lib/useMediaManager';
 import { TwitterTab } from '@/pages/schedule/TwitterTab';
 import { InstagramTab } from '@/pages/schedule/InstagramTab';
 import type { TabKey, FormDataState } from '@/types/types';
@@ -28,6 +28,7 @@ export function SchedulerPage() {
     formData,
     setFormData,
     isSubmitting,
+    publishProgress,
     handleSubmit,
     carouselMediaItems,
     selectedMedia,
@@ -127,7 +128,7 @@ export function SchedulerPage() {
                     className="w-full bg-primary text-primary-foreground"
                     disabled={isSubmitting}
                   >
-                    {isSubmitting ? 'Posting...' : `Post for ${activeTab}`}
+                    {isSubmitting ? describeProgress(publishProgress) : `Post for ${activeTab}`}
                   </Button>
                 </CardFooter>
               </form>
@@ -2,6 +2,18 @@ export type TabKey = 'twitter' | 'youtube' | 'instagram' | 'reddit' | 'mastodon'
 
 export type MediaItemType = { id: string; type: "image" | "video"; src: string; };
 
+// PublishProgress is one event of a background publish, streamed from
+// /api/create/:id/events.
+export type PublishProgress = {
+  seq: number;
+  platform?: string;
+  stage: 'queued' | 'uploading' | 'processing' | 'publishing' | 'done' | 'failed';
+  current?: number;
+  total?: number;
+  percent?: number;
+  error?: string;
+};
+
 export type MediaOverride = { src: string; file: File; };
 
 export type MediaOverrides = Record<TabKey, Record<string, MediaOverride>>;
@@ -42,7 +42,7 @@ func (h *Handler) SignUp(c echo.Context) error {
 	}
 	req.Password = string(hashedPassword)
 
-	if err := h.UserService.CreateUser(&req); err != nil {
+	if err := h.UserService.CreateUser(c.Request().Context(), &req); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
@@ -55,7 +55,7 @@ func (h *Handler) Login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, "Invalid input")
 	}
 
-	tokenString, err := h.UserService.LoginUser(&req)
+	tokenString, err := h.UserService.LoginUser(c.Request().Context(), &req)
 	if err != nil {
 		log.Printf("Login failed for email '%s': %v", req.Email, err)
 
@@ -130,25 +130,36 @@ func (h *Handler) AuthStatus(c echo.Context) error {
 	})
 }
 
+// plannedPlatforms are shown on the profile page before they have a
+// publisher, and always report as unlinked.
+var plannedPlatforms = []string{"artstation", "youtube"}
+
 func (h *Handler) OAuthStatus(c echo.Context) error {
     email, err := h.UserService.IsLoggedIn(c)
     if err != nil {
         return c.JSON(http.StatusUnauthorized, map[string]any{"error": "Invalid token"})
     }
 
-    status, err := h.UserService.GetOAuthLinkStatus(email)
+    status, err := h.UserService.GetOAuthLinkStatus(c.Request().Context(), email)
     if err != nil {
         return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to get OAuth status"})
     }
 
-    return c.JSON(http.StatusOK, map[string]any{
-        "twitter_linked":    status.Twitter,
-        "instagram_linked":  status.Instagram,
-        "bluesky_linked":    status.Bluesky,
-        "mastodon_linked":   status.Mastodon,
-        "artstation_linked": status.Artstation,
-        "youtube_linked":    status.Youtube,
-    })
-}
+    response := map[string]any{}
+    for _, platform := range plannedPlatforms {
+        response[platform+"_linked"] = false
+    }
+    for platform, linked := range status {
+        response[platform+"_linked"] = linked
+    }
+
+    reconnect, err := h.UserService.GetReconnectStatus(c.Request().Context(), email)
+    if err != nil {
+        return c.JSON(http.StatusInternalServerError, map[string]any{"error": "Failed to get OAuth status"})
+    }
+    for platform, needed := range reconnect {
+        response[platform+"_reconnect_needed"] = needed
+    }
 
-	
+    return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"backend/repositories/provider"
+	service_bluesky "backend/services/bluesky"
+	service_user "backend/services/user"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type BlueskyHandler struct {
+	blueskyService service_bluesky.BlueskyService
+	userService    service_user.UserService
+}
+
+func NewBlueskyHandler(blueskyService service_bluesky.BlueskyService, userService service_user.UserService) *BlueskyHandler {
+	return &BlueskyHandler{
+		blueskyService: blueskyService,
+		userService:    userService,
+	}
+}
+
+// LinkBluesky links an account with a handle and an app password. Bluesky
+// has no redirect-based OAuth flow for this, so there is no callback.
+func (h *BlueskyHandler) LinkBluesky(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Handle      string `json:"handle" form:"handle"`
+		AppPassword string `json:"app_password" form:"app_password"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+	if req.Handle == "" || req.AppPassword == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Handle and app password are required"})
+	}
+
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	handle, err := h.blueskyService.LinkAccount(c.Request().Context(), userID, req.Handle, req.AppPassword)
+	if err != nil {
+		log.Printf("[BLUESKY_LINK] --- Failed to link account: %v", err)
+		// Only a rejected login is the user's to fix.
+		if class := provider.ClassOf(err); class == provider.RateLimited || class == provider.Transient {
+			return publishError(c, err)
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to link Bluesky account, check the handle and app password"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Bluesky account linked", "handle": handle})
+}
+
+// UnlinkBluesky disconnects the user's Bluesky account.
+func (h *BlueskyHandler) UnlinkBluesky(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	if err := h.blueskyService.Unlink(c.Request().Context(), userID); err != nil {
+		log.Printf("[BLUESKY_UNLINK] --- Failed to unlink account: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlink Bluesky account"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Bluesky account unlinked"})
+}
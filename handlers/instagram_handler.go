@@ -104,7 +104,7 @@ func (h *InstagramHandler) Callback(c echo.Context) error {
 
 	log.Println("[CALLBACK_TRACE] --- Session cleaned up ---")
 
-	token, expiresIn, err := h.instagramService.GetAccessToken(code)
+	token, expiresIn, err := h.instagramService.GetAccessToken(c.Request().Context(), code)
 	if err != nil {
 		return fmt.Errorf("failed to exchange token: %v", err)
 	}
@@ -112,7 +112,7 @@ func (h *InstagramHandler) Callback(c echo.Context) error {
 
 	log.Println("[CALLBACK_TRACE] --- Access token obtained successfully ---")
 
-	err = h.userService.SaveInstagramToken(email, token, expiresIn)
+	err = h.userService.SaveInstagramToken(c.Request().Context(), email, token, expiresIn)
 	if err != nil {
 		return fmt.Errorf("failed to link instagram to your account")
 	}
@@ -122,3 +122,22 @@ func (h *InstagramHandler) Callback(c echo.Context) error {
 	successRedirectURL := fmt.Sprintf("%s?status=success&provider=instagram", profilePath)
 	return c.Redirect(http.StatusSeeOther, successRedirectURL)
 }
+
+// UnlinkInstagram disconnects the user's Instagram account.
+func (h *InstagramHandler) UnlinkInstagram(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	if err := h.instagramService.Unlink(c.Request().Context(), userID); err != nil {
+		log.Printf("[INSTAGRAM_UNLINK] --- Failed to unlink account: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlink Instagram account"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Instagram account unlinked"})
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	service_mastodon "backend/services/mastodon"
+	service_user "backend/services/user"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+type MastodonHandler struct {
+	mastodonService service_mastodon.MastodonService
+	userService     service_user.UserService
+}
+
+func NewMastodonHandler(mastodonService service_mastodon.MastodonService, userService service_user.UserService) *MastodonHandler {
+	return &MastodonHandler{
+		mastodonService: mastodonService,
+		userService:     userService,
+	}
+}
+
+// BeginMastodonLink starts the OAuth flow on the instance given in the
+// "instance" query parameter, registering an app there if needed.
+func (h *MastodonHandler) BeginMastodonLink(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+
+	state, err := generateState()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate state"})
+	}
+
+	authURL, instanceURL, err := h.mastodonService.GetAuthorizationURL(c.Request().Context(), c.QueryParam("instance"), state)
+	if err != nil {
+		log.Printf("[MASTODON_LINK] --- Failed to begin link: %v", err)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to start Mastodon login: " + err.Error()})
+	}
+
+	sess, err := session.Get("mastodon-link-session", c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create session"})
+	}
+
+	sess.Values["state"] = state
+	sess.Values["instanceURL"] = instanceURL
+	sess.Values["userEmail"] = email
+	sess.Save(c.Request(), c.Response())
+
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+func (h *MastodonHandler) Callback(c echo.Context) error {
+	const profilePath = "/profile"
+
+	sess, err := session.Get("mastodon-link-session", c)
+	if err != nil {
+		redirectURL := fmt.Sprintf("%s?status=error&provider=mastodon&code=session_expired", profilePath)
+		return c.Redirect(http.StatusSeeOther, redirectURL)
+	}
+
+	email, ok := sess.Values["userEmail"].(string)
+	if !ok {
+		redirectURL := fmt.Sprintf("%s?status=error&provider=mastodon&code=no_user_in_session", profilePath)
+		return c.Redirect(http.StatusSeeOther, redirectURL)
+	}
+	instanceURL, _ := sess.Values["instanceURL"].(string)
+	state, _ := sess.Values["state"].(string)
+
+	sess.Options.MaxAge = -1 // Clean up session
+	sess.Save(c.Request(), c.Response())
+
+	if c.QueryParam("error") != "" {
+		redirectURL := fmt.Sprintf("%s?status=denied&provider=mastodon", profilePath)
+		return c.Redirect(http.StatusSeeOther, redirectURL)
+	}
+
+	code := c.QueryParam("code")
+	if code == "" || instanceURL == "" || state == "" || c.QueryParam("state") != state {
+		redirectURL := fmt.Sprintf("%s?status=error&provider=mastodon&code=invalid_callback_params", profilePath)
+		return c.Redirect(http.StatusSeeOther, redirectURL)
+	}
+
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		redirectURL := fmt.Sprintf("%s?status=error&provider=mastodon&code=no_user_in_session", profilePath)
+		return c.Redirect(http.StatusSeeOther, redirectURL)
+	}
+
+	if _, err := h.mastodonService.CompleteLink(c.Request().Context(), userID, instanceURL, code); err != nil {
+		log.Printf("[MASTODON_LINK] --- Failed to complete link: %v", err)
+		redirectURL := fmt.Sprintf("%s?status=error&provider=mastodon&code=token_exchange_failed", profilePath)
+		return c.Redirect(http.StatusSeeOther, redirectURL)
+	}
+
+	successRedirectURL := fmt.Sprintf("%s?status=success&provider=mastodon", profilePath)
+	return c.Redirect(http.StatusSeeOther, successRedirectURL)
+}
+
+// UnlinkMastodon disconnects the user's Mastodon account.
+func (h *MastodonHandler) UnlinkMastodon(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	if err := h.mastodonService.Unlink(c.Request().Context(), userID); err != nil {
+		log.Printf("[MASTODON_UNLINK] --- Failed to unlink account: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlink Mastodon account"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Mastodon account unlinked"})
+}
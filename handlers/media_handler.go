@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"backend/models"
+	service_library "backend/services/library"
+	service_user "backend/services/user"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultLibraryLimit = 50
+	maxLibraryLimit     = 200
+)
+
+type MediaHandler struct {
+	libraryService service_library.LibraryService
+	userService    service_user.UserService
+}
+
+func NewMediaHandler(libraryService service_library.LibraryService, userService service_user.UserService) *MediaHandler {
+	return &MediaHandler{
+		libraryService: libraryService,
+		userService:    userService,
+	}
+}
+
+// UploadMedia adds the uploaded "media" files to the user's library. Files
+// the user has uploaded before are not stored again; the response returns
+// their existing assets. The status is 201 when at least one new asset was
+// created and 200 otherwise.
+func (h *MediaHandler) UploadMedia(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return err
+	}
+	files := form.File["media"]
+	if len(files) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No media uploaded"})
+	}
+
+	files, err = stripMetadata(c, files)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	status := http.StatusOK
+	assets := make([]*models.MediaAsset, 0, len(files))
+	for _, fh := range files {
+		asset, created, err := h.libraryService.Upload(c.Request().Context(), userID, fh)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to upload media: " + err.Error()})
+		}
+		if created {
+			status = http.StatusCreated
+		}
+		assets = append(assets, asset)
+	}
+	return c.JSON(status, map[string]any{"media": assets})
+}
+
+// ListMedia returns one page of the user's library, newest first.
+func (h *MediaHandler) ListMedia(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	limit, offset := defaultLibraryLimit, 0
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLibraryLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 200"})
+		}
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "offset must be a non-negative integer"})
+		}
+	}
+
+	assets, total, err := h.libraryService.List(userID, limit, offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list media"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"media":  assets,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+func (h *MediaHandler) GetMedia(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	asset, err := h.libraryService.Get(userID, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Media not found"})
+	}
+	return c.JSON(http.StatusOK, asset)
+}
+
+func (h *MediaHandler) DeleteMedia(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	if err := h.libraryService.Delete(c.Request().Context(), userID, c.Param("id")); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Failed to delete media: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Media deleted"})
+}
+
+// CreateUpload starts a direct upload of a video to object storage. The
+// browser sends the bytes with the returned presigned requests and then
+// calls CompleteUpload.
+func (h *MediaHandler) CreateUpload(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	var req struct {
+		FileName    string `json:"file_name"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	upload, err := h.libraryService.CreateUpload(c.Request().Context(), userID, req.FileName, req.ContentType, req.Size)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to start upload: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, upload)
+}
+
+// CompleteUpload confirms a finished direct upload and adds the video to
+// the library. Like UploadMedia, the status is 201 for a new asset and 200
+// when the user already had the same video.
+func (h *MediaHandler) CompleteUpload(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	var req models.CompletedUpload
+	if err := c.Bind(&req); err != nil || req.Key == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	asset, created, err := h.libraryService.CompleteUpload(c.Request().Context(), userID, &req)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to complete upload: " + err.Error()})
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	return c.JSON(status, asset)
+}
+
+// AbortUpload discards a direct upload that will not be completed.
+func (h *MediaHandler) AbortUpload(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	var req struct {
+		Key      string `json:"key"`
+		UploadID string `json:"upload_id"`
+	}
+	if err := c.Bind(&req); err != nil || req.Key == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid input"})
+	}
+
+	if err := h.libraryService.AbortUpload(c.Request().Context(), userID, req.Key, req.UploadID); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to abort upload: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Upload aborted"})
+}
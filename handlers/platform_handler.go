@@ -1,30 +1,66 @@
 package handlers
 
 import (
-	service_twitter "backend/services/twitter"
-	service_instagram "backend/services/instagram"
+	"backend/models"
+	"backend/repositories/provider"
+	service_library "backend/services/library"
+	"backend/services/media"
+	service_post "backend/services/post"
+	"backend/services/progress"
+	"backend/services/publisher"
+	service_scheduler "backend/services/scheduler"
 	service_user "backend/services/user"
+	"context"
 	"encoding/json"
-	_ "github.com/labstack/echo-contrib/session"
+	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
-	_ "io"
-	_ "log"
+	"log"
 	"mime/multipart"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Uploads larger than this are copied to temporary files when they are
+	// handed to a background publish.
+	maxDetachMemory = 32 << 20
+	// progressPing keeps idle progress streams from being closed by proxies.
+	progressPing = 15 * time.Second
 )
 
 type PlatformHandler struct {
-	twitterService   service_twitter.TwitterService
-	instagramService service_instagram.InstagramService
+	registry         *publisher.Registry
+	schedulerService service_scheduler.SchedulerService
 	userService      service_user.UserService
+	postService      service_post.PostService
+	libraryService   service_library.LibraryService
+	tracker          *progress.Tracker
 }
 
-func NewPlatformHandler(twitterService service_twitter.TwitterService, instagramService service_instagram.InstagramService, userService service_user.UserService) *PlatformHandler {
+func NewPlatformHandler(registry *publisher.Registry, schedulerService service_scheduler.SchedulerService, userService service_user.UserService, postService service_post.PostService, libraryService service_library.LibraryService, tracker *progress.Tracker) *PlatformHandler {
 	return &PlatformHandler{
-		twitterService:   twitterService,
-		instagramService: instagramService,
+		registry:         registry,
+		schedulerService: schedulerService,
 		userService:      userService,
+		postService:      postService,
+		libraryService:   libraryService,
+		tracker:          tracker,
+	}
+}
+
+// ListPlatforms describes every registered platform and what it accepts.
+func (h *PlatformHandler) ListPlatforms(c echo.Context) error {
+	platforms := []map[string]any{}
+	for _, p := range h.registry.Publishers() {
+		platforms = append(platforms, map[string]any{
+			"platform":     p.Platform(),
+			"capabilities": p.Capabilities(),
+		})
 	}
+	return c.JSON(http.StatusOK, map[string]any{"platforms": platforms})
 }
 
 func (h *PlatformHandler) PostToPlatform(c echo.Context) error {
@@ -38,6 +74,10 @@ func (h *PlatformHandler) PostToPlatform(c echo.Context) error {
 	if platform == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Platform not specified"})
 	}
+	p, ok := h.registry.Get(platform)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported platform"})
+	}
 
 	platformDataJSON := c.FormValue("platformData")
 
@@ -48,61 +88,527 @@ func (h *PlatformHandler) PostToPlatform(c echo.Context) error {
 	}
 	files := form.File["media"]
 
-	switch platform {
-	case "twitter":
-		return h.postToTwitter(c, email, platformDataJSON, files)
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
 
-	case "instagram":
-		return h.postToInstagram(c, email, platformDataJSON, files)
-		// OTHER PLATFORMS COMING SOON HEHEHEHEE
+	files, libraryForm, err := h.withLibraryMedia(c, userID, files)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if libraryForm != nil {
+		defer libraryForm.RemoveAll()
+	}
+	files, err = stripMetadata(c, files)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
 
-	default:
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unsupported platform"})
+	req := &publisher.Request{
+		UserID:       userID,
+		PlatformData: json.RawMessage(platformDataJSON),
+		Files:        files,
+	}
+
+	var transforms []media.Normalization
+	if normalize := c.FormValue("normalize"); normalize != "" {
+		fit, err := media.ParseFit(normalize)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		transforms, err = publisher.NormalizeMedia(p, req, fit)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	if err := p.Validate(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	// A scheduled_at in the future queues the post for the scheduler worker
+	// instead of publishing it within this request.
+	if scheduledAtValue := c.FormValue("scheduled_at"); scheduledAtValue != "" {
+		scheduledAt, err := time.Parse(time.RFC3339, scheduledAtValue)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "scheduled_at must be an RFC3339 timestamp"})
+		}
+		if scheduledAt.After(time.Now()) {
+			return h.schedulePost(c, userID, platform, platformDataJSON, req.Files, scheduledAt, transforms)
+		}
+	}
+
+	// A post that would run into the platform's rate limits is turned away
+	// before it is handed to the background.
+	if err := publisher.CheckRateLimit(p, req); err != nil {
+		return publishError(c, err)
+	}
+
+	return h.startPublish(c, userID, platform, req, transforms)
+}
+
+// startPublish publishes the post in the background and responds with the
+// job ID right away. Its progress is streamed by StreamProgress.
+func (h *PlatformHandler) startPublish(c echo.Context, userID string, platform string, req *publisher.Request, transforms []media.Normalization) error {
+	files, form, err := media.DetachFiles(req.Files, maxDetachMemory)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	req.Files = files
+
+	job, err := h.tracker.Start(c.Request().Context(), userID, platform, func(ctx context.Context) (*publisher.Result, error) {
+		if form != nil {
+			defer form.RemoveAll()
+		}
+		outcome := h.postService.Publish(ctx, userID, len(files), []publisher.Target{{Platform: platform, Request: req}})[0]
+		if outcome.Err != nil {
+			return nil, outcome.Err
+		}
+		outcome.Result.MediaTransforms = transforms
+		return outcome.Result, nil
+	})
+	if err != nil {
+		if form != nil {
+			form.RemoveAll()
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	body := map[string]any{
+		"message": "Publishing started",
+		"job_id":  job.ID,
+		"events":  "/api/create/" + job.ID + "/events",
+	}
+	if len(transforms) > 0 {
+		body["media_transforms"] = transforms
+	}
+	return c.JSON(http.StatusAccepted, body)
+}
+
+// StreamProgress streams the progress of a background publish as
+// server-sent events, one JSON progress.Event per message, until the job is
+// done or has failed. A client that reconnects with Last-Event-ID only gets
+// the events it missed.
+func (h *PlatformHandler) StreamProgress(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	job, ok := h.tracker.Get(userID, c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Job not found"})
+	}
+	after, _ := strconv.Atoi(c.Request().Header.Get("Last-Event-ID"))
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ping := time.NewTicker(progressPing)
+	defer ping.Stop()
+
+	for {
+		events, finished, changed := job.Events(after)
+		for _, event := range events {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.Seq, data); err != nil {
+				return nil
+			}
+			after = event.Seq
+		}
+		w.Flush()
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-changed:
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+// publishTarget is one entry of the "targets" form value of PostToPlatforms.
+// Media holds indexes into the uploaded files; when omitted, all files are
+// sent to the platform. Normalize overrides the "normalize" form value for
+// this target.
+type publishTarget struct {
+	Platform     string          `json:"platform"`
+	PlatformData json.RawMessage `json:"platformData"`
+	Media        []int           `json:"media,omitempty"`
+	Normalize    *string         `json:"normalize,omitempty"`
+}
+
+type targetResult struct {
+	Platform        string                `json:"platform"`
+	Status          string                `json:"status"`
+	JobID           string                `json:"job_id,omitempty"`
+	Events          string                `json:"events,omitempty"`
+	Job             *models.ScheduledJob  `json:"job,omitempty"`
+	Error           string                `json:"error,omitempty"`
+	ErrorClass      provider.Class        `json:"error_class,omitempty"`
+	RetryAt         *time.Time            `json:"retry_at,omitempty"`
+	MediaTransforms []media.Normalization `json:"media_transforms,omitempty"`
+}
+
+func (r *targetResult) fail(err error) {
+	r.Status = "failed"
+	r.Error = err.Error()
+	if providerErr, ok := provider.As(err); ok {
+		r.ErrorClass = providerErr.Class
+		if !providerErr.RetryAt.IsZero() {
+			r.RetryAt = &providerErr.RetryAt
+		}
+	}
+}
+
+// PostToPlatforms publishes one post to several platforms at once. The
+// multipart upload is parsed once and shared by every target, and each
+// platform gets its own entry in the response so a failure on one does not
+// hide the others. Every target is validated and checked against its rate
+// limits before the post is recorded; the targets that pass are then
+// published in the background, each as its own job whose progress is
+// streamed by StreamProgress.
+func (h *PlatformHandler) PostToPlatforms(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+
+	var targets []publishTarget
+	if err := json.Unmarshal([]byte(c.FormValue("targets")), &targets); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format for targets"})
+	}
+	if len(targets) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "No platforms specified"})
+	}
+
+	var scheduledAt time.Time
+	if scheduledAtValue := c.FormValue("scheduled_at"); scheduledAtValue != "" {
+		scheduledAt, err = time.Parse(time.RFC3339, scheduledAtValue)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "scheduled_at must be an RFC3339 timestamp"})
+		}
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return err
+	}
+	files := form.File["media"]
+
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	files, libraryForm, err := h.withLibraryMedia(c, userID, files)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if libraryForm != nil {
+		defer libraryForm.RemoveAll()
+	}
+	files, err = stripMetadata(c, files)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	results := make([]targetResult, len(targets))
+	pending := []publisher.Target{}
+	pendingIdx := []int{}
+	seen := map[string]bool{}
+
+	for idx, target := range targets {
+		results[idx] = targetResult{Platform: target.Platform, Status: "failed"}
+
+		if seen[target.Platform] {
+			results[idx].Error = "Platform specified more than once"
+			continue
+		}
+		seen[target.Platform] = true
+
+		p, ok := h.registry.Get(target.Platform)
+		if !ok {
+			results[idx].Error = "Unsupported platform"
+			continue
+		}
+
+		targetFiles, err := selectFiles(files, target.Media)
+		if err != nil {
+			results[idx].Error = err.Error()
+			continue
+		}
+
+		req := &publisher.Request{
+			UserID:       userID,
+			PlatformData: target.PlatformData,
+			Files:        targetFiles,
+		}
+
+		normalize := c.FormValue("normalize")
+		if target.Normalize != nil {
+			normalize = *target.Normalize
+		}
+		if normalize != "" {
+			fit, err := media.ParseFit(normalize)
+			if err != nil {
+				results[idx].Error = err.Error()
+				continue
+			}
+			results[idx].MediaTransforms, err = publisher.NormalizeMedia(p, req, fit)
+			if err != nil {
+				results[idx].Error = err.Error()
+				continue
+			}
+		}
+
+		if err := p.Validate(req); err != nil {
+			results[idx].Error = err.Error()
+			continue
+		}
+
+		if scheduledAt.After(time.Now()) {
+			job, err := h.schedulerService.Schedule(c.Request().Context(), userID, target.Platform, string(target.PlatformData), req.Files, scheduledAt)
+			if err != nil {
+				results[idx].Error = "Failed to schedule post: " + err.Error()
+				continue
+			}
+			results[idx].Status = "scheduled"
+			results[idx].Job = job
+			continue
+		}
+
+		// A target that would run into the platform's rate limits is turned
+		// away before the post is recorded, like a target that is invalid.
+		if err := publisher.CheckRateLimit(p, req); err != nil {
+			results[idx].fail(err)
+			continue
+		}
+
+		pending = append(pending, publisher.Target{Platform: target.Platform, Request: req})
+		pendingIdx = append(pendingIdx, idx)
+	}
+
+	if len(pending) > 0 {
+		if err := h.startPublishAll(c, userID, len(files), pending, pendingIdx, results); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+
+	status := http.StatusAccepted
+	for _, result := range results {
+		if result.Status == "failed" {
+			status = http.StatusMultiStatus
+			break
+		}
 	}
+	return c.JSON(status, map[string]any{"results": results})
 }
 
-func (h *PlatformHandler) postToTwitter(c echo.Context, email string, platformData string, files []*multipart.FileHeader) error {
-	accessToken, accessSecret, err := h.userService.GetTwitterToken(email)
-	if err != nil || accessToken == "" || accessSecret == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Twitter account not linked or tokens are missing"})
+// startPublishAll records the post with one delivery per target and starts
+// a background job for each target, filling in the entries of results at
+// pendingIdx. The uploads are detached once and shared by the jobs, and
+// their temporary files are removed when the last job is done.
+func (h *PlatformHandler) startPublishAll(c echo.Context, userID string, mediaCount int, pending []publisher.Target, pendingIdx []int, results []targetResult) error {
+	detached := map[*multipart.FileHeader]*multipart.FileHeader{}
+	var uploads []*multipart.FileHeader
+	for _, target := range pending {
+		for _, fh := range target.Request.Files {
+			if _, ok := detached[fh]; !ok {
+				detached[fh] = nil
+				uploads = append(uploads, fh)
+			}
+		}
 	}
-	var twitterData struct {
-		Content string `json:"content"`
+	files, form, err := media.DetachFiles(uploads, maxDetachMemory)
+	if err != nil {
+		return err
 	}
-	if err := json.Unmarshal([]byte(platformData), &twitterData); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format for platformData"})
+	for idx, fh := range uploads {
+		detached[fh] = files[idx]
 	}
-	if twitterData.Content == "" && len(files) == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A tweet must have either text content or media."})
+	for _, target := range pending {
+		targetFiles := make([]*multipart.FileHeader, len(target.Request.Files))
+		for idx, fh := range target.Request.Files {
+			targetFiles[idx] = detached[fh]
+		}
+		target.Request.Files = targetFiles
 	}
 
-	err = h.twitterService.PostTweet(accessToken, accessSecret, twitterData.Content, files)
+	var wg sync.WaitGroup
+	wg.Add(len(pending))
+	go func() {
+		wg.Wait()
+		if form != nil {
+			form.RemoveAll()
+		}
+	}()
+
+	deliveries, err := h.postService.Record(userID, mediaCount, pending)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to post tweet: " + err.Error()})
+		log.Printf("[POST_TO_PLATFORMS] --- %v", err)
 	}
-	return c.JSON(http.StatusOK, map[string]string{"message": "Tweet scheduled successfully!"})
+
+	for i, target := range pending {
+		idx := pendingIdx[i]
+		if err != nil {
+			results[idx].fail(err)
+			wg.Done()
+			continue
+		}
+
+		delivery, transforms := deliveries[i], results[idx].MediaTransforms
+		job, startErr := h.tracker.Start(c.Request().Context(), userID, target.Platform, func(ctx context.Context) (*publisher.Result, error) {
+			defer wg.Done()
+			outcome := h.postService.Deliver(ctx, delivery, target)
+			if outcome.Err != nil {
+				return nil, outcome.Err
+			}
+			outcome.Result.MediaTransforms = transforms
+			return outcome.Result, nil
+		})
+		if startErr != nil {
+			results[idx].fail(startErr)
+			wg.Done()
+			continue
+		}
+		results[idx].Status = "publishing"
+		results[idx].JobID = job.ID
+		results[idx].Events = "/api/create/" + job.ID + "/events"
+	}
+	return nil
 }
 
-func (h *PlatformHandler) postToInstagram(c echo.Context, email string, platformData string, files []*multipart.FileHeader) error {
-	accessToken, instagramID, err := h.userService.GetInstagramCredentials(email)
-	if err != nil || accessToken == "" || instagramID == "" {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Instagram account not linked or tokens are missing"})
+// withLibraryMedia appends the media library assets listed in the
+// "media_ids" form value, a JSON array of asset IDs, to the uploaded files.
+// The assets are spooled like uploads; the returned form must be cleaned up
+// with RemoveAll once the request is done.
+func (h *PlatformHandler) withLibraryMedia(c echo.Context, userID string, files []*multipart.FileHeader) ([]*multipart.FileHeader, *multipart.Form, error) {
+	value := c.FormValue("media_ids")
+	if value == "" {
+		return files, nil, nil
+	}
+	var assetIDs []string
+	if err := json.Unmarshal([]byte(value), &assetIDs); err != nil {
+		return nil, nil, fmt.Errorf("Invalid format for media_ids")
 	}
-	var instagramData struct {
-		Caption string `json:"caption"`
-		// THINK OF MORE PARAMS LATER BECAUSE I AM SLEEP DEPRIVED RIGHT NOW
+	assets, form, err := h.libraryService.Files(c.Request().Context(), userID, assetIDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to load media: %w", err)
+	}
+	return append(files, assets...), form, nil
+}
+
+// stripMetadata removes EXIF, XMP and IPTC data from the uploaded images
+// so that GPS coordinates and device details are not published. Posts can
+// opt out with keep_metadata=true.
+func stripMetadata(c echo.Context, files []*multipart.FileHeader) ([]*multipart.FileHeader, error) {
+	if c.FormValue("keep_metadata") == "true" {
+		return files, nil
 	}
-	if err := json.Unmarshal([]byte(platformData), &instagramData); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format for platformData"})
+	return media.StripAll(files)
+}
+
+// selectFiles picks the uploaded files a target refers to by index.
+func selectFiles(files []*multipart.FileHeader, indexes []int) ([]*multipart.FileHeader, error) {
+	if indexes == nil {
+		return files, nil
 	}
-	if instagramData.Caption == "" && len(files) == 0 {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "An Instagram post must have either a caption or media."})
+	selected := make([]*multipart.FileHeader, 0, len(indexes))
+	for _, idx := range indexes {
+		if idx < 0 || idx >= len(files) {
+			return nil, fmt.Errorf("Media index %d is out of range", idx)
+		}
+		selected = append(selected, files[idx])
 	}
+	return selected, nil
+}
 
-	mediaURL, err := h.instagramService.PostToInstagram(accessToken, instagramID, instagramData.Caption, files)
+func (h *PlatformHandler) schedulePost(c echo.Context, userID string, platform string, platformData string, files []*multipart.FileHeader, scheduledAt time.Time, transforms []media.Normalization) error {
+	job, err := h.schedulerService.Schedule(c.Request().Context(), userID, platform, platformData, files, scheduledAt)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to post to Instagram: " + err.Error()})
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to schedule post: " + err.Error()})
+	}
+	body := map[string]any{"message": "Post scheduled successfully!", "job": job}
+	if len(transforms) > 0 {
+		body["media_transforms"] = transforms
+	}
+	return c.JSON(http.StatusAccepted, body)
+}
+
+// publishError responds with the status and body for an error returned by
+// a Publisher or a platform call. Errors the platform said when to retry
+// after also get a Retry-After header.
+func publishError(c echo.Context, err error) error {
+	if providerErr, ok := provider.As(err); ok && !providerErr.RetryAt.IsZero() {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(providerErr.RetryAfter().Seconds())))
+	}
+	return c.JSON(publishErrorStatus(err), publishErrorBody(err))
+}
+
+// publishErrorBody is the JSON body of a failed publish. When part of a
+// multi-part post went out before the failure, those parts are listed so
+// the client knows what is already live. Errors from a platform carry
+// their class and, when known, when to try again.
+func publishErrorBody(err error) map[string]any {
+	body := map[string]any{"error": err.Error()}
+	var partialErr *publisher.PartialError
+	if errors.As(err, &partialErr) {
+		body["published"] = partialErr.Published
+	}
+	if providerErr, ok := provider.As(err); ok {
+		body["error_class"] = providerErr.Class
+		if !providerErr.RetryAt.IsZero() {
+			body["retry_at"] = providerErr.RetryAt
+		}
+	}
+	return body
+}
+
+// publishErrorStatus maps an error returned by a Publisher to an HTTP status.
+func publishErrorStatus(err error) int {
+	var validationErr *publisher.ValidationError
+	switch {
+	case errors.Is(err, publisher.ErrNotLinked):
+		return http.StatusUnauthorized
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
 	}
-	return c.JSON(http.StatusOK, map[string]string{"message": "Instagram post scheduled successfully!", "mediaURL": mediaURL})
 
+	switch provider.ClassOf(err) {
+	case provider.AuthRevoked:
+		// The account has to be linked again, like one that never was.
+		return http.StatusUnauthorized
+	case provider.RateLimited, provider.QuotaExceeded:
+		return http.StatusTooManyRequests
+	case provider.InvalidContent:
+		return http.StatusUnprocessableEntity
+	case provider.Transient:
+		return http.StatusServiceUnavailable
+	case provider.Unknown:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	repo_post "backend/repositories/post"
+	service_post "backend/services/post"
+	service_user "backend/services/user"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type PostHandler struct {
+	postService service_post.PostService
+	userService service_user.UserService
+}
+
+func NewPostHandler(postService service_post.PostService, userService service_user.UserService) *PostHandler {
+	return &PostHandler{
+		postService: postService,
+		userService: userService,
+	}
+}
+
+// ListPosts returns the current user's post history, one entry per platform
+// a post was sent to, newest first. Supports filtering by platform, status
+// and an RFC3339 since/until range, paginated with limit and offset.
+func (h *PostHandler) ListPosts(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	filter := repo_post.DeliveryFilter{
+		Platform: c.QueryParam("platform"),
+		Status:   c.QueryParam("status"),
+		Limit:    defaultHistoryLimit,
+	}
+
+	if value := c.QueryParam("since"); value != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, value); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "since must be an RFC3339 timestamp"})
+		}
+	}
+	if value := c.QueryParam("until"); value != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, value); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "until must be an RFC3339 timestamp"})
+		}
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxHistoryLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 100"})
+		}
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil || filter.Offset < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "offset must be a non-negative integer"})
+		}
+	}
+
+	deliveries, total, err := h.postService.ListDeliveries(userID, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list posts"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"deliveries": deliveries,
+		"total":      total,
+		"limit":      filter.Limit,
+		"offset":     filter.Offset,
+	})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"backend/repositories/ratelimit"
+	service_user "backend/services/user"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RateLimitHandler struct {
+	limits      *ratelimit.Tracker
+	userService service_user.UserService
+}
+
+func NewRateLimitHandler(limits *ratelimit.Tracker, userService service_user.UserService) *RateLimitHandler {
+	return &RateLimitHandler{
+		limits:      limits,
+		userService: userService,
+	}
+}
+
+// ListRateLimits returns the rate limits the platforms last reported for
+// the current user, along with the ones shared by the whole app. Each
+// quota says whether a publish would be held back by it now.
+func (h *RateLimitHandler) ListRateLimits(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{"rate_limits": h.limits.List(userID)})
+}
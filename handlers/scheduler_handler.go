@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	service_scheduler "backend/services/scheduler"
+	service_user "backend/services/user"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type SchedulerHandler struct {
+	schedulerService service_scheduler.SchedulerService
+	userService      service_user.UserService
+}
+
+func NewSchedulerHandler(schedulerService service_scheduler.SchedulerService, userService service_user.UserService) *SchedulerHandler {
+	return &SchedulerHandler{
+		schedulerService: schedulerService,
+		userService:      userService,
+	}
+}
+
+// ListJobs returns the current user's scheduled posts, newest first.
+func (h *SchedulerHandler) ListJobs(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	jobs, err := h.schedulerService.ListJobs(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list scheduled posts"})
+	}
+	return c.JSON(http.StatusOK, map[string]any{"jobs": jobs})
+}
+
+// CancelJob cancels a scheduled post that has not started publishing yet.
+func (h *SchedulerHandler) CancelJob(c echo.Context) error {
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	if err := h.schedulerService.CancelJob(c.Request().Context(), userID, c.Param("id")); err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Failed to cancel scheduled post: " + err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Scheduled post cancelled"})
+}
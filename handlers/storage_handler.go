@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	repo_storage "backend/repositories/storage"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StorageHandler serves a LocalStore the way a public bucket would: anyone
+// can read objects, and writes need a presigned URL.
+type StorageHandler struct {
+	store *repo_storage.LocalStore
+}
+
+func NewStorageHandler(store *repo_storage.LocalStore) *StorageHandler {
+	return &StorageHandler{
+		store: store,
+	}
+}
+
+func (h *StorageHandler) GetObject(c echo.Context) error {
+	key, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	filePath, err := h.store.FilePath(key)
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	return c.File(filePath)
+}
+
+// PutObject stores the body of a presigned upload and returns its ETag,
+// which multipart uploads pass back when they are completed.
+func (h *StorageHandler) PutObject(c echo.Context) error {
+	key, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid key"})
+	}
+
+	req := c.Request()
+	etag, err := h.store.PutSigned(req.Context(), key, c.QueryParams(), req.Header.Get("Content-Type"), req.ContentLength, req.Body)
+	switch {
+	case errors.Is(err, repo_storage.ErrInvalidSignature):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, repo_storage.ErrInvalidUpload):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	c.Response().Header().Set("ETag", etag)
+	return c.NoContent(http.StatusOK)
+}
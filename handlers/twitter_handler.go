@@ -109,7 +109,7 @@ func (h *TwitterHandler) Callback(c echo.Context) error {
 
 	// 7. Call the user service to update the database
 	log.Println("[CALLBACK_TRACE] Attempting Step 7: Calling LinkTwitterAccount in user service...")
-	err = h.userService.SaveTwitterToken(email, accessToken, accessSecret)
+	err = h.userService.SaveTwitterToken(c.Request().Context(), email, accessToken, accessSecret)
 	if err != nil {
 		log.Printf("[CALLBACK_TRACE] FATAL: Step 7 failed. Error from LinkTwitterAccount: %v", err)
 		redirectURL := fmt.Sprintf("%s?status=error&provider=twitter&code=db_link_failed", profilePath)
@@ -122,3 +122,22 @@ func (h *TwitterHandler) Callback(c echo.Context) error {
 	successRedirectURL := fmt.Sprintf("%s?status=success&provider=twitter", profilePath)
 	return c.Redirect(http.StatusSeeOther, successRedirectURL)
 }
+
+// UnlinkTwitter disconnects the user's Twitter account.
+func (h *TwitterHandler) UnlinkTwitter(c echo.Context) error {
+	// This endpoint MUST be protected by JWTMiddleware.
+	email, err := h.userService.IsLoggedIn(c)
+	if err != nil {
+		return err
+	}
+	userID, err := h.userService.GetUserID(c.Request().Context(), email)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+	}
+
+	if err := h.twitterService.Unlink(c.Request().Context(), userID); err != nil {
+		log.Printf("[TWITTER_UNLINK] --- Failed to unlink account: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unlink Twitter account"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Twitter account unlinked"})
+}
@@ -3,34 +3,49 @@ package main
 import (
 	"context"
 	"embed"
+	"flag"
 	"fmt"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"backend/handlers"
 	"backend/middlewares"
 	repo_cloudflare "backend/repositories/cloudflare"
-	repo_instagram "backend/repositories/instagram"
+	"backend/repositories/encryption"
+	repo_idempotency "backend/repositories/idempotency"
+	repo_job "backend/repositories/job"
+	repo_media "backend/repositories/media"
+	repo_post "backend/repositories/post"
+	"backend/repositories/ratelimit"
+	repo_staging "backend/repositories/staging"
+	repo_storage "backend/repositories/storage"
 	repo_supabase "backend/repositories/supabase"
-	repo_twitter "backend/repositories/twitter"
 	repo_user "backend/repositories/user"
 	"backend/routes"
+	service_idempotency "backend/services/idempotency"
 	service_instagram "backend/services/instagram"
-	service_twitter "backend/services/twitter"
+	service_library "backend/services/library"
+	service_post "backend/services/post"
+	"backend/services/progress"
+	"backend/services/publisher"
+	service_scheduler "backend/services/scheduler"
+	service_staging "backend/services/staging"
 	service_user "backend/services/user"
 
-	"github.com/dghubble/oauth1"
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"golang.org/x/oauth2"
 )
 
 type EnvConfig struct {
@@ -42,6 +57,8 @@ type EnvConfig struct {
 	InstagramClientSecret string
 	InstagramRedirectURL  string
 
+	MastodonRedirectURL string
+
 	SupabaseURL string
 	SupabaseKey string
 
@@ -50,18 +67,29 @@ type EnvConfig struct {
 	CloudflareToken             string
 	CloudflareS3AccessKeyID     string
 	CloudflareS3SecretAccessKey string
+	CloudflareBucket            string
+	CloudflarePublicURL         string
+
+	// StorageBackend is "r2" (the default) or "local".
+	StorageBackend   string
+	StorageDir       string
+	StoragePublicURL string
+
+	TokenEncryptionKeys  string
+	TokenEncryptionKeyID string
 
 	JWTSecret     string
 	SessionSecret string
 	AppEnv        string
+
+	// IdempotencyKeyTTL is how long a response is replayed for repeats of
+	// a publish request with the same Idempotency-Key.
+	IdempotencyKeyTTL time.Duration
 }
 
 //go:embed all:frontend/dist
 var embeddedFrontend embed.FS
 
-const TWITTERCALLBACKPATH = "/twitter/link/callback"
-const INSTAGRAMCALLBACKPATH = "/instagram/link/callback"
-
 func loadEnv() EnvConfig {
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found, using environment variables")
@@ -75,6 +103,8 @@ func loadEnv() EnvConfig {
 	instagramClientSecret := os.Getenv("INSTAGRAM_APP_SECRET")
 	instagramRedirectURL := os.Getenv("INSTAGRAM_REDIRECT_URL")
 
+	mastodonRedirectURL := os.Getenv("MASTODON_REDIRECT_URL")
+
 	supabaseURL := os.Getenv("SUPABASE_URL")
 	supabaseKey := os.Getenv("SUPABASE_KEY")
 
@@ -83,11 +113,25 @@ func loadEnv() EnvConfig {
 	cloudflareToken := os.Getenv("CLOUDFLARE_TOKEN")
 	cloudflareS3AccessKeyID := os.Getenv("CLOUDFLARE_S3_ACCESS_KEY_ID")
 	cloudflareS3SecretAccessKey := os.Getenv("CLOUDFLARE_S3_SECRET_ACCESS_KEY")
+	cloudflareBucket := getenvDefault("CLOUDFLARE_BUCKET", "mediabucket")
+	cloudflarePublicURL := getenvDefault("CLOUDFLARE_PUBLIC_URL", "https://pub-16ef3834c60f45cca08f78c4653d8f49.r2.dev/")
+
+	storageBackend := getenvDefault("STORAGE_BACKEND", "r2")
+	storageDir := getenvDefault("STORAGE_DIR", "storage")
+	storagePublicURL := getenvDefault("STORAGE_PUBLIC_URL", "http://localhost:8080")
+
+	tokenEncryptionKeys := os.Getenv("TOKEN_ENCRYPTION_KEYS")
+	tokenEncryptionKeyID := os.Getenv("TOKEN_ENCRYPTION_KEY_ID")
 
 	sessionSecret := os.Getenv("SESSION_SECRET")
 	jwtSecret := []byte(os.Getenv("JWT_SECRET"))
 	appEnv := os.Getenv("APP_ENV")
 
+	idempotencyKeyTTL, err := time.ParseDuration(getenvDefault("IDEMPOTENCY_KEY_TTL", "24h"))
+	if err != nil {
+		log.Fatal("Invalid IDEMPOTENCY_KEY_TTL:", err)
+	}
+
 	envConfig := EnvConfig{
 		TwitterConsumerKey:    twitterConsumerKey,
 		TwitterConsumerSecret: twitterConsumerSecret,
@@ -97,6 +141,8 @@ func loadEnv() EnvConfig {
 		InstagramClientSecret: instagramClientSecret,
 		InstagramRedirectURL:  instagramRedirectURL,
 
+		MastodonRedirectURL: mastodonRedirectURL,
+
 		SupabaseURL: supabaseURL,
 		SupabaseKey: supabaseKey,
 
@@ -105,20 +151,66 @@ func loadEnv() EnvConfig {
 		CloudflareToken:             cloudflareToken,
 		CloudflareS3AccessKeyID:     cloudflareS3AccessKeyID,
 		CloudflareS3SecretAccessKey: cloudflareS3SecretAccessKey,
+		CloudflareBucket:            cloudflareBucket,
+		CloudflarePublicURL:         cloudflarePublicURL,
+
+		StorageBackend:   storageBackend,
+		StorageDir:       storageDir,
+		StoragePublicURL: storagePublicURL,
+
+		TokenEncryptionKeys:  tokenEncryptionKeys,
+		TokenEncryptionKeyID: tokenEncryptionKeyID,
 
 		JWTSecret:     string(jwtSecret),
 		SessionSecret: sessionSecret,
 		AppEnv:        appEnv,
+
+		IdempotencyKeyTTL: idempotencyKeyTTL,
 	}
 
 	return envConfig
 }
 
+func getenvDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// newObjectStore opens the configured storage backend. The local store is
+// also returned on its own, since the server has to serve it.
+func newObjectStore(envConfig EnvConfig) (repo_storage.ObjectStore, *repo_storage.LocalStore, error) {
+	switch envConfig.StorageBackend {
+	case "r2":
+		store, err := repo_cloudflare.NewCloudflareRepository(
+			context.Background(),
+			envConfig.CloudflareAccountID,
+			envConfig.CloudflareS3APIURL,
+			envConfig.CloudflareToken,
+			envConfig.CloudflareS3AccessKeyID,
+			envConfig.CloudflareS3SecretAccessKey,
+			envConfig.CloudflareBucket,
+			envConfig.CloudflarePublicURL,
+		)
+		return store, nil, err
+	case "local":
+		store, err := repo_storage.NewLocalStore(envConfig.StorageDir, envConfig.StoragePublicURL, []byte(envConfig.JWTSecret))
+		return store, store, err
+	}
+	return nil, nil, fmt.Errorf("unknown storage backend %q, expected r2 or local", envConfig.StorageBackend)
+}
+
 func setupServer(envConfig EnvConfig,
+	registry *publisher.Registry,
 	userHandler *handlers.Handler,
-	twitterHandler *handlers.TwitterHandler,
-	instagramHandler *handlers.InstagramHandler,
-	platformHandler *handlers.PlatformHandler) *echo.Echo {
+	platformHandler *handlers.PlatformHandler,
+	schedulerHandler *handlers.SchedulerHandler,
+	postHandler *handlers.PostHandler,
+	mediaHandler *handlers.MediaHandler,
+	rateLimitHandler *handlers.RateLimitHandler,
+	idempotency echo.MiddlewareFunc,
+	localStore *repo_storage.LocalStore) *echo.Echo {
 
 	e := echo.New()
 
@@ -133,12 +225,18 @@ func setupServer(envConfig EnvConfig,
 
 	apiGroup := e.Group("/api")
 	apiGroup.Use(middlewares.JWTMiddleware([]byte(envConfig.JWTSecret), []string{}))
-	routes.RegisterPlatformRoute(apiGroup, platformHandler)
-	routes.RegisterTwitterRoutes(apiGroup, twitterHandler)
-	routes.RegisterInstagramRoutes(apiGroup, instagramHandler)
-
-	e.GET(TWITTERCALLBACKPATH, twitterHandler.Callback)
-	e.GET(INSTAGRAMCALLBACKPATH, instagramHandler.Callback)
+	routes.RegisterPlatformRoute(apiGroup, platformHandler, idempotency)
+	routes.RegisterJobRoutes(apiGroup, schedulerHandler)
+	routes.RegisterPostRoutes(apiGroup, postHandler)
+	routes.RegisterMediaRoutes(apiGroup, mediaHandler)
+	routes.RegisterRateLimitRoutes(apiGroup, rateLimitHandler)
+	routes.RegisterLinkRoutes(e, apiGroup, registry)
+
+	backendPaths := registry.CallbackPaths()
+	if localStore != nil {
+		routes.RegisterStorageRoutes(e, handlers.NewStorageHandler(localStore))
+		backendPaths = append(backendPaths, repo_storage.RoutePath)
+	}
 
 	if envConfig.AppEnv == "production" {
 		// Serve the frontend from the embedded filesystem.
@@ -151,7 +249,7 @@ func setupServer(envConfig EnvConfig,
 			Skipper: func(c echo.Context) bool {
 				path := c.Request().URL.Path
 				// Skip static file serving for API routes
-				return strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/auth") || strings.HasPrefix(path, TWITTERCALLBACKPATH) || strings.HasPrefix(path, INSTAGRAMCALLBACKPATH)
+				return isBackendPath(path, backendPaths)
 			},
 			Filesystem: http.FS(staticFilesFS),
 			HTML5:      true, // Crucial for SPAs
@@ -167,7 +265,7 @@ func setupServer(envConfig EnvConfig,
 			Skipper: func(c echo.Context) bool {
 				path := c.Request().URL.Path
 				// Skip proxying for API routes, let them be handled by Echo
-				return strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/auth") || strings.HasPrefix(path, TWITTERCALLBACKPATH) || strings.HasPrefix(path, INSTAGRAMCALLBACKPATH)
+				return isBackendPath(path, backendPaths)
 			},
 			Balancer: middleware.NewRoundRobinBalancer([]*middleware.ProxyTarget{
 				{
@@ -180,70 +278,114 @@ func setupServer(envConfig EnvConfig,
 	return e
 }
 
-func main() {
-	envConfig := loadEnv()
-
-	twitterEndpoint := oauth1.Endpoint{
-		RequestTokenURL: "https://api.twitter.com/oauth/request_token",
-		AuthorizeURL:    "https://api.twitter.com/oauth/authorize",
-		AccessTokenURL:  "https://api.twitter.com/oauth/access_token",
+// isBackendPath reports whether a request should be handled by Echo rather
+// than the frontend.
+func isBackendPath(path string, backendPaths []string) bool {
+	if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/auth") {
+		return true
 	}
-
-	twitterConfig := &oauth1.Config{
-		ConsumerKey:    envConfig.TwitterConsumerKey,
-		ConsumerSecret: envConfig.TwitterConsumerSecret,
-		CallbackURL:    envConfig.TwitterCallbackURL,
-		Endpoint:       twitterEndpoint,
+	for _, backendPath := range backendPaths {
+		if strings.HasPrefix(path, backendPath) {
+			return true
+		}
 	}
+	return false
+}
 
-	instagramEndpoint := oauth2.Endpoint{
-		AuthURL:  fmt.Sprintf("https://www.instagram.com/oauth/authorize?client_id=%s&redirect_uri=%s&response_type=code&scope=instagram_business_basic,instagram_business_content_publish&force_reauth=true", envConfig.InstagramClientID, envConfig.InstagramRedirectURL),
-		TokenURL: "https://api.instagram.com/oauth/access_token",
-	}
+func main() {
+	reencrypt := flag.Bool("reencrypt-tokens", false, "encrypt all stored platform credentials with the current key and exit")
+	flag.Parse()
 
-	instagramConfig := &oauth2.Config{
-		ClientID:     envConfig.InstagramClientID,
-		ClientSecret: envConfig.InstagramClientSecret,
-		RedirectURL:  envConfig.InstagramRedirectURL,
-		Scopes:       []string{"instagram_business_basic,instagram_content_publish"},
-		Endpoint:     instagramEndpoint,
-	}
+	envConfig := loadEnv()
 
 	// --- Services and Handlers ---
 	supabaseRepository := repo_supabase.NewSupabaseRepository(envConfig.SupabaseURL, envConfig.SupabaseKey)
-	cloudflareRepository, err := repo_cloudflare.NewCloudflareRepository(
-		context.Background(),
-		envConfig.CloudflareAccountID,
-		envConfig.CloudflareS3APIURL,
-		envConfig.CloudflareToken,
-		envConfig.CloudflareS3AccessKeyID,
-		envConfig.CloudflareS3SecretAccessKey,
-	)
+	objectStore, localStore, err := newObjectStore(envConfig)
 	if err != nil {
-		log.Fatal("Failed to initialize Cloudflare repository:", err)
+		log.Fatal("Failed to initialize storage:", err)
+	}
+	keyring, err := encryption.ParseKeyring(envConfig.TokenEncryptionKeyID, envConfig.TokenEncryptionKeys)
+	if err != nil {
+		log.Fatal("Failed to load token encryption keys:", err)
 	}
 	userRepository := repo_user.NewUserRepository(supabaseRepository)
-	twitterRepository := repo_twitter.NewTwitterRepository(supabaseRepository, twitterConfig)
-	instagramRepository := repo_instagram.NewInstagramRepository(supabaseRepository, cloudflareRepository)
+	limits := ratelimit.NewTracker()
+	platformRepos := newPlatformRepositories(envConfig, supabaseRepository, keyring, limits)
+
+	if *reencrypt {
+		if err := reencryptTokens(context.Background(), platformRepos); err != nil {
+			log.Fatal("Failed to re-encrypt tokens:", err)
+		}
+		return
+	}
 
-	userService := service_user.NewUserService(userRepository, instagramRepository, twitterRepository, []byte(envConfig.JWTSecret))
+	registry := publisher.NewRegistry()
+	userService := service_user.NewUserService(userRepository, platformRepos.instagram, platformRepos.twitter, registry, []byte(envConfig.JWTSecret))
 	userHandler := handlers.NewHandler(userService)
 
-	twitterService := service_twitter.NewTwitterService(twitterRepository, twitterConfig)
-	twitterHandler := handlers.NewTwitterHandler(twitterService, userService)
+	mediaRepository := repo_media.NewMediaRepository(supabaseRepository)
+	stagingRepository := repo_staging.NewStagingRepository(supabaseRepository)
+	stagingService := service_staging.NewStagingService(stagingRepository, mediaRepository, objectStore)
+
+	registerPlatforms(envConfig, registry, platformRepos, userService, stagingService, limits)
 
-	instagramService := service_instagram.NewInstagramService(instagramConfig, instagramRepository)
-	instagramHandler := handlers.NewInstagramHandler(instagramService, userService)
+	postRepository := repo_post.NewPostRepository(supabaseRepository)
+	postService := service_post.NewPostService(postRepository, registry)
+	postHandler := handlers.NewPostHandler(postService, userService)
 
-	platformHandler := handlers.NewPlatformHandler(twitterService, instagramService, userService)
+	jobRepository := repo_job.NewJobRepository(supabaseRepository)
+	schedulerService := service_scheduler.NewSchedulerService(jobRepository, objectStore, registry, postService)
+	schedulerHandler := handlers.NewSchedulerHandler(schedulerService, userService)
+
+	libraryService := service_library.NewLibraryService(mediaRepository, objectStore, stagingService)
+	mediaHandler := handlers.NewMediaHandler(libraryService, userService)
+	rateLimitHandler := handlers.NewRateLimitHandler(limits, userService)
+
+	tracker := progress.NewTracker()
+	platformHandler := handlers.NewPlatformHandler(registry, schedulerService, userService, postService, libraryService, tracker)
+
+	idempotencyRepository := repo_idempotency.NewIdempotencyRepository(supabaseRepository)
+	idempotencyService := service_idempotency.NewIdempotencyService(idempotencyRepository, envConfig.IdempotencyKeyTTL)
 
 	e := setupServer(envConfig,
+		registry,
 		userHandler,
-		twitterHandler,
-		instagramHandler,
 		platformHandler,
+		schedulerHandler,
+		postHandler,
+		mediaHandler,
+		rateLimitHandler,
+		middlewares.Idempotency(idempotencyService, userService),
+		localStore,
 	)
 
-	log.Println("Starting server on :8080")
-	e.Logger.Fatal(e.Start(":8080"))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// --- Background Workers ---
+	go schedulerService.Run(ctx)
+	go service_instagram.NewTokenRefresher(platformRepos.instagram).Run(ctx)
+	go stagingService.Run(ctx)
+	go tracker.Run(ctx)
+	go idempotencyService.Run(ctx)
+	go limits.Run(ctx)
+
+	// Requests inherit ctx, so shutting down cancels in-flight publishes
+	// instead of waiting for them to time out.
+	e.Server.BaseContext = func(net.Listener) context.Context { return ctx }
+
+	go func() {
+		log.Println("Starting server on :8080")
+		if err := e.Start(":8080"); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		e.Logger.Fatal(err)
+	}
 }
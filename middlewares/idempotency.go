@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	service_idempotency "backend/services/idempotency"
+	service_user "backend/services/user"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// Idempotency makes the routes it wraps safe to retry. A request with an
+// Idempotency-Key header runs once per user and key; repeats of it get the
+// stored response back, marked with an Idempotent-Replayed header.
+// Responses that may change on a retry, such as server errors and rate
+// limits, are not stored, so such a request can be retried with the same
+// key. Requests without the header are passed through.
+func Idempotency(idempotencyService service_idempotency.IdempotencyService, userService service_user.UserService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Idempotency-Key can be at most %d characters long", maxIdempotencyKeyLength)})
+			}
+
+			email, err := userService.IsLoggedIn(c)
+			if err != nil {
+				return err
+			}
+			userID, err := userService.GetUserID(c.Request().Context(), email)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "User not found"})
+			}
+
+			fingerprint, err := requestFingerprint(c)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+			}
+
+			stored, err := idempotencyService.Begin(c.Request().Context(), userID, key, fingerprint)
+			switch {
+			case errors.Is(err, service_idempotency.ErrInProgress):
+				return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+			case errors.Is(err, service_idempotency.ErrKeyReused):
+				return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			case err != nil:
+				log.Printf("[IDEMPOTENCY] --- %v", err)
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check Idempotency-Key"})
+			}
+			if stored != nil {
+				c.Response().Header().Set(IdempotentReplayedHeader, "true")
+				return c.JSONBlob(stored.ResponseStatus, stored.ResponseBody)
+			}
+
+			// The key is released if the handler panics, so it is not stuck
+			// until it is considered abandoned.
+			finished := false
+			defer func() {
+				if !finished {
+					if err := idempotencyService.Release(userID, key); err != nil {
+						log.Printf("[IDEMPOTENCY] --- %v", err)
+					}
+				}
+			}()
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			if err := next(c); err != nil {
+				// Write the error response now, so that it is recorded.
+				c.Error(err)
+			}
+			finished = true
+
+			status := c.Response().Status
+			body := recorder.body.Bytes()
+			if retryable(status) || !json.Valid(body) {
+				if err := idempotencyService.Release(userID, key); err != nil {
+					log.Printf("[IDEMPOTENCY] --- %v", err)
+				}
+				return nil
+			}
+			if err := idempotencyService.Complete(userID, key, status, body); err != nil {
+				log.Printf("[IDEMPOTENCY] --- %v", err)
+			}
+			return nil
+		}
+	}
+}
+
+// retryable reports whether the same request may get a different response
+// later: server errors, timeouts, conflicts, rate limits, and accounts that
+// are not linked yet.
+func retryable(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusConflict, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// requestFingerprint hashes the route, the form values and the uploaded
+// files. Files are hashed by content, since a browser that sends the same
+// form again picks a new multipart boundary.
+func requestFingerprint(c echo.Context) (string, error) {
+	values, err := c.FormParams()
+	if err != nil {
+		return "", fmt.Errorf("failed to read form: %w", err)
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", c.Request().Method, c.Path())
+	for _, name := range sortedKeys(values) {
+		for _, value := range values[name] {
+			fmt.Fprintf(hash, "%q=%q\n", name, value)
+		}
+	}
+
+	if form := c.Request().MultipartForm; form != nil {
+		for _, name := range sortedKeys(form.File) {
+			for _, fh := range form.File[name] {
+				fmt.Fprintf(hash, "%q:%q:%d\n", name, fh.Filename, fh.Size)
+				f, err := fh.Open()
+				if err != nil {
+					return "", fmt.Errorf("failed to open file %s: %w", fh.Filename, err)
+				}
+				_, err = io.Copy(hash, f)
+				f.Close()
+				if err != nil {
+					return "", fmt.Errorf("failed to read file %s: %w", fh.Filename, err)
+				}
+			}
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// responseRecorder keeps a copy of the response body while it is written.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
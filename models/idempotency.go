@@ -0,0 +1,24 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// IdempotencyKey is a publish request made with an Idempotency-Key header.
+// ResponseStatus and ResponseBody are empty while the first request with
+// the key is still running.
+type IdempotencyKey struct {
+	UserID         string          `json:"user_id"`
+	Key            string          `json:"key"`
+	Fingerprint    string          `json:"fingerprint"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	ResponseBody   json.RawMessage `json:"response_body,omitempty"`
+	ExpiresAt      time.Time       `json:"expires_at"`
+	CreatedAt      *time.Time      `json:"created_at,omitempty"`
+}
+
+// Completed reports whether the response of the first request is stored.
+func (k *IdempotencyKey) Completed() bool {
+	return k.ResponseStatus != 0
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusPublished = "published"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// JobMedia points at a file staged in object storage for a scheduled job.
+type JobMedia struct {
+	Key         string `json:"key"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+}
+
+type ScheduledJob struct {
+	ID           string          `json:"id,omitempty"`
+	UserID       string          `json:"user_id"`
+	Platform     string          `json:"platform"`
+	PlatformData json.RawMessage `json:"platform_data"`
+	Media        []JobMedia      `json:"media"`
+	ScheduledAt  time.Time       `json:"scheduled_at"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	LastError    string          `json:"last_error,omitempty"`
+	Result       json.RawMessage `json:"result,omitempty"`
+	ClaimedAt    *time.Time      `json:"claimed_at,omitempty"`
+	HeartbeatAt  *time.Time      `json:"heartbeat_at,omitempty"`
+	CompletedAt  *time.Time      `json:"completed_at,omitempty"`
+	CreatedAt    *time.Time      `json:"created_at,omitempty"`
+}
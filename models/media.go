@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// MediaAsset is a file in a user's media library. The object is stored
+// under its SHA-256, so identical bytes are kept only once.
+type MediaAsset struct {
+	ID         string     `json:"id,omitempty"`
+	UserID     string     `json:"user_id"`
+	SHA256     string     `json:"sha256"`
+	ObjectKey  string     `json:"object_key"`
+	URL        string     `json:"url"`
+	FileName   string     `json:"file_name"`
+	MimeType   string     `json:"mime_type"`
+	Size       int64      `json:"size"`
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// PresignedRequest is a request the browser can send to object storage
+// without credentials. The headers are part of the signature and must be
+// sent exactly as given.
+type PresignedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// DirectUpload tells the browser how to upload a file straight to object
+// storage. Small files are sent with a single Put request; larger ones are
+// split into PartSize parts, each sent with its own request, and the ETag
+// of every part is passed back when the upload is completed.
+type DirectUpload struct {
+	Key       string            `json:"key"`
+	UploadID  string            `json:"upload_id,omitempty"`
+	Put       *PresignedRequest `json:"put,omitempty"`
+	PartSize  int64             `json:"part_size,omitempty"`
+	Parts     []DirectPart      `json:"parts,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type DirectPart struct {
+	PartNumber int32             `json:"part_number"`
+	Size       int64             `json:"size"`
+	Request    *PresignedRequest `json:"request"`
+}
+
+// CompletedUpload is what the browser sends back once all bytes of a
+// DirectUpload are in object storage.
+type CompletedUpload struct {
+	Key      string          `json:"key"`
+	UploadID string          `json:"upload_id,omitempty"`
+	FileName string          `json:"file_name"`
+	Parts    []CompletedPart `json:"parts,omitempty"`
+}
+
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
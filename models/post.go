@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	DeliveryStatusPublishing = "publishing"
+	DeliveryStatusPublished  = "published"
+	DeliveryStatusFailed     = "failed"
+	// DeliveryStatusPartial is a thread that failed after some of its parts
+	// were published. RemoteID and Permalink point to the first part.
+	DeliveryStatusPartial = "partial"
+)
+
+type Post struct {
+	ID         string     `json:"id,omitempty"`
+	UserID     string     `json:"user_id"`
+	MediaCount int        `json:"media_count"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+}
+
+// PostDelivery is the outcome of a post on a single platform.
+type PostDelivery struct {
+	ID           string          `json:"id,omitempty"`
+	PostID       string          `json:"post_id"`
+	UserID       string          `json:"user_id"`
+	Platform     string          `json:"platform"`
+	Status       string          `json:"status"`
+	RemoteID     string          `json:"remote_id,omitempty"`
+	Permalink    string          `json:"permalink,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	PlatformData json.RawMessage `json:"platform_data,omitempty"`
+	CreatedAt    *time.Time      `json:"created_at,omitempty"`
+	PublishedAt  *time.Time      `json:"published_at,omitempty"`
+}
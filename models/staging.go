@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// StagePurpose says why an object was put in the bucket. Every purpose has
+// its own time to live.
+type StagePurpose string
+
+const (
+	// StagePurposeInstagram objects are only there for the Graph API to
+	// fetch while a container is created.
+	StagePurposeInstagram StagePurpose = "instagram"
+	// StagePurposeDirectUpload objects are browser uploads that have not
+	// been completed into the media library yet.
+	StagePurposeDirectUpload StagePurpose = "direct_upload"
+)
+
+// StagedObject is a temporary object in the bucket. It is deleted once it
+// is no longer needed, or by the janitor after ExpiresAt.
+type StagedObject struct {
+	Key       string       `json:"key"`
+	Purpose   StagePurpose `json:"purpose"`
+	UserID    string       `json:"user_id,omitempty"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+}
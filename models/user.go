@@ -21,4 +21,33 @@ type InstagramModel struct {
 	InstagramID string    `json:"instagram_id"`
 	AccessToken string    `json:"access_token"`
 	ExpiresAt   string `json:"expires_at"`
+	// RefreshError is set when the background refresh failed and the user
+	// has to link the account again. Saving a new token clears it.
+	RefreshError string `json:"refresh_error"`
+}
+
+type BlueskyModel struct {
+	UserID      string `json:"user_id"`
+	DID         string `json:"did"`
+	Handle      string `json:"handle"`
+	PDSURL      string `json:"pds_url"`
+	AccessJWT   string `json:"access_jwt"`
+	RefreshJWT  string `json:"refresh_jwt"`
+	AppPassword string `json:"app_password"`
+}
+
+// MastodonAppModel is the OAuth app registered on a Mastodon instance. It is
+// shared by every user on that instance.
+type MastodonAppModel struct {
+	InstanceURL  string `json:"instance_url"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURI  string `json:"redirect_uri"`
+}
+
+type MastodonModel struct {
+	UserID      string `json:"user_id"`
+	InstanceURL string `json:"instance_url"`
+	AccessToken string `json:"access_token"`
+	Username    string `json:"username"`
 }
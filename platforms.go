@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+
+	"backend/handlers"
+	repo_bluesky "backend/repositories/bluesky"
+	"backend/repositories/encryption"
+	"backend/repositories/httpclient"
+	repo_instagram "backend/repositories/instagram"
+	repo_mastodon "backend/repositories/mastodon"
+	"backend/repositories/ratelimit"
+	repo_supabase "backend/repositories/supabase"
+	repo_twitter "backend/repositories/twitter"
+	"backend/routes"
+	service_bluesky "backend/services/bluesky"
+	service_instagram "backend/services/instagram"
+	service_mastodon "backend/services/mastodon"
+	"backend/services/publisher"
+	service_staging "backend/services/staging"
+	service_twitter "backend/services/twitter"
+	service_user "backend/services/user"
+
+	"github.com/dghubble/oauth1"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+const TWITTERCALLBACKPATH = "/twitter/link/callback"
+const INSTAGRAMCALLBACKPATH = "/instagram/link/callback"
+const MASTODONCALLBACKPATH = "/mastodon/link/callback"
+
+// platformRepositories holds the platform configs and repositories that are
+// needed outside of the platform itself, e.g. by the user service.
+type platformRepositories struct {
+	twitterConfig   *oauth1.Config
+	instagramConfig *oauth2.Config
+	twitter         repo_twitter.TwitterRepository
+	instagram       repo_instagram.InstagramRepository
+	bluesky         repo_bluesky.BlueskyRepository
+	mastodon        repo_mastodon.MastodonRepository
+}
+
+func newPlatformRepositories(envConfig EnvConfig, supabaseRepository *repo_supabase.SupabaseRepository, keyring *encryption.Keyring, limits *ratelimit.Tracker) platformRepositories {
+	twitterEndpoint := oauth1.Endpoint{
+		RequestTokenURL: "https://api.twitter.com/oauth/request_token",
+		AuthorizeURL:    "https://api.twitter.com/oauth/authorize",
+		AccessTokenURL:  "https://api.twitter.com/oauth/access_token",
+	}
+
+	twitterConfig := &oauth1.Config{
+		ConsumerKey:    envConfig.TwitterConsumerKey,
+		ConsumerSecret: envConfig.TwitterConsumerSecret,
+		CallbackURL:    envConfig.TwitterCallbackURL,
+		Endpoint:       twitterEndpoint,
+		HTTPClient:     httpclient.API,
+	}
+
+	instagramEndpoint := oauth2.Endpoint{
+		AuthURL:  fmt.Sprintf("https://www.instagram.com/oauth/authorize?client_id=%s&redirect_uri=%s&response_type=code&scope=instagram_business_basic,instagram_business_content_publish&force_reauth=true", envConfig.InstagramClientID, envConfig.InstagramRedirectURL),
+		TokenURL: "https://api.instagram.com/oauth/access_token",
+	}
+
+	instagramConfig := &oauth2.Config{
+		ClientID:     envConfig.InstagramClientID,
+		ClientSecret: envConfig.InstagramClientSecret,
+		RedirectURL:  envConfig.InstagramRedirectURL,
+		Scopes:       []string{"instagram_business_basic,instagram_content_publish"},
+		Endpoint:     instagramEndpoint,
+	}
+
+	return platformRepositories{
+		twitterConfig:   twitterConfig,
+		instagramConfig: instagramConfig,
+		twitter:         repo_twitter.NewTwitterRepository(supabaseRepository, twitterConfig, keyring, limits),
+		instagram:       repo_instagram.NewInstagramRepository(supabaseRepository, keyring, limits),
+		bluesky:         repo_bluesky.NewBlueskyRepository(supabaseRepository, keyring),
+		mastodon:        repo_mastodon.NewMastodonRepository(supabaseRepository, keyring),
+	}
+}
+
+// registerPlatforms builds the publisher and account linking routes of every
+// supported platform. Adding a platform only needs a new entry here.
+func registerPlatforms(envConfig EnvConfig, registry *publisher.Registry, repos platformRepositories, userService service_user.UserService, stagingService service_staging.StagingService, limits *ratelimit.Tracker) {
+	twitterService := service_twitter.NewTwitterService(repos.twitter, repos.twitterConfig)
+	twitterHandler := handlers.NewTwitterHandler(twitterService, userService)
+	registry.Register(service_twitter.NewTwitterPublisher(twitterService, repos.twitter, limits), &publisher.LinkRoutes{
+		Register:     func(api *echo.Group) { routes.RegisterTwitterRoutes(api, twitterHandler) },
+		CallbackPath: TWITTERCALLBACKPATH,
+		Callback:     twitterHandler.Callback,
+	})
+
+	instagramService := service_instagram.NewInstagramService(repos.instagramConfig, repos.instagram, stagingService, limits)
+	instagramHandler := handlers.NewInstagramHandler(instagramService, userService)
+	registry.Register(service_instagram.NewInstagramPublisher(instagramService, repos.instagram, limits), &publisher.LinkRoutes{
+		Register:     func(api *echo.Group) { routes.RegisterInstagramRoutes(api, instagramHandler) },
+		CallbackPath: INSTAGRAMCALLBACKPATH,
+		Callback:     instagramHandler.Callback,
+	})
+
+	blueskyService := service_bluesky.NewBlueskyService(repos.bluesky)
+	blueskyHandler := handlers.NewBlueskyHandler(blueskyService, userService)
+	registry.Register(service_bluesky.NewBlueskyPublisher(blueskyService), &publisher.LinkRoutes{
+		Register: func(api *echo.Group) { routes.RegisterBlueskyRoutes(api, blueskyHandler) },
+	})
+
+	mastodonService := service_mastodon.NewMastodonService(repos.mastodon, envConfig.MastodonRedirectURL)
+	mastodonHandler := handlers.NewMastodonHandler(mastodonService, userService)
+	registry.Register(service_mastodon.NewMastodonPublisher(mastodonService), &publisher.LinkRoutes{
+		Register:     func(api *echo.Group) { routes.RegisterMastodonRoutes(api, mastodonHandler) },
+		CallbackPath: MASTODONCALLBACKPATH,
+		Callback:     mastodonHandler.Callback,
+	})
+}
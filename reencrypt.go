@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// reencryptTokens encrypts every stored platform credential with the current
+// key. It is run once after encryption is introduced, and again after a new
+// key is made current, before the old key is removed from the config.
+func reencryptTokens(ctx context.Context, repos platformRepositories) error {
+	tables := []struct {
+		name      string
+		reencrypt func() (int, error)
+	}{
+		{"twitter", func() (int, error) { return repos.twitter.ReencryptTokens(ctx) }},
+		{"instagram", func() (int, error) { return repos.instagram.ReencryptTokens(ctx) }},
+		{"bluesky", func() (int, error) { return repos.bluesky.ReencryptTokens(ctx) }},
+		{"mastodon", func() (int, error) { return repos.mastodon.ReencryptTokens(ctx) }},
+	}
+
+	for _, table := range tables {
+		updated, err := table.reencrypt()
+		if err != nil {
+			return fmt.Errorf("%s: %w", table.name, err)
+		}
+		log.Printf("[REENCRYPT] --- %s: %d rows re-encrypted", table.name, updated)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package bluesky
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	"backend/repositories/encryption"
+	"backend/repositories/httpclient"
+	"backend/repositories/provider"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const bluesky_path = "bluesky"
+
+// DefaultPDSURL is used to create sessions when the account's own PDS is
+// not known yet.
+const DefaultPDSURL = "https://bsky.social"
+
+// Session is the result of createSession / refreshSession.
+type Session struct {
+	DID        string `json:"did"`
+	Handle     string `json:"handle"`
+	AccessJWT  string `json:"accessJwt"`
+	RefreshJWT string `json:"refreshJwt"`
+	DIDDoc     struct {
+		Service []struct {
+			ID              string `json:"id"`
+			Type            string `json:"type"`
+			ServiceEndpoint string `json:"serviceEndpoint"`
+		} `json:"service"`
+	} `json:"didDoc"`
+}
+
+// PDSURL returns the account's PDS endpoint from the DID document, falling
+// back to the given URL.
+func (s *Session) PDSURL(fallback string) string {
+	for _, service := range s.DIDDoc.Service {
+		if service.ID == "#atproto_pds" && service.ServiceEndpoint != "" {
+			return strings.TrimSuffix(service.ServiceEndpoint, "/")
+		}
+	}
+	return fallback
+}
+
+type CreateRecordResponse struct {
+	URI string `json:"uri"`
+	CID string `json:"cid"`
+}
+
+type BlueskyRepository interface {
+	SaveSession(ctx context.Context, session *models.BlueskyModel) error
+	GetSession(ctx context.Context, userID string) (*models.BlueskyModel, error)
+	DeleteSession(ctx context.Context, userID string) error
+	ReencryptTokens(ctx context.Context) (int, error)
+	CreateSession(ctx context.Context, pdsURL string, identifier string, appPassword string) (*Session, error)
+	RefreshSession(ctx context.Context, pdsURL string, refreshJWT string) (*Session, error)
+	CheckSession(ctx context.Context, pdsURL string, accessJWT string) error
+	RevokeSession(ctx context.Context, pdsURL string, refreshJWT string) error
+	ResolveHandle(ctx context.Context, pdsURL string, handle string) (string, error)
+	UploadBlob(ctx context.Context, pdsURL string, accessJWT string, data []byte, mimeType string) (json.RawMessage, error)
+	CreateRecord(ctx context.Context, pdsURL string, accessJWT string, did string, collection string, record any) (*CreateRecordResponse, error)
+}
+
+type blueskyRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+	keyring       *encryption.Keyring
+}
+
+func NewBlueskyRepository(supabaseRepository *repo_supabase.SupabaseRepository, keyring *encryption.Keyring) BlueskyRepository {
+	return &blueskyRepositoryImpl{
+		repo_supabase: supabaseRepository,
+		keyring:       keyring,
+	}
+}
+
+// SaveSession stores the session with its tokens and app password encrypted.
+func (b *blueskyRepositoryImpl) SaveSession(ctx context.Context, session *models.BlueskyModel) error {
+	encrypted := *session
+	var err error
+	for _, field := range []*string{&encrypted.AccessJWT, &encrypted.RefreshJWT, &encrypted.AppPassword} {
+		if *field, err = b.keyring.Encrypt(*field); err != nil {
+			return err
+		}
+	}
+
+	payloadBytes, err := json.Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+
+	url := b.repo_supabase.SupabaseURL + bluesky_path + "?on_conflict=user_id"
+	req, err := repo.NewRequestWithContext(ctx, b.repo_supabase, "POST", url, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Prefer", "return=representation,resolution=merge-duplicates")
+
+	resp, err := b.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to save bluesky session, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (b *blueskyRepositoryImpl) GetSession(ctx context.Context, userID string) (*models.BlueskyModel, error) {
+	sessions, err := b.selectSessions(ctx, "?user_id=eq."+url.QueryEscape(userID))
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 || sessions[0].AccessJWT == "" {
+		return nil, fmt.Errorf("bluesky session not found")
+	}
+
+	session := &sessions[0]
+	if err := b.decryptSession(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (b *blueskyRepositoryImpl) decryptSession(session *models.BlueskyModel) error {
+	var err error
+	for _, field := range []*string{&session.AccessJWT, &session.RefreshJWT, &session.AppPassword} {
+		if *field, err = b.keyring.Decrypt(*field); err != nil {
+			return fmt.Errorf("failed to decrypt bluesky session: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReencryptTokens encrypts plaintext rows and rows sealed with an old key
+// with the current key, returning the number of rows updated.
+func (b *blueskyRepositoryImpl) ReencryptTokens(ctx context.Context) (int, error) {
+	sessions, err := b.selectSessions(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for idx := range sessions {
+		session := &sessions[idx]
+		if !b.keyring.NeedsReencryption(session.AccessJWT) &&
+			!b.keyring.NeedsReencryption(session.RefreshJWT) &&
+			!b.keyring.NeedsReencryption(session.AppPassword) {
+			continue
+		}
+
+		if err := b.decryptSession(session); err != nil {
+			return updated, fmt.Errorf("user %s: %w", session.UserID, err)
+		}
+		if err := b.SaveSession(ctx, session); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (b *blueskyRepositoryImpl) selectSessions(ctx context.Context, query string) ([]models.BlueskyModel, error) {
+	req, err := repo.NewRequestWithContext(ctx, b.repo_supabase, "GET", b.repo_supabase.SupabaseURL+bluesky_path+query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch bluesky session, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var sessions []models.BlueskyModel
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return sessions, nil
+}
+
+func (b *blueskyRepositoryImpl) DeleteSession(ctx context.Context, userID string) error {
+	req, err := repo.NewRequestWithContext(ctx, b.repo_supabase, "DELETE", b.repo_supabase.SupabaseURL+bluesky_path+"?user_id=eq."+url.QueryEscape(userID), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete bluesky session, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (b *blueskyRepositoryImpl) CreateSession(ctx context.Context, pdsURL string, identifier string, appPassword string) (*Session, error) {
+	payload := map[string]string{
+		"identifier": identifier,
+		"password":   appPassword,
+	}
+	var session Session
+	if err := b.xrpc(ctx, pdsURL, "POST", "com.atproto.server.createSession", "", payload, &session); err != nil {
+		return nil, fmt.Errorf("failed to create bluesky session: %w", err)
+	}
+	return &session, nil
+}
+
+func (b *blueskyRepositoryImpl) RefreshSession(ctx context.Context, pdsURL string, refreshJWT string) (*Session, error) {
+	var session Session
+	if err := b.xrpc(ctx, pdsURL, "POST", "com.atproto.server.refreshSession", refreshJWT, nil, &session); err != nil {
+		return nil, fmt.Errorf("failed to refresh bluesky session: %w", err)
+	}
+	return &session, nil
+}
+
+func (b *blueskyRepositoryImpl) CheckSession(ctx context.Context, pdsURL string, accessJWT string) error {
+	return b.xrpc(ctx, pdsURL, "GET", "com.atproto.server.getSession", accessJWT, nil, nil)
+}
+
+// RevokeSession ends the session on the PDS so its tokens stop working.
+func (b *blueskyRepositoryImpl) RevokeSession(ctx context.Context, pdsURL string, refreshJWT string) error {
+	if err := b.xrpc(ctx, pdsURL, "POST", "com.atproto.server.deleteSession", refreshJWT, nil, nil); err != nil {
+		return fmt.Errorf("failed to revoke bluesky session: %w", err)
+	}
+	return nil
+}
+
+func (b *blueskyRepositoryImpl) ResolveHandle(ctx context.Context, pdsURL string, handle string) (string, error) {
+	var result struct {
+		DID string `json:"did"`
+	}
+	method := "com.atproto.identity.resolveHandle?handle=" + url.QueryEscape(handle)
+	if err := b.xrpc(ctx, pdsURL, "GET", method, "", nil, &result); err != nil {
+		return "", fmt.Errorf("failed to resolve handle %s: %w", handle, err)
+	}
+	return result.DID, nil
+}
+
+// UploadBlob uploads raw bytes and returns the blob reference that records
+// embed to point at them.
+func (b *blueskyRepositoryImpl) UploadBlob(ctx context.Context, pdsURL string, accessJWT string, data []byte, mimeType string) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", pdsURL+"/xrpc/com.atproto.repo.uploadBlob", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessJWT)
+	req.Header.Set("Content-Type", mimeType)
+	// Blobs are stored by content, so uploading one twice is harmless.
+	req.Header["Idempotency-Key"] = nil
+
+	var result struct {
+		Blob json.RawMessage `json:"blob"`
+	}
+	if err := b.do(req, &result); err != nil {
+		return nil, fmt.Errorf("failed to upload blob: %w", err)
+	}
+	return result.Blob, nil
+}
+
+func (b *blueskyRepositoryImpl) CreateRecord(ctx context.Context, pdsURL string, accessJWT string, did string, collection string, record any) (*CreateRecordResponse, error) {
+	payload := map[string]any{
+		"repo":       did,
+		"collection": collection,
+		"record":     record,
+	}
+	var result CreateRecordResponse
+	if err := b.xrpc(ctx, pdsURL, "POST", "com.atproto.repo.createRecord", accessJWT, payload, &result); err != nil {
+		return nil, fmt.Errorf("failed to create record: %w", err)
+	}
+	return &result, nil
+}
+
+func (b *blueskyRepositoryImpl) xrpc(ctx context.Context, pdsURL string, method string, nsid string, token string, payload any, result any) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, pdsURL+"/xrpc/"+nsid, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return b.do(req, result)
+}
+
+// do makes an XRPC call under the shared retry policy. Failures come back
+// as a *provider.Error.
+func (b *blueskyRepositoryImpl) do(req *http.Request, result any) error {
+	_, body, err := provider.Bluesky.Do(httpclient.API, req)
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -1,15 +1,20 @@
 package cloudflare
 
 import (
-    "bytes"
+	"backend/models"
+	"backend/repositories/httpclient"
+	repo_storage "backend/repositories/storage"
     "context"
     "fmt"
     "io"
-    "mime/multipart"
 	"log"
+	"strings"
+	"time"
 
     "github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
     "github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
     "github.com/aws/aws-sdk-go-v2/config"
     "github.com/aws/aws-sdk-go-v2/service/s3"
     "github.com/aws/aws-sdk-go-v2/service/s3/types"
@@ -22,14 +27,24 @@ type CloudflareRepository struct {
     CloudflareS3AccessKeyID   string
     CloudflareS3SecretAccessKey string
     S3Client                  *s3.Client
+	// Bucket is the public bucket media is stored in, and PublicBaseURL
+	// the address it is served under, ending in a slash.
+	Bucket        string
+	PublicBaseURL string
 }
 
-const bucketName = "mediabucket"
+var _ repo_storage.ObjectStore = (*CloudflareRepository)(nil)
 
-func NewCloudflareRepository(ctx context.Context, cloudflareAccountID, cloudflareS3APIURL, cloudflareToken, accessKeyID, secretAccessKey string) (*CloudflareRepository, error) {
+const (
+	uploadPartSize    = 8 << 20
+	uploadConcurrency = 3
+)
+
+func NewCloudflareRepository(ctx context.Context, cloudflareAccountID, cloudflareS3APIURL, cloudflareToken, accessKeyID, secretAccessKey, bucket, publicBaseURL string) (*CloudflareRepository, error) {
 	cfg, err := config.LoadDefaultConfig(ctx,
         config.WithRegion("auto"),
         config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
+		config.WithHTTPClient(httpclient.Upload),
 
     )
     if err != nil {
@@ -48,56 +63,228 @@ func NewCloudflareRepository(ctx context.Context, cloudflareAccountID, cloudflar
         CloudflareS3AccessKeyID:     accessKeyID,
         CloudflareS3SecretAccessKey: secretAccessKey,
         S3Client:                   s3Client,
+		Bucket:                     bucket,
+		PublicBaseURL:              strings.TrimSuffix(publicBaseURL, "/") + "/",
     }, nil
 }
 
-func (c *CloudflareRepository) UploadFile(file multipart.File, fileName string, mimeType string) (string, error) {
-    log.Println("[UPLOAD_FILE] --- Starting file upload")
-    log.Printf("[UPLOAD_FILE] --- File name: %s", fileName)
-    log.Printf("[UPLOAD_FILE] --- Expected MIME type: %s", mimeType)
-	
-    // Read the file bytes from the start.
-	file.Seek(0, io.SeekStart)
-    fileBytes, err := io.ReadAll(file)
-    if err != nil {
-        log.Printf("[UPLOAD_FILE] --- ERROR while reading file: %v", err)
-        return "", fmt.Errorf("failed to read file: %w", err)
-    }
-    log.Printf("[UPLOAD_FILE] --- Read %d bytes from file", len(fileBytes))
+// UploadFile streams file to the bucket with the SDK's upload manager.
+// Files larger than one part are sent as a multipart upload; when file is
+// an io.ReaderAt (such as a multipart temp file) the parts are read straight
+// from it, otherwise at most uploadConcurrency parts are buffered. Either
+// way memory use does not grow with the file size.
+func (c *CloudflareRepository) UploadFile(ctx context.Context, file io.Reader, fileName string, mimeType string) (string, error) {
+	log.Printf("[UPLOAD_FILE] --- Uploading %s (%s)", fileName, mimeType)
 
-    if len(fileBytes) < 10 {
-        log.Printf("[UPLOAD_FILE] --- WARNING: File size looks abnormally small")
-    }
+	// Callers may have sniffed the content type, so start from the top.
+	if seeker, ok := file.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to rewind file: %w", err)
+		}
+	}
 
-    // Log the start of S3 PutObject preparation.
-    log.Println("[UPLOAD_FILE] --- Preparing PutObjectInput for S3 client")
-    log.Printf("[UPLOAD_FILE] --- S3 Bucket: %s", bucketName)
-    log.Printf("[UPLOAD_FILE] --- S3 Key: %s", fileName)
-    log.Printf("[UPLOAD_FILE] --- S3 ContentType: %s", mimeType)
-    log.Printf("[UPLOAD_FILE] --- S3 ACL: %v", types.ObjectCannedACLPublicRead)
-
-    input := &s3.PutObjectInput{
-        Bucket:      aws.String(bucketName),
-        Key:         aws.String(fileName),
-        Body:        bytes.NewReader(fileBytes),
-        ACL:         types.ObjectCannedACLPublicRead,
-        ContentType: aws.String(mimeType),
-    }
-    log.Println("[UPLOAD_FILE] --- PutObjectInput successfully built")
+	uploader := manager.NewUploader(c.S3Client, func(u *manager.Uploader) {
+		u.PartSize = uploadPartSize
+		u.Concurrency = uploadConcurrency
+	})
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(c.Bucket),
+		Key:         aws.String(fileName),
+		Body:        file,
+		ACL:         types.ObjectCannedACLPublicRead,
+		ContentType: aws.String(mimeType),
+	})
+	if err != nil {
+		log.Printf("[UPLOAD_FILE] --- ERROR during file upload: %v", err)
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	publicURL := c.PublicURL(fileName)
+	log.Printf("[UPLOAD_FILE] --- File uploaded to %s", publicURL)
+	return publicURL, nil
+}
 
-    ctx := context.Background()
-    log.Println("[UPLOAD_FILE] --- Calling S3Client.PutObject ...")
-    result, err := c.S3Client.PutObject(ctx, input)
+func (c *CloudflareRepository) DeleteFile(ctx context.Context, fileName string) error {
+    _, err := c.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+        Bucket: aws.String(c.Bucket),
+        Key:    aws.String(fileName),
+    })
     if err != nil {
-        log.Printf("[UPLOAD_FILE] --- ERROR during file upload: %v", err)
-        return "", fmt.Errorf("failed to upload file: %w", err)
+        return fmt.Errorf("failed to delete file %s: %w", fileName, err)
     }
-    log.Println("[UPLOAD_FILE] --- File uploaded successfully")
-    log.Printf("[UPLOAD_FILE] --- S3 PutObject result: %#v", result)
+    return nil
+}
+
+// PublicURL is the address under which the public bucket serves an object.
+func (c *CloudflareRepository) PublicURL(fileName string) string {
+	return c.PublicBaseURL + fileName
+}
+
+// PresignPut returns a URL the browser can PUT exactly size bytes of
+// contentType to.
+func (c *CloudflareRepository) PresignPut(ctx context.Context, fileName string, contentType string, size int64, expires time.Duration) (*models.PresignedRequest, error) {
+	req, err := s3.NewPresignClient(c.S3Client).PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(c.Bucket),
+		Key:           aws.String(fileName),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload of %s: %w", fileName, err)
+	}
+	return presignedRequest(req), nil
+}
 
-    publicURL := fmt.Sprintf("%s%s", "https://pub-16ef3834c60f45cca08f78c4653d8f49.r2.dev/", fileName)
-    log.Printf("[UPLOAD_FILE] --- Public URL constructed: %s", publicURL)
-    return publicURL, nil
+func (c *CloudflareRepository) CreateMultipartUpload(ctx context.Context, fileName string, contentType string) (string, error) {
+	result, err := c.S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(c.Bucket),
+		Key:         aws.String(fileName),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start multipart upload of %s: %w", fileName, err)
+	}
+	return aws.ToString(result.UploadId), nil
 }
 
+// PresignUploadPart returns a URL the browser can PUT one part of a
+// multipart upload to.
+func (c *CloudflareRepository) PresignUploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, size int64, expires time.Duration) (*models.PresignedRequest, error) {
+	req, err := s3.NewPresignClient(c.S3Client).PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(c.Bucket),
+		Key:           aws.String(fileName),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(partNumber),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign part %d of %s: %w", partNumber, fileName, err)
+	}
+	return presignedRequest(req), nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts, given as part
+// number to ETag, into the final object.
+func (c *CloudflareRepository) CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, etags map[int32]string) error {
+	parts := make([]types.CompletedPart, 0, len(etags))
+	for number := int32(1); number <= int32(len(etags)); number++ {
+		etag, ok := etags[number]
+		if !ok {
+			return fmt.Errorf("part %d of %s is missing", number, fileName)
+		}
+		parts = append(parts, types.CompletedPart{PartNumber: aws.Int32(number), ETag: aws.String(etag)})
+	}
+
+	_, err := c.S3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.Bucket),
+		Key:             aws.String(fileName),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", fileName, err)
+	}
+	return nil
+}
 
+func (c *CloudflareRepository) AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error {
+	_, err := c.S3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(fileName),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to abort multipart upload of %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// FileSize returns the size of an object, failing if it does not exist.
+func (c *CloudflareRepository) FileSize(ctx context.Context, fileName string) (int64, error) {
+	result, err := c.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up file %s: %w", fileName, err)
+	}
+	return aws.ToInt64(result.ContentLength), nil
+}
+
+// OpenFile streams an object. The caller must close the returned body.
+func (c *CloudflareRepository) OpenFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	result, err := c.S3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(fileName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file %s: %w", fileName, err)
+	}
+	return result.Body, nil
+}
+
+// FileReaderAt reads parts of an object with ranged requests, for parsers
+// that only need a few headers out of a large file.
+func (c *CloudflareRepository) FileReaderAt(ctx context.Context, fileName string, size int64) io.ReaderAt {
+	return &objectReaderAt{ctx: ctx, c: c, fileName: fileName, size: size}
+}
+
+type objectReaderAt struct {
+	ctx      context.Context
+	c        *CloudflareRepository
+	fileName string
+	size     int64
+}
+
+func (r *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+
+	result, err := r.c.S3Client.GetObject(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.c.Bucket),
+		Key:    aws.String(r.fileName),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file %s: %w", r.fileName, err)
+	}
+	defer result.Body.Close()
+
+	n, err := io.ReadFull(result.Body, p[:end-off])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// CopyFile copies an object within the bucket.
+func (c *CloudflareRepository) CopyFile(ctx context.Context, fromName string, toName string, contentType string) error {
+	_, err := c.S3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(c.Bucket),
+		Key:               aws.String(toName),
+		CopySource:        aws.String(c.Bucket + "/" + fromName),
+		ACL:               types.ObjectCannedACLPublicRead,
+		ContentType:       aws.String(contentType),
+		MetadataDirective: types.MetadataDirectiveReplace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy file %s to %s: %w", fromName, toName, err)
+	}
+	return nil
+}
+
+func presignedRequest(req *v4.PresignedHTTPRequest) *models.PresignedRequest {
+	headers := map[string]string{}
+	for name, values := range req.SignedHeader {
+		if name == "Host" || len(values) == 0 {
+			continue
+		}
+		headers[name] = values[0]
+	}
+	return &models.PresignedRequest{Method: req.Method, URL: req.URL, Headers: headers}
+}
@@ -0,0 +1,190 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Encrypted values look like "enc:v1:<key id>:<wrapped data key>:<ciphertext>".
+// Each value gets its own random data key, which is sealed with the master
+// key named by the key ID. Values without the prefix are plaintext rows
+// written before encryption was introduced.
+const (
+	prefix  = "enc:v1:"
+	keySize = 32
+)
+
+// Keyring holds the master keys. New values are always sealed with the
+// current key; older keys are only kept to open existing values until they
+// have been re-encrypted.
+type Keyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+// ParseKeyring builds a keyring from a comma separated list of
+// "<key id>:<base64 32-byte key>" pairs, such as the TOKEN_ENCRYPTION_KEYS
+// environment variable.
+func ParseKeyring(currentID string, spec string) (*Keyring, error) {
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid encryption key entry, expected <id>:<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+		keys[id] = key
+	}
+	return NewKeyring(currentID, keys)
+}
+
+func NewKeyring(currentID string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys configured")
+	}
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("current encryption key %q is not configured", currentID)
+	}
+
+	k := &Keyring{currentID: currentID, keys: map[string]cipher.AEAD{}}
+	for id, key := range keys {
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("encryption key id %q must not contain ':'", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("encryption key %s must be %d bytes, got %d", id, keySize, len(key))
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %w", id, err)
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// Encrypt seals a value with a fresh data key. Empty values stay empty so
+// that "not set" can still be told apart in the database.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(dataAEAD, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := seal(k.keys[k.currentID], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.currentID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Plaintext values are returned
+// unchanged so rows written before encryption keep working until they are
+// re-encrypted.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+
+	masterAEAD, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key %q", parts[0])
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+
+	dataKey, err := open(masterAEAD, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption reports whether a stored value is plaintext or sealed
+// with a key other than the current one.
+func (k *Keyring) NeedsReencryption(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+k.currentID+":")
+}
+
+// Reencrypt opens a stored value and seals it again with the current key.
+func (k *Keyring) Reencrypt(value string) (string, error) {
+	plaintext, err := k.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return k.Encrypt(plaintext)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce followed by the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
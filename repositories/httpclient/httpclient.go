@@ -0,0 +1,99 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// transport is shared by every client, so that connections to Supabase and
+// the platform APIs are pooled and reused across requests.
+var transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// publicTransport is for hosts that users pick, such as Mastodon instances.
+// It only connects to addresses on the internet. The address is checked
+// when it is dialed, so a host can't pass a lookup and then resolve to the
+// server's own network. It uses no proxy, since a proxy would do the
+// dialing instead.
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   publicOnly,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+var (
+	// API is for JSON requests to Supabase and the platform APIs.
+	API = New(30 * time.Second)
+	// Upload is for requests that carry media, which can take minutes on
+	// a slow link.
+	Upload = New(10 * time.Minute)
+
+	// PublicAPI and PublicUpload are API and Upload for hosts that users
+	// pick, see publicTransport.
+	PublicAPI    = newClient(publicTransport, 30*time.Second)
+	PublicUpload = newClient(publicTransport, 10*time.Minute)
+)
+
+// New returns a client on the shared transport whose requests, including
+// reading the response body, are cut off after timeout. Requests are also
+// cancelled with their context.
+func New(timeout time.Duration) *http.Client {
+	return newClient(transport, timeout)
+}
+
+func newClient(transport http.RoundTripper, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// ErrNotPublic is the error of a connection that publicTransport refused.
+var ErrNotPublic = errors.New("not a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range, which is not routable
+// on the internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicOnly refuses connections to loopback, private, link-local and
+// other addresses that are not on the internet.
+func publicOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsUnspecified() || addr.IsMulticast() || sharedAddressSpace.Contains(addr) {
+		return fmt.Errorf("refusing to connect to %s: %w", addr, ErrNotPublic)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+const idempotency_keys_path = "idempotency_keys"
+
+type IdempotencyRepository interface {
+	// Claim inserts the key unless the user already has a row for it. The
+	// returned bool reports whether this caller inserted it.
+	Claim(key *models.IdempotencyKey) (bool, error)
+	// Get returns the user's key, or nil if there is none.
+	Get(userID string, key string) (*models.IdempotencyKey, error)
+	Complete(userID string, key string, status int, body json.RawMessage) error
+	Delete(userID string, key string) error
+	// Expire deletes the user's key if it expired before now, or if its
+	// request is still running after it was started before abandonedBefore.
+	Expire(userID string, key string, now time.Time, abandonedBefore time.Time) error
+	// DeleteExpired deletes every key that expired before now.
+	DeleteExpired(now time.Time) (int, error)
+}
+
+type idempotencyRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+}
+
+func NewIdempotencyRepository(supabaseRepository *repo_supabase.SupabaseRepository) IdempotencyRepository {
+	return &idempotencyRepositoryImpl{
+		repo_supabase: supabaseRepository,
+	}
+}
+
+func (i *idempotencyRepositoryImpl) Claim(key *models.IdempotencyKey) (bool, error) {
+	var inserted []models.IdempotencyKey
+	// Duplicates are ignored rather than rejected, so a key that is taken
+	// simply comes back as an empty result.
+	err := i.do("POST", idempotency_keys_path+"?on_conflict=user_id,key", key, &inserted, "return=representation,resolution=ignore-duplicates")
+	if err != nil {
+		return false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	return len(inserted) > 0, nil
+}
+
+func (i *idempotencyRepositoryImpl) Get(userID string, key string) (*models.IdempotencyKey, error) {
+	var keys []models.IdempotencyKey
+	if err := i.do("GET", idempotency_keys_path+"?"+keyFilter(userID, key).Encode(), nil, &keys, ""); err != nil {
+		return nil, fmt.Errorf("failed to fetch idempotency key: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &keys[0], nil
+}
+
+func (i *idempotencyRepositoryImpl) Complete(userID string, key string, status int, body json.RawMessage) error {
+	payload := map[string]any{
+		"response_status": status,
+		"response_body":   body,
+	}
+	if err := i.do("PATCH", idempotency_keys_path+"?"+keyFilter(userID, key).Encode(), payload, nil, "return=minimal"); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (i *idempotencyRepositoryImpl) Delete(userID string, key string) error {
+	if err := i.do("DELETE", idempotency_keys_path+"?"+keyFilter(userID, key).Encode(), nil, nil, "return=minimal"); err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Expire matches on the expiry in the same request as the delete, so a key
+// that was claimed again in the meantime is left alone.
+func (i *idempotencyRepositoryImpl) Expire(userID string, key string, now time.Time, abandonedBefore time.Time) error {
+	q := keyFilter(userID, key)
+	q.Add("or", fmt.Sprintf("(expires_at.lt.%s,and(response_status.is.null,created_at.lt.%s))",
+		now.UTC().Format(time.RFC3339), abandonedBefore.UTC().Format(time.RFC3339)))
+
+	if err := i.do("DELETE", idempotency_keys_path+"?"+q.Encode(), nil, nil, "return=minimal"); err != nil {
+		return fmt.Errorf("failed to expire idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (i *idempotencyRepositoryImpl) DeleteExpired(now time.Time) (int, error) {
+	q := url.Values{}
+	q.Add("expires_at", "lt."+now.UTC().Format(time.RFC3339))
+	q.Add("select", "key")
+
+	var deleted []models.IdempotencyKey
+	if err := i.do("DELETE", idempotency_keys_path+"?"+q.Encode(), nil, &deleted, "return=representation"); err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	return len(deleted), nil
+}
+
+func keyFilter(userID string, key string) url.Values {
+	q := url.Values{}
+	q.Add("user_id", "eq."+userID)
+	q.Add("key", "eq."+key)
+	return q
+}
+
+func (i *idempotencyRepositoryImpl) do(method string, path string, payload any, result any, prefer string) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := repo.NewRequest(i.repo_supabase, method, i.repo_supabase.SupabaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+
+	resp, err := i.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	if result != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -3,59 +3,99 @@ package instagram
 import (
 	"backend/models"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 
 	repo "backend/repositories"
-	repo_cloudflare "backend/repositories/cloudflare"
+	"backend/repositories/encryption"
+	"backend/repositories/httpclient"
+	"backend/repositories/provider"
+	"backend/repositories/ratelimit"
 	repo_supabase "backend/repositories/supabase"
-	"mime/multipart"
 	"net/http"
 	_ "net/http/httputil"
+	"net/url"
 	"time"
 )
 
 const instagram_api_path = "https://graph.instagram.com/v24.0/"
 const longTimeTokenURL = "https://graph.instagram.com/access_token"
+const refreshTokenURL = "https://graph.instagram.com/refresh_access_token"
+
+const platform = "instagram"
+
+// EndpointContentPublishing is the quota of posts an account can publish
+// in a rolling 24 hours.
+const EndpointContentPublishing = "content_publishing_limit"
 
 type InstagramRepository interface {
-	SaveToken(accessToken string, userID string, instagramID string, expirationTime string) error
-	GetInstagramID(accessToken string) (string, error)
-	GetAccessToken(accessToken string, clientSecret string) (string, int, error)
-	CheckTokens(accessToken string) error
-	GetCredentials(userID string) (string, string, error)
-	CheckPublishLimit(accessToken string, instagramID string) (bool, error)
-	UploadMedia(file multipart.File, fileName string, mimeType string) (string, error)
-	CreateContainer(accessToken, instagramID, caption, mediaURL, mediaType string, isCarouselItem bool) (string, error)
-	CreateCarouselContainer(accessToken string, instagramID string, caption string, containerIDs []string) (string, error)
-	WaitForContainerReady(accessToken string, containerID string) (string, error)
-	PublishMedia(accessToken string, instagramID string, creationID string) (string, error)
+	SaveToken(ctx context.Context, accessToken string, userID string, instagramID string, expirationTime string) error
+	GetInstagramID(ctx context.Context, accessToken string) (string, error)
+	GetAccessToken(ctx context.Context, accessToken string, clientSecret string) (string, int, error)
+	CheckTokens(ctx context.Context, accessToken string) error
+	GetCredentials(ctx context.Context, userID string) (string, string, error)
+	GetToken(ctx context.Context, userID string) (*models.InstagramModel, error)
+	DeleteToken(ctx context.Context, userID string) error
+	ListExpiring(ctx context.Context, before time.Time) ([]models.InstagramModel, error)
+	RefreshToken(ctx context.Context, accessToken string) (string, int, error)
+	RecordRefreshFailure(ctx context.Context, userID string, errMessage string) error
+	ReencryptTokens(ctx context.Context) (int, error)
+	CheckPublishLimit(ctx context.Context, accessToken string, instagramID string) (*ratelimit.Quota, error)
+	CreateContainer(ctx context.Context, accessToken, instagramID, caption, mediaURL, mediaType, altText string, isCarouselItem bool) (string, error)
+	CreateCarouselContainer(ctx context.Context, accessToken string, instagramID string, caption string, containerIDs []string) (string, error)
+	WaitForContainerReady(ctx context.Context, accessToken string, containerID string) (string, error)
+	PublishMedia(ctx context.Context, accessToken string, instagramID string, creationID string) (string, error)
+	GetPermalink(ctx context.Context, accessToken string, mediaID string) (string, error)
 }
 
 type instagramRepositoryImpl struct {
-	repo_supabase   *repo_supabase.SupabaseRepository
-	repo_cloudflare *repo_cloudflare.CloudflareRepository
+	repo_supabase *repo_supabase.SupabaseRepository
+	keyring       *encryption.Keyring
+	limits        *ratelimit.Tracker
 }
 
-func NewInstagramRepository(supabaseRepository *repo_supabase.SupabaseRepository, cloudflareRepository *repo_cloudflare.CloudflareRepository) InstagramRepository {
+func NewInstagramRepository(supabaseRepository *repo_supabase.SupabaseRepository, keyring *encryption.Keyring, limits *ratelimit.Tracker) InstagramRepository {
 	return &instagramRepositoryImpl{
-		repo_supabase:   supabaseRepository,
-		repo_cloudflare: cloudflareRepository,
+		repo_supabase: supabaseRepository,
+		keyring:       keyring,
+		limits:        limits,
+	}
+}
+
+// send makes a call to the Graph API under the shared retry policy and
+// records the API usage reported on the last response against the account
+// of the request's context. Failures come back as a *provider.Error.
+func (i *instagramRepositoryImpl) send(req *http.Request) ([]byte, error) {
+	resp, body, err := provider.Instagram.Do(httpclient.API, req)
+	if resp != nil {
+		account := ratelimit.AccountFrom(req.Context())
+		for _, quota := range ratelimit.MetaQuotas(platform, resp.Header) {
+			i.limits.Record(account, quota)
+		}
 	}
+	return body, err
 }
 
-func (i *instagramRepositoryImpl) SaveToken(accessToken string, userID string, instagramID string, expirationTime string) error {
+func (i *instagramRepositoryImpl) SaveToken(ctx context.Context, accessToken string, userID string, instagramID string, expirationTime string) error {
+	encryptedToken, err := i.keyring.Encrypt(accessToken)
+	if err != nil {
+		return err
+	}
+
 	payload := models.InstagramModel{
 		UserID:      userID,
 		InstagramID: instagramID,
-		AccessToken: accessToken,
+		AccessToken: encryptedToken,
 		ExpiresAt:   expirationTime,
 	}
 
-	existingAccessToken, _, err := i.GetCredentials(userID)
-	if err != nil && err.Error() != "instagram token not found" {
+	// Look the row up directly rather than through GetCredentials, which
+	// rejects expired tokens and would make re-linking impossible.
+	existing, err := i.GetToken(ctx, userID)
+	if err != nil {
 		return err
 	}
 
@@ -64,21 +104,20 @@ func (i *instagramRepositoryImpl) SaveToken(accessToken string, userID string, i
 		return err
 	}
 
-	if existingAccessToken == "" {
-		return i.createToken(payloadBytes)
+	if existing == nil {
+		return i.createToken(ctx, payloadBytes)
 	}
-	return i.updateToken(userID, payloadBytes)
+	return i.updateToken(ctx, userID, payloadBytes)
 }
 
-func (i *instagramRepositoryImpl) createToken(payloadBytes []byte) error {
+func (i *instagramRepositoryImpl) createToken(ctx context.Context, payloadBytes []byte) error {
 	url := i.repo_supabase.SupabaseURL + "instagram"
-	req, err := repo.NewRequest(i.repo_supabase, "POST", url, bytes.NewBuffer(payloadBytes))
+	req, err := repo.NewRequestWithContext(ctx, i.repo_supabase, "POST", url, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := i.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -91,15 +130,14 @@ func (i *instagramRepositoryImpl) createToken(payloadBytes []byte) error {
 	return nil
 }
 
-func (i *instagramRepositoryImpl) updateToken(userID string, payloadBytes []byte) error {
+func (i *instagramRepositoryImpl) updateToken(ctx context.Context, userID string, payloadBytes []byte) error {
 	url := i.repo_supabase.SupabaseURL + "instagram?user_id=eq." + userID
-	req, err := repo.NewRequest(i.repo_supabase, "PATCH", url, bytes.NewBuffer(payloadBytes))
+	req, err := repo.NewRequestWithContext(ctx, i.repo_supabase, "PATCH", url, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := i.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -112,9 +150,29 @@ func (i *instagramRepositoryImpl) updateToken(userID string, payloadBytes []byte
 	return nil
 }
 
-func (i *instagramRepositoryImpl) GetInstagramID(accessToken string) (string, error) {
+func (i *instagramRepositoryImpl) DeleteToken(ctx context.Context, userID string) error {
+	url := i.repo_supabase.SupabaseURL + "instagram?user_id=eq." + userID
+	req, err := repo.NewRequestWithContext(ctx, i.repo_supabase, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := i.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete instagram tokens, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (i *instagramRepositoryImpl) GetInstagramID(ctx context.Context, accessToken string) (string, error) {
 	url := "https://graph.instagram.com/me"
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -125,30 +183,23 @@ func (i *instagramRepositoryImpl) GetInstagramID(accessToken string) (string, er
 
 	req.URL.RawQuery = q.Encode()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	body, err := i.send(req)
 	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to fetch user profile, status %d: %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("failed to fetch user profile: %w", err)
 	}
 
 	var result struct {
 		ID string `json:"id"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(body, &result); err != nil {
 		return "", err
 	}
 
 	return result.ID, nil
 }
 
-func (i *instagramRepositoryImpl) GetAccessToken(accessToken string, clientSecret string) (string, int, error) {
-	req, err := http.NewRequest("GET", longTimeTokenURL, nil)
+func (i *instagramRepositoryImpl) GetAccessToken(ctx context.Context, accessToken string, clientSecret string) (string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", longTimeTokenURL, nil)
 	if err != nil {
 		return "", 0, err
 	}
@@ -159,17 +210,11 @@ func (i *instagramRepositoryImpl) GetAccessToken(accessToken string, clientSecre
 	q.Add("access_token", accessToken)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", 0, err
-	}
-	defer resp.Body.Close()
-
 	fmt.Printf("[INSTAGRAM_REPOSITORY] --- Exchanging for long-term token ---")
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", 0, fmt.Errorf("instagram token exchange failed: status %d: %s", resp.StatusCode, string(body))
+	body, err := i.send(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("instagram token exchange failed: %w", err)
 	}
 
 	var longToken struct {
@@ -178,24 +223,30 @@ func (i *instagramRepositoryImpl) GetAccessToken(accessToken string, clientSecre
 		ExpiresIn   int    `json:"expires_in"`
 	}
 
-	if err = json.NewDecoder(resp.Body).Decode(&longToken); err != nil {
+	if err = json.Unmarshal(body, &longToken); err != nil {
 		return "", 0, err
 	}
 
 	return longToken.AccessToken, longToken.ExpiresIn, nil
 }
 
-func (i *instagramRepositoryImpl) CheckTokens(accessToken string) error {
-	resp, err := http.Get("https://graph.instagram.com/me?access_token=" + accessToken)
-	if err != nil || resp.StatusCode != 200 {
-		return fmt.Errorf("tokens have been revoked, please connect your account again")
+func (i *instagramRepositoryImpl) CheckTokens(ctx context.Context, accessToken string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://graph.instagram.com/me?access_token="+accessToken, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := i.send(req); err != nil {
+		if provider.ClassOf(err) == provider.AuthRevoked {
+			return fmt.Errorf("tokens have been revoked, please connect your account again: %w", err)
+		}
+		return fmt.Errorf("failed to verify instagram tokens: %w", err)
 	}
 	return nil
 }
 
-func (i *instagramRepositoryImpl) GetCredentials(userID string) (string, string, error) {
+func (i *instagramRepositoryImpl) GetCredentials(ctx context.Context, userID string) (string, string, error) {
 
-	req, err := http.NewRequest("GET", i.repo_supabase.SupabaseURL+"instagram", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", i.repo_supabase.SupabaseURL+"instagram", nil)
 	if err != nil {
 		return "", "", err
 	}
@@ -206,7 +257,7 @@ func (i *instagramRepositoryImpl) GetCredentials(userID string) (string, string,
 	q.Add("user_id", "eq."+userID)
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := i.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return "", "", err
 	}
@@ -246,40 +297,171 @@ func (i *instagramRepositoryImpl) GetCredentials(userID string) (string, string,
 		return "", "", fmt.Errorf("instagram tokens not found")
 	}
 
-	return instagramModel[0].AccessToken, instagramModel[0].InstagramID, nil
+	accessToken, err := i.keyring.Decrypt(instagramModel[0].AccessToken)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt instagram token: %w", err)
+	}
+
+	return accessToken, instagramModel[0].InstagramID, nil
 }
 
-func (i *instagramRepositoryImpl) CheckPublishLimit(accessToken string, instagramID string) (bool, error) {
+// GetToken returns the stored token row for the user, or nil if the user
+// never linked an Instagram account.
+func (i *instagramRepositoryImpl) GetToken(ctx context.Context, userID string) (*models.InstagramModel, error) {
+	q := url.Values{}
+	q.Add("user_id", "eq."+userID)
+
+	tokens, err := i.selectTokens(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return &tokens[0], nil
+}
+
+// ListExpiring returns the tokens that are still valid but expire before
+// the given time.
+func (i *instagramRepositoryImpl) ListExpiring(ctx context.Context, before time.Time) ([]models.InstagramModel, error) {
+	q := url.Values{}
+	q.Add("expires_at", "gt."+time.Now().UTC().Format(time.RFC3339))
+	q.Add("expires_at", "lt."+before.UTC().Format(time.RFC3339))
+	q.Add("order", "expires_at.asc")
+
+	return i.selectTokens(ctx, q)
+}
+
+// selectTokens returns the matching rows with their access tokens decrypted.
+func (i *instagramRepositoryImpl) selectTokens(ctx context.Context, q url.Values) ([]models.InstagramModel, error) {
+	tokens, err := i.selectRawTokens(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range tokens {
+		tokens[idx].AccessToken, err = i.keyring.Decrypt(tokens[idx].AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt instagram token of user %s: %w", tokens[idx].UserID, err)
+		}
+	}
+	return tokens, nil
+}
+
+func (i *instagramRepositoryImpl) selectRawTokens(ctx context.Context, q url.Values) ([]models.InstagramModel, error) {
+	req, err := repo.NewRequestWithContext(ctx, i.repo_supabase, "GET", i.repo_supabase.SupabaseURL+"instagram?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := i.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch instagram tokens, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var tokens []models.InstagramModel
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RefreshToken exchanges a long-lived token for a new one, returning the
+// new token and its lifetime in seconds.
+func (i *instagramRepositoryImpl) RefreshToken(ctx context.Context, accessToken string) (string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", refreshTokenURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	q := req.URL.Query()
+	q.Add("grant_type", "ig_refresh_token")
+	q.Add("access_token", accessToken)
+	req.URL.RawQuery = q.Encode()
+
+	body, err := i.send(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("instagram token refresh failed: %w", err)
+	}
+
+	var refreshed struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &refreshed); err != nil {
+		return "", 0, err
+	}
+
+	return refreshed.AccessToken, refreshed.ExpiresIn, nil
+}
+
+func (i *instagramRepositoryImpl) RecordRefreshFailure(ctx context.Context, userID string, errMessage string) error {
+	payloadBytes, err := json.Marshal(map[string]string{"refresh_error": errMessage})
+	if err != nil {
+		return err
+	}
+	return i.updateToken(ctx, userID, payloadBytes)
+}
+
+// ReencryptTokens encrypts plaintext rows and rows sealed with an old key
+// with the current key, returning the number of rows updated.
+func (i *instagramRepositoryImpl) ReencryptTokens(ctx context.Context) (int, error) {
+	tokens, err := i.selectRawTokens(ctx, url.Values{})
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, token := range tokens {
+		if !i.keyring.NeedsReencryption(token.AccessToken) {
+			continue
+		}
+
+		accessToken, err := i.keyring.Reencrypt(token.AccessToken)
+		if err != nil {
+			return updated, fmt.Errorf("failed to re-encrypt instagram token of user %s: %w", token.UserID, err)
+		}
+
+		payloadBytes, err := json.Marshal(map[string]string{"access_token": accessToken})
+		if err != nil {
+			return updated, err
+		}
+		if err := i.updateToken(ctx, token.UserID, payloadBytes); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// CheckPublishLimit returns the account's publishing quota.
+func (i *instagramRepositoryImpl) CheckPublishLimit(ctx context.Context, accessToken string, instagramID string) (*ratelimit.Quota, error) {
 	log.Println("[CHECK_PUBLISH_LIMIT] --- Starting check for InstagramID:", instagramID)
 
-	req, err := http.NewRequest("GET", instagram_api_path+instagramID+"/content_publishing_limit", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", instagram_api_path+instagramID+"/content_publishing_limit", nil)
 	if err != nil {
 		log.Printf("[CHECK_PUBLISH_LIMIT] --- Error creating request: %v", err)
-		return false, err
+		return nil, err
 	}
 
 	q := req.URL.Query()
 	q.Add("access_token", accessToken)
 	q.Add("fields", "quota_usage,config")
 	req.URL.RawQuery = q.Encode()
-	log.Printf("[CHECK_PUBLISH_LIMIT] --- Request URL: %s", req.URL.String())
+	// The query carries the access token, so only the endpoint is logged.
+	log.Printf("[CHECK_PUBLISH_LIMIT] --- Request URL: %s://%s%s", req.URL.Scheme, req.URL.Host, req.URL.Path)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	body, err := i.send(req)
 	if err != nil {
-		log.Printf("[CHECK_PUBLISH_LIMIT] --- HTTP request error: %v", err)
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	log.Printf("[CHECK_PUBLISH_LIMIT] --- Response status code: %d", resp.StatusCode)
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("[CHECK_PUBLISH_LIMIT] --- Response body on error: %s", string(body))
-		return false, fmt.Errorf("failed to fetch publishing limit, status %d: %s", resp.StatusCode, string(body))
+		log.Printf("[CHECK_PUBLISH_LIMIT] --- Request failed: %v", err)
+		return nil, fmt.Errorf("failed to fetch publishing limit: %w", err)
 	}
 
-	body, _ := io.ReadAll(resp.Body)
 	log.Printf("[CHECK_PUBLISH_LIMIT] --- Response body: %s", string(body))
 
 	var responseData struct {
@@ -293,38 +475,29 @@ func (i *instagramRepositoryImpl) CheckPublishLimit(accessToken string, instagra
 	}
 	if err := json.Unmarshal(body, &responseData); err != nil {
 		log.Printf("[CHECK_PUBLISH_LIMIT] --- JSON unmarshal error: %v", err)
-		return false, err
+		return nil, err
 	}
 
 	if len(responseData.Data) == 0 {
 		log.Println("[CHECK_PUBLISH_LIMIT] --- No data found in response")
-		return false, fmt.Errorf("no data found in publishing limit response")
+		return nil, fmt.Errorf("no data found in publishing limit response")
 	}
 
 	result := responseData.Data[0]
 
 	log.Printf("[CHECK_PUBLISH_LIMIT] --- QuotaUsage: %d, QuotaTotal: %d", result.QuotaUsage, result.Config.QuotaTotal)
 
-	if result.QuotaUsage >= result.Config.QuotaTotal {
-		log.Println("[CHECK_PUBLISH_LIMIT] --- Publish limit reached or exceeded")
-		return false, nil
-	}
-
-	log.Println("[CHECK_PUBLISH_LIMIT] --- Publish limit not exceeded - can publish")
-	return true, nil
-}
-
-func (i *instagramRepositoryImpl) UploadMedia(file multipart.File, fileExtension string, mimeType string) (string, error) {
-	// Upload media to cloudflare
-	mediaURL, err := i.repo_cloudflare.UploadFile(file, fmt.Sprintf("instagram_%d%s", time.Now().UnixNano(), fileExtension), mimeType)
-	if err != nil {
-		return "", fmt.Errorf("failed to upload media to Cloudflare: %w", err)
-	}
-
-	return mediaURL, nil
+	// The quota is a rolling window, so there is no reset time to report.
+	return &ratelimit.Quota{
+		Platform:  platform,
+		Endpoint:  EndpointContentPublishing,
+		Daily:     true,
+		Limit:     result.Config.QuotaTotal,
+		Remaining: max(result.Config.QuotaTotal-result.QuotaUsage, 0),
+	}, nil
 }
 
-func (i *instagramRepositoryImpl) WaitForContainerReady(accessToken string, containerID string) (string, error) {
+func (i *instagramRepositoryImpl) WaitForContainerReady(ctx context.Context, accessToken string, containerID string) (string, error) {
 	const maxWait = 2 * time.Minute
 	const initialBackoff = 2 * time.Second
 	backoff := initialBackoff
@@ -332,7 +505,7 @@ func (i *instagramRepositoryImpl) WaitForContainerReady(accessToken string, cont
 	start := time.Now()
 
 	for {
-		status, err := i.containerStatus(accessToken, containerID)
+		status, err := i.containerStatus(ctx, accessToken, containerID)
 		if err != nil {
 			return "", fmt.Errorf("error getting container status: %w", err)
 		}
@@ -343,12 +516,22 @@ func (i *instagramRepositoryImpl) WaitForContainerReady(accessToken string, cont
 			return status, nil
 		}
 
+		// Instagram could not fetch or process the media; waiting longer
+		// will not change that.
+		if status == "ERROR" {
+			return "", &provider.Error{Platform: platform, Class: provider.InvalidContent, Message: "Instagram could not process the media"}
+		}
+
 		if time.Since(start) > maxWait {
 			return "", fmt.Errorf("timeout waiting for container to be ready, last status: %s", status)
 		}
 
 		log.Printf("[WAIT_CONTAINER] --- Container not ready, retrying after %v...", backoff)
-		time.Sleep(backoff)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
 
 		// Gradually increase backoff but cap it
 		if backoff < 10*time.Second {
@@ -357,9 +540,9 @@ func (i *instagramRepositoryImpl) WaitForContainerReady(accessToken string, cont
 	}
 }
 
-func (i *instagramRepositoryImpl) containerStatus(accessToken string, containerID string) (string, error) {
+func (i *instagramRepositoryImpl) containerStatus(ctx context.Context, accessToken string, containerID string) (string, error) {
 	url := instagram_api_path + containerID
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -369,29 +552,22 @@ func (i *instagramRepositoryImpl) containerStatus(accessToken string, containerI
 	q := req.URL.Query()
 	q.Add("fields", "status_code")
 	req.URL.RawQuery = q.Encode()
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	body, err := i.send(req)
 	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to fetch container status, status %d: %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("failed to fetch container status: %w", err)
 	}
 
 	var result struct {
 		StatusCode string `json:"status_code"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(body, &result); err != nil {
 		return "", err
 	}
 
 	return result.StatusCode, nil
 }
 
-func (i *instagramRepositoryImpl) CreateContainer(accessToken string, instagramID string, caption string, mediaURL string, mediaType string, isCarouselItem bool) (string, error) {
+func (i *instagramRepositoryImpl) CreateContainer(ctx context.Context, accessToken string, instagramID string, caption string, mediaURL string, mediaType string, altText string, isCarouselItem bool) (string, error) {
 	log.Println("[CREATE_CONTAINER] --- Starting CreateContainer operation")
 	log.Printf("[CREATE_CONTAINER] --- instagramID: %s, mediaType: %s, isCarouselItem: %v", instagramID, mediaType, isCarouselItem)
 	log.Printf("[CREATE_CONTAINER] --- Caption: %.40s...", caption) // shows the first 40 characters if long
@@ -400,7 +576,7 @@ func (i *instagramRepositoryImpl) CreateContainer(accessToken string, instagramI
 	url := instagram_api_path + instagramID + "/media"
 	log.Printf("[CREATE_CONTAINER] --- Request URL: %s", url)
 
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		log.Printf("[CREATE_CONTAINER] --- Error creating new request: %v", err)
 		return "", err
@@ -423,6 +599,10 @@ func (i *instagramRepositoryImpl) CreateContainer(accessToken string, instagramI
 	case "IMAGE":
 		q.Add("image_url", mediaURL)
 		log.Println("[CREATE_CONTAINER] --- Added image_url to query params")
+		if altText != "" {
+			q.Add("alt_text", altText)
+			log.Println("[CREATE_CONTAINER] --- Added alt_text to query params")
+		}
 	case "VIDEO":
 		if !isCarouselItem {
 			q.Add("media_type", "REELS")
@@ -441,26 +621,20 @@ func (i *instagramRepositoryImpl) CreateContainer(accessToken string, instagramI
 	req.URL.RawQuery = q.Encode()
 	log.Printf("[CREATE_CONTAINER] --- Final request URL with query params: %s", req.URL.String())
 
-	client := &http.Client{}
+	// A container created twice is never published, so it is safe to retry.
+	req.Header["Idempotency-Key"] = nil
+
 	log.Println("[CREATE_CONTAINER] --- Sending HTTP request to Instagram API...")
-	resp, err := client.Do(req)
+	body, err := i.send(req)
 	if err != nil {
-		log.Printf("[CREATE_CONTAINER] --- HTTP request failed: %v", err)
-		return "", err
-	}
-	defer resp.Body.Close()
-	log.Printf("[CREATE_CONTAINER] --- HTTP response status: %d", resp.StatusCode)
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("[CREATE_CONTAINER] --- ERROR response: %s", string(body))
-		return "", fmt.Errorf("failed to create container, status %d: %s", resp.StatusCode, string(body))
+		log.Printf("[CREATE_CONTAINER] --- Request failed: %v", err)
+		return "", fmt.Errorf("failed to create container: %w", err)
 	}
 
 	var result struct {
 		ID string `json:"id"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(body, &result); err != nil {
 		log.Printf("[CREATE_CONTAINER] --- Failed to decode response body: %v", err)
 		return "", err
 	}
@@ -469,14 +643,14 @@ func (i *instagramRepositoryImpl) CreateContainer(accessToken string, instagramI
 	return result.ID, nil
 }
 
-func (i *instagramRepositoryImpl) CreateCarouselContainer(accessToken string, instagramID string, caption string, containerIDs []string) (string, error) {
+func (i *instagramRepositoryImpl) CreateCarouselContainer(ctx context.Context, accessToken string, instagramID string, caption string, containerIDs []string) (string, error) {
 	log.Println("[CREATE_CAROUSEL_CONTAINER] --- Starting carousel container creation")
 	log.Printf("[CREATE_CAROUSEL_CONTAINER] --- instagramID: %s, caption length: %d, number of children: %d", instagramID, len(caption), len(containerIDs))
 
 	url := instagram_api_path + instagramID + "/media"
 	log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Request URL: %s", url)
 
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Error creating request: %v", err)
 		return "", err
@@ -497,16 +671,19 @@ func (i *instagramRepositoryImpl) CreateCarouselContainer(accessToken string, in
 	req.URL.RawQuery = q.Encode()
 	log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Final request URL with query params: %s", req.URL.String())
 
-	// Try sending the request and handle potential transient errors
-	resp, err := tryContainerCreation(req)
+	req.Header["Idempotency-Key"] = nil
+
+	log.Println("[CREATE_CAROUSEL_CONTAINER] --- Sending HTTP request to Instagram API...")
+	body, err := i.send(req)
 	if err != nil {
-		return "", err
+		log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Request failed: %v", err)
+		return "", fmt.Errorf("failed to create carousel container: %w", err)
 	}
 
 	var result struct {
 		ID string `json:"id"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(body, &result); err != nil {
 		log.Printf("[CREATE_CAROUSEL_CONTAINER] --- JSON decode error: %v", err)
 		return "", err
 	}
@@ -515,60 +692,14 @@ func (i *instagramRepositoryImpl) CreateCarouselContainer(accessToken string, in
 	return result.ID, nil
 }
 
-func tryContainerCreation(req *http.Request) (*http.Response, error) {
-	const maxRetries = 5
-	backoff := 2 * time.Second
-	client := &http.Client{}
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Attempt %d/%d: Sending HTTP request to Instagram API...", attempt, maxRetries)
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("[CREATE_CAROUSEL_CONTAINER] --- HTTP request error: %v", err)
-			return nil, err
-		}
-
-		if resp.StatusCode == 200 {
-			log.Println("[CREATE_CAROUSEL_CONTAINER] --- Request succeeded with status 200")
-			return resp, nil
-		}
-
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		log.Printf("[CREATE_CAROUSEL_CONTAINER] --- ERROR response body: %s", string(body))
-
-		// Check if error is transient by inspecting the body
-		var apiErr struct {
-			Error struct {
-				IsTransient bool   `json:"is_transient"`
-				Message     string `json:"message"`
-			} `json:"error"`
-		}
-		if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.IsTransient {
-			log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Transient error detected: %s", apiErr.Error.Message)
-			if attempt < maxRetries {
-				log.Printf("[CREATE_CAROUSEL_CONTAINER] --- Retrying after %v...", backoff)
-				time.Sleep(backoff)
-				backoff *= 2
-				continue
-			}
-			return nil, fmt.Errorf("transient error after %d attempts: %s", attempt, apiErr.Error.Message)
-		}
-
-		return nil, fmt.Errorf("failed to create carousel container, status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return nil, fmt.Errorf("max retries (%d) exceeded in tryContainerCreation", maxRetries)
-}
-
-func (i *instagramRepositoryImpl) PublishMedia(accessToken string, instagramID string, creationID string) (string, error) {
+func (i *instagramRepositoryImpl) PublishMedia(ctx context.Context, accessToken string, instagramID string, creationID string) (string, error) {
 	log.Println("[PUBLISH_MEDIA] --- Starting media publish")
 	log.Printf("[PUBLISH_MEDIA] --- instagramID: %s, creationID: %s", instagramID, creationID)
 
 	url := instagram_api_path + instagramID + "/media_publish"
 	log.Printf("[PUBLISH_MEDIA] --- Request URL: %s", url)
 
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		log.Printf("[PUBLISH_MEDIA] --- Error creating request: %v", err)
 		return "", err
@@ -581,26 +712,17 @@ func (i *instagramRepositoryImpl) PublishMedia(accessToken string, instagramID s
 	req.URL.RawQuery = q.Encode()
 	log.Printf("[PUBLISH_MEDIA] --- Final request URL with query: %s", req.URL.String())
 
-	client := &http.Client{}
 	log.Println("[PUBLISH_MEDIA] --- Sending HTTP request to Instagram API...")
-	resp, err := client.Do(req)
+	body, err := i.send(req)
 	if err != nil {
-		log.Printf("[PUBLISH_MEDIA] --- HTTP request error: %v", err)
-		return "", err
-	}
-	defer resp.Body.Close()
-	log.Printf("[PUBLISH_MEDIA] --- HTTP response status: %d", resp.StatusCode)
-
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("[PUBLISH_MEDIA] --- ERROR response body: %s", string(body))
-		return "", fmt.Errorf("failed to publish media, status %d: %s", resp.StatusCode, string(body))
+		log.Printf("[PUBLISH_MEDIA] --- Request failed: %v", err)
+		return "", fmt.Errorf("failed to publish media: %w", err)
 	}
 
 	var result struct {
 		ID string `json:"id"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(body, &result); err != nil {
 		log.Printf("[PUBLISH_MEDIA] --- JSON decode error: %v", err)
 		return "", err
 	}
@@ -608,3 +730,31 @@ func (i *instagramRepositoryImpl) PublishMedia(accessToken string, instagramID s
 	log.Printf("[PUBLISH_MEDIA] --- Media published successfully with ID: %s", result.ID)
 	return result.ID, nil
 }
+
+
+// GetPermalink returns the public instagram.com link of a published post.
+func (i *instagramRepositoryImpl) GetPermalink(ctx context.Context, accessToken string, mediaID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", instagram_api_path+mediaID, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	q := req.URL.Query()
+	q.Add("fields", "permalink")
+	req.URL.RawQuery = q.Encode()
+
+	body, err := i.send(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch permalink: %w", err)
+	}
+
+	var result struct {
+		Permalink string `json:"permalink"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+
+	return result.Permalink, nil
+}
@@ -0,0 +1,280 @@
+package job
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const jobs_path = "scheduled_jobs"
+
+type JobRepository interface {
+	Create(job *models.ScheduledJob) (*models.ScheduledJob, error)
+	GetByID(userID string, jobID string) (*models.ScheduledJob, error)
+	ListByUser(userID string, limit int) ([]models.ScheduledJob, error)
+	ListDue(now time.Time, limit int) ([]models.ScheduledJob, error)
+	Claim(job *models.ScheduledJob, now time.Time) (bool, error)
+	Complete(jobID string, result json.RawMessage, now time.Time) error
+	Fail(jobID string, errMessage string, now time.Time) error
+	Defer(job *models.ScheduledJob, until time.Time, errMessage string) error
+	Heartbeat(jobID string, now time.Time) error
+	Cancel(userID string, jobID string) (bool, error)
+	RequeueStale(staleBefore time.Time, maxAttempts int, now time.Time) (int, int, error)
+}
+
+type jobRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+}
+
+func NewJobRepository(supabaseRepository *repo_supabase.SupabaseRepository) JobRepository {
+	return &jobRepositoryImpl{
+		repo_supabase: supabaseRepository,
+	}
+}
+
+func (j *jobRepositoryImpl) Create(job *models.ScheduledJob) (*models.ScheduledJob, error) {
+	payload := map[string]any{
+		"user_id":       job.UserID,
+		"platform":      job.Platform,
+		"platform_data": job.PlatformData,
+		"media":         job.Media,
+		"scheduled_at":  job.ScheduledAt.UTC().Format(time.RFC3339),
+		"status":        models.JobStatusPending,
+		"attempts":      0,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := j.do("POST", j.repo_supabase.SupabaseURL+jobs_path, payloadBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scheduled job: %w", err)
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("failed to create scheduled job: empty response")
+	}
+	return &jobs[0], nil
+}
+
+func (j *jobRepositoryImpl) GetByID(userID string, jobID string) (*models.ScheduledJob, error) {
+	q := url.Values{}
+	q.Add("id", "eq."+jobID)
+	q.Add("user_id", "eq."+userID)
+
+	jobs, err := j.do("GET", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("scheduled job not found")
+	}
+	return &jobs[0], nil
+}
+
+func (j *jobRepositoryImpl) ListByUser(userID string, limit int) ([]models.ScheduledJob, error) {
+	q := url.Values{}
+	q.Add("user_id", "eq."+userID)
+	q.Add("order", "scheduled_at.desc")
+	q.Add("limit", strconv.Itoa(limit))
+
+	return j.do("GET", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), nil)
+}
+
+func (j *jobRepositoryImpl) ListDue(now time.Time, limit int) ([]models.ScheduledJob, error) {
+	q := url.Values{}
+	q.Add("status", "eq."+models.JobStatusPending)
+	q.Add("scheduled_at", "lte."+now.UTC().Format(time.RFC3339))
+	q.Add("order", "scheduled_at.asc")
+	q.Add("limit", strconv.Itoa(limit))
+
+	return j.do("GET", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), nil)
+}
+
+// Claim moves a pending job to running. The update is filtered on the
+// current status so only one worker can win the claim; the returned bool
+// reports whether this caller did.
+func (j *jobRepositoryImpl) Claim(job *models.ScheduledJob, now time.Time) (bool, error) {
+	q := url.Values{}
+	q.Add("id", "eq."+job.ID)
+	q.Add("status", "eq."+models.JobStatusPending)
+
+	payloadBytes, err := json.Marshal(map[string]any{
+		"status":       models.JobStatusRunning,
+		"attempts":     job.Attempts + 1,
+		"claimed_at":   now.UTC().Format(time.RFC3339),
+		"heartbeat_at": now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	jobs, err := j.do("PATCH", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), payloadBytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim scheduled job %s: %w", job.ID, err)
+	}
+	if len(jobs) == 0 {
+		return false, nil
+	}
+	*job = jobs[0]
+	return true, nil
+}
+
+func (j *jobRepositoryImpl) Complete(jobID string, result json.RawMessage, now time.Time) error {
+	return j.finish(jobID, map[string]any{
+		"status":       models.JobStatusPublished,
+		"result":       result,
+		"last_error":   nil,
+		"completed_at": now.UTC().Format(time.RFC3339),
+	})
+}
+
+func (j *jobRepositoryImpl) Fail(jobID string, errMessage string, now time.Time) error {
+	return j.finish(jobID, map[string]any{
+		"status":       models.JobStatusFailed,
+		"last_error":   errMessage,
+		"completed_at": now.UTC().Format(time.RFC3339),
+	})
+}
+
+// Defer puts a running job back into the pending state, due at until. The
+// attempt is given back, since waiting for a rate limit is not a failure.
+func (j *jobRepositoryImpl) Defer(job *models.ScheduledJob, until time.Time, errMessage string) error {
+	return j.finish(job.ID, map[string]any{
+		"status":       models.JobStatusPending,
+		"scheduled_at": until.UTC().Format(time.RFC3339),
+		"attempts":     max(job.Attempts-1, 0),
+		"last_error":   errMessage,
+	})
+}
+
+// Heartbeat tells other workers that a running job is still being worked
+// on, so that it is not requeued as stale.
+func (j *jobRepositoryImpl) Heartbeat(jobID string, now time.Time) error {
+	q := url.Values{}
+	q.Add("id", "eq."+jobID)
+	q.Add("status", "eq."+models.JobStatusRunning)
+
+	payloadBytes, err := json.Marshal(map[string]any{"heartbeat_at": now.UTC().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.do("PATCH", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), payloadBytes); err != nil {
+		return fmt.Errorf("failed to update scheduled job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+func (j *jobRepositoryImpl) finish(jobID string, payload map[string]any) error {
+	q := url.Values{}
+	q.Add("id", "eq."+jobID)
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.do("PATCH", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), payloadBytes); err != nil {
+		return fmt.Errorf("failed to update scheduled job %s: %w", jobID, err)
+	}
+	return nil
+}
+
+// Cancel only touches jobs that have not been picked up by the worker yet.
+func (j *jobRepositoryImpl) Cancel(userID string, jobID string) (bool, error) {
+	q := url.Values{}
+	q.Add("id", "eq."+jobID)
+	q.Add("user_id", "eq."+userID)
+	q.Add("status", "eq."+models.JobStatusPending)
+
+	payloadBytes, err := json.Marshal(map[string]any{"status": models.JobStatusCancelled})
+	if err != nil {
+		return false, err
+	}
+
+	jobs, err := j.do("PATCH", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), payloadBytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel scheduled job %s: %w", jobID, err)
+	}
+	return len(jobs) > 0, nil
+}
+
+// RequeueStale puts running jobs whose last heartbeat is older than
+// staleBefore back into the pending state. This recovers jobs whose worker
+// died mid-run, e.g. because the server was restarted. Jobs that have
+// already been claimed maxAttempts times are failed instead, so that a job
+// that brings its worker down is not run forever. It returns the number of
+// jobs requeued and failed.
+func (j *jobRepositoryImpl) RequeueStale(staleBefore time.Time, maxAttempts int, now time.Time) (int, int, error) {
+	stale := func() url.Values {
+		q := url.Values{}
+		q.Add("status", "eq."+models.JobStatusRunning)
+		q.Add("heartbeat_at", "lt."+staleBefore.UTC().Format(time.RFC3339))
+		return q
+	}
+
+	q := stale()
+	q.Add("attempts", "gte."+strconv.Itoa(maxAttempts))
+	payloadBytes, err := json.Marshal(map[string]any{
+		"status":       models.JobStatusFailed,
+		"last_error":   fmt.Sprintf("abandoned after %d attempts that did not finish", maxAttempts),
+		"completed_at": now.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	failed, err := j.do("PATCH", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), payloadBytes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fail stale jobs: %w", err)
+	}
+
+	q = stale()
+	q.Add("attempts", "lt."+strconv.Itoa(maxAttempts))
+	payloadBytes, err = json.Marshal(map[string]any{"status": models.JobStatusPending})
+	if err != nil {
+		return 0, len(failed), err
+	}
+	requeued, err := j.do("PATCH", j.repo_supabase.SupabaseURL+jobs_path+"?"+q.Encode(), payloadBytes)
+	if err != nil {
+		return 0, len(failed), fmt.Errorf("failed to requeue stale jobs: %w", err)
+	}
+	return len(requeued), len(failed), nil
+}
+
+func (j *jobRepositoryImpl) do(method string, url string, payload []byte) ([]models.ScheduledJob, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewBuffer(payload)
+	}
+
+	req, err := repo.NewRequest(j.repo_supabase, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := j.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	var jobs []models.ScheduledJob
+	if err := json.Unmarshal(respBody, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return jobs, nil
+}
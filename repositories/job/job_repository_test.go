@@ -0,0 +1,168 @@
+package job
+
+import (
+	"backend/models"
+	repo_supabase "backend/repositories/supabase"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// request is a call the repository made to PostgREST.
+type request struct {
+	method string
+	query  url.Values
+	body   map[string]any
+}
+
+var (
+	now    = time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	nowStr = "2026-10-17T12:00:00Z"
+)
+
+func TestJobRepository(t *testing.T) {
+	tests := []struct {
+		name      string
+		responses []string
+		run       func(t *testing.T, r JobRepository)
+		want      []request
+	}{
+		{
+			name:      "claim counts the attempt",
+			responses: []string{`[{"id":"job-1","status":"running","attempts":3}]`},
+			run: func(t *testing.T, r JobRepository) {
+				job := &models.ScheduledJob{ID: "job-1", Attempts: 2}
+				claimed, err := r.Claim(job, now)
+				if err != nil || !claimed {
+					t.Fatalf("Claim() = %v, %v, want true", claimed, err)
+				}
+				if job.Attempts != 3 || job.Status != models.JobStatusRunning {
+					t.Errorf("job = %+v, want the claimed row", job)
+				}
+			},
+			want: []request{{
+				method: "PATCH",
+				query:  url.Values{"id": {"eq.job-1"}, "status": {"eq.pending"}},
+				body:   map[string]any{"status": "running", "attempts": 3.0, "claimed_at": nowStr, "heartbeat_at": nowStr},
+			}},
+		},
+		{
+			name:      "claim lost to another worker",
+			responses: []string{`[]`},
+			run: func(t *testing.T, r JobRepository) {
+				claimed, err := r.Claim(&models.ScheduledJob{ID: "job-1"}, now)
+				if err != nil || claimed {
+					t.Fatalf("Claim() = %v, %v, want false", claimed, err)
+				}
+			},
+			want: []request{{
+				method: "PATCH",
+				query:  url.Values{"id": {"eq.job-1"}, "status": {"eq.pending"}},
+				body:   map[string]any{"status": "running", "attempts": 1.0, "claimed_at": nowStr, "heartbeat_at": nowStr},
+			}},
+		},
+		{
+			name:      "defer gives the attempt back",
+			responses: []string{`[]`},
+			run: func(t *testing.T, r JobRepository) {
+				if err := r.Defer(&models.ScheduledJob{ID: "job-1", Attempts: 2}, now.Add(time.Hour), "rate limited"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []request{{
+				method: "PATCH",
+				query:  url.Values{"id": {"eq.job-1"}},
+				body:   map[string]any{"status": "pending", "scheduled_at": "2026-10-17T13:00:00Z", "attempts": 1.0, "last_error": "rate limited"},
+			}},
+		},
+		{
+			name:      "heartbeat only touches running jobs",
+			responses: []string{`[]`},
+			run: func(t *testing.T, r JobRepository) {
+				if err := r.Heartbeat("job-1", now); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []request{{
+				method: "PATCH",
+				query:  url.Values{"id": {"eq.job-1"}, "status": {"eq.running"}},
+				body:   map[string]any{"heartbeat_at": nowStr},
+			}},
+		},
+		{
+			name:      "requeue stale fails jobs out of attempts",
+			responses: []string{`[{"id":"job-1"}]`, `[{"id":"job-2"},{"id":"job-3"}]`},
+			run: func(t *testing.T, r JobRepository) {
+				requeued, failed, err := r.RequeueStale(now.Add(-5*time.Minute), 3, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if requeued != 2 || failed != 1 {
+					t.Errorf("RequeueStale() = %d requeued, %d failed, want 2 and 1", requeued, failed)
+				}
+			},
+			want: []request{
+				{
+					method: "PATCH",
+					query:  url.Values{"status": {"eq.running"}, "heartbeat_at": {"lt.2026-10-17T11:55:00Z"}, "attempts": {"gte.3"}},
+					body:   map[string]any{"status": "failed", "last_error": "abandoned after 3 attempts that did not finish", "completed_at": nowStr},
+				},
+				{
+					method: "PATCH",
+					query:  url.Values{"status": {"eq.running"}, "heartbeat_at": {"lt.2026-10-17T11:55:00Z"}, "attempts": {"lt.3"}},
+					body:   map[string]any{"status": "pending"},
+				},
+			},
+		},
+		{
+			name:      "list due",
+			responses: []string{`[]`},
+			run: func(t *testing.T, r JobRepository) {
+				if _, err := r.ListDue(now, 10); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []request{{
+				method: "GET",
+				query:  url.Values{"status": {"eq.pending"}, "scheduled_at": {"lte." + nowStr}, "order": {"scheduled_at.asc"}, "limit": {"10"}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []request
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/"+jobs_path {
+					t.Errorf("path = %q, want /%s", r.URL.Path, jobs_path)
+				}
+				req := request{method: r.Method, query: r.URL.Query()}
+				if data, _ := io.ReadAll(r.Body); len(data) > 0 {
+					if err := json.Unmarshal(data, &req.body); err != nil {
+						t.Errorf("body %q is not JSON: %v", data, err)
+					}
+				}
+				got = append(got, req)
+				if len(got) > len(tt.responses) {
+					http.Error(w, "unexpected request", http.StatusInternalServerError)
+					return
+				}
+				io.WriteString(w, tt.responses[len(got)-1])
+			}))
+			defer server.Close()
+
+			tt.run(t, NewJobRepository(&repo_supabase.SupabaseRepository{
+				SupabaseURL: server.URL + "/",
+				HttpClient:  server.Client(),
+			}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requests = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
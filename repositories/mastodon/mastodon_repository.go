@@ -0,0 +1,401 @@
+package mastodon
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	"backend/repositories/encryption"
+	"backend/repositories/httpclient"
+	"backend/repositories/provider"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	mastodon_path      = "mastodon"
+	mastodon_apps_path = "mastodon_apps"
+	// Scopes requested from every instance.
+	Scopes = "read:accounts write:statuses write:media"
+)
+
+// Media is the attachment returned by /api/v2/media and /api/v1/media/:id.
+// URL stays empty until the instance has finished processing the file.
+type Media struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type Status struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type MastodonRepository interface {
+	GetApp(ctx context.Context, instanceURL string) (*models.MastodonAppModel, error)
+	SaveApp(ctx context.Context, app *models.MastodonAppModel) error
+	RegisterApp(ctx context.Context, instanceURL string, redirectURI string) (*models.MastodonAppModel, error)
+	ExchangeCode(ctx context.Context, app *models.MastodonAppModel, code string) (string, error)
+	SaveToken(ctx context.Context, token *models.MastodonModel) error
+	GetCredentials(ctx context.Context, userID string) (*models.MastodonModel, error)
+	DeleteToken(ctx context.Context, userID string) error
+	ReencryptTokens(ctx context.Context) (int, error)
+	RevokeToken(ctx context.Context, app *models.MastodonAppModel, accessToken string) error
+	VerifyCredentials(ctx context.Context, instanceURL string, accessToken string) (string, error)
+	UploadMedia(ctx context.Context, instanceURL string, accessToken string, file multipart.File, fileName string, description string) (*Media, bool, error)
+	GetMedia(ctx context.Context, instanceURL string, accessToken string, mediaID string) (*Media, bool, error)
+	PostStatus(ctx context.Context, instanceURL string, accessToken string, params url.Values) (*Status, error)
+}
+
+type mastodonRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+	keyring       *encryption.Keyring
+}
+
+func NewMastodonRepository(supabaseRepository *repo_supabase.SupabaseRepository, keyring *encryption.Keyring) MastodonRepository {
+	return &mastodonRepositoryImpl{
+		repo_supabase: supabaseRepository,
+		keyring:       keyring,
+	}
+}
+
+func (m *mastodonRepositoryImpl) GetApp(ctx context.Context, instanceURL string) (*models.MastodonAppModel, error) {
+	var apps []models.MastodonAppModel
+	if err := m.selectRows(ctx, mastodon_apps_path, "instance_url", instanceURL, &apps); err != nil {
+		return nil, fmt.Errorf("failed to fetch mastodon app: %w", err)
+	}
+	if len(apps) == 0 {
+		return nil, nil
+	}
+	return &apps[0], nil
+}
+
+func (m *mastodonRepositoryImpl) SaveApp(ctx context.Context, app *models.MastodonAppModel) error {
+	if err := m.upsert(ctx, mastodon_apps_path, "instance_url", app); err != nil {
+		return fmt.Errorf("failed to save mastodon app: %w", err)
+	}
+	return nil
+}
+
+func (m *mastodonRepositoryImpl) GetCredentials(ctx context.Context, userID string) (*models.MastodonModel, error) {
+	var tokens []models.MastodonModel
+	if err := m.selectRows(ctx, mastodon_path, "user_id", userID, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to fetch mastodon tokens: %w", err)
+	}
+	if len(tokens) == 0 || tokens[0].AccessToken == "" {
+		return nil, fmt.Errorf("mastodon tokens not found")
+	}
+
+	accessToken, err := m.keyring.Decrypt(tokens[0].AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt mastodon token: %w", err)
+	}
+	tokens[0].AccessToken = accessToken
+	return &tokens[0], nil
+}
+
+// SaveToken stores the token with the access token encrypted.
+func (m *mastodonRepositoryImpl) SaveToken(ctx context.Context, token *models.MastodonModel) error {
+	encrypted := *token
+	accessToken, err := m.keyring.Encrypt(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	encrypted.AccessToken = accessToken
+
+	if err := m.upsert(ctx, mastodon_path, "user_id", &encrypted); err != nil {
+		return fmt.Errorf("failed to save mastodon tokens: %w", err)
+	}
+	return nil
+}
+
+// ReencryptTokens encrypts plaintext rows and rows sealed with an old key
+// with the current key, returning the number of rows updated.
+func (m *mastodonRepositoryImpl) ReencryptTokens(ctx context.Context) (int, error) {
+	var tokens []models.MastodonModel
+	if err := m.selectRows(ctx, mastodon_path, "", "", &tokens); err != nil {
+		return 0, fmt.Errorf("failed to fetch mastodon tokens: %w", err)
+	}
+
+	updated := 0
+	for idx := range tokens {
+		token := &tokens[idx]
+		if !m.keyring.NeedsReencryption(token.AccessToken) {
+			continue
+		}
+
+		accessToken, err := m.keyring.Decrypt(token.AccessToken)
+		if err != nil {
+			return updated, fmt.Errorf("failed to decrypt mastodon token of user %s: %w", token.UserID, err)
+		}
+		token.AccessToken = accessToken
+		if err := m.SaveToken(ctx, token); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (m *mastodonRepositoryImpl) DeleteToken(ctx context.Context, userID string) error {
+	req, err := repo.NewRequestWithContext(ctx, m.repo_supabase, "DELETE", m.repo_supabase.SupabaseURL+mastodon_path+"?user_id=eq."+url.QueryEscape(userID), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete mastodon tokens, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// selectRows returns the rows where column equals value, or every row when
+// column is empty.
+func (m *mastodonRepositoryImpl) selectRows(ctx context.Context, table string, column string, value string, result any) error {
+	endpoint := m.repo_supabase.SupabaseURL + table
+	if column != "" {
+		endpoint += "?" + column + "=eq." + url.QueryEscape(value)
+	}
+
+	req, err := repo.NewRequestWithContext(ctx, m.repo_supabase, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (m *mastodonRepositoryImpl) upsert(ctx context.Context, table string, conflictColumn string, row any) error {
+	payloadBytes, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	req, err := repo.NewRequestWithContext(ctx, m.repo_supabase, "POST", m.repo_supabase.SupabaseURL+table+"?on_conflict="+conflictColumn, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Prefer", "return=representation,resolution=merge-duplicates")
+
+	resp, err := m.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// RegisterApp creates an OAuth application on the instance.
+func (m *mastodonRepositoryImpl) RegisterApp(ctx context.Context, instanceURL string, redirectURI string) (*models.MastodonAppModel, error) {
+	form := url.Values{}
+	form.Add("client_name", "Disseminate")
+	form.Add("redirect_uris", redirectURI)
+	form.Add("scopes", Scopes)
+
+	var result struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	if _, err := m.postForm(ctx, instanceURL+"/api/v1/apps", "", form, &result); err != nil {
+		return nil, fmt.Errorf("failed to register app on %s: %w", instanceURL, err)
+	}
+
+	return &models.MastodonAppModel{
+		InstanceURL:  instanceURL,
+		ClientID:     result.ClientID,
+		ClientSecret: result.ClientSecret,
+		RedirectURI:  redirectURI,
+	}, nil
+}
+
+func (m *mastodonRepositoryImpl) ExchangeCode(ctx context.Context, app *models.MastodonAppModel, code string) (string, error) {
+	form := url.Values{}
+	form.Add("grant_type", "authorization_code")
+	form.Add("code", code)
+	form.Add("client_id", app.ClientID)
+	form.Add("client_secret", app.ClientSecret)
+	form.Add("redirect_uri", app.RedirectURI)
+	form.Add("scope", Scopes)
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+	}
+	if _, err := m.postForm(ctx, app.InstanceURL+"/oauth/token", "", form, &result); err != nil {
+		return "", fmt.Errorf("mastodon token exchange failed: %w", err)
+	}
+	return result.AccessToken, nil
+}
+
+// RevokeToken invalidates the access token on the instance.
+func (m *mastodonRepositoryImpl) RevokeToken(ctx context.Context, app *models.MastodonAppModel, accessToken string) error {
+	form := url.Values{}
+	form.Add("client_id", app.ClientID)
+	form.Add("client_secret", app.ClientSecret)
+	form.Add("token", accessToken)
+
+	if _, err := m.postForm(ctx, app.InstanceURL+"/oauth/revoke", "", form, nil); err != nil {
+		return fmt.Errorf("failed to revoke mastodon token: %w", err)
+	}
+	return nil
+}
+
+// VerifyCredentials checks the token and returns the account's username.
+func (m *mastodonRepositoryImpl) VerifyCredentials(ctx context.Context, instanceURL string, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", instanceURL+"/api/v1/accounts/verify_credentials", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var account struct {
+		Username string `json:"username"`
+	}
+	if _, err := m.do(httpclient.PublicAPI, req, &account); err != nil {
+		return "", fmt.Errorf("tokens invalid or revoked: %w", err)
+	}
+	return account.Username, nil
+}
+
+// UploadMedia uploads an attachment through /api/v2/media. The returned bool
+// reports whether the instance has finished processing it; if not, poll
+// GetMedia until it has.
+func (m *mastodonRepositoryImpl) UploadMedia(ctx context.Context, instanceURL string, accessToken string, file multipart.File, fileName string, description string) (*Media, bool, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	part, err := writer.CreateFormFile("file", fileName)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create media request: %w", err)
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return nil, false, fmt.Errorf("failed to write media data to form: %w", err)
+	}
+	if description != "" {
+		if err := writer.WriteField("description", description); err != nil {
+			return nil, false, fmt.Errorf("failed to write description to form: %w", err)
+		}
+	}
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", instanceURL+"/api/v2/media", body)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	// An attachment uploaded twice is never posted, so it is safe to retry.
+	req.Header["Idempotency-Key"] = nil
+
+	var media Media
+	status, err := m.do(httpclient.PublicUpload, req, &media)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to upload media: %w", err)
+	}
+	// 202 Accepted means the file is still being processed.
+	return &media, status == http.StatusOK, nil
+}
+
+func (m *mastodonRepositoryImpl) GetMedia(ctx context.Context, instanceURL string, accessToken string, mediaID string) (*Media, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", instanceURL+"/api/v1/media/"+url.PathEscape(mediaID), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var media Media
+	status, err := m.do(httpclient.PublicAPI, req, &media)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch media status: %w", err)
+	}
+	// 206 Partial Content means the file is still being processed.
+	return &media, status == http.StatusOK, nil
+}
+
+// PostStatus creates the status. It is sent with an Idempotency-Key, which
+// Mastodon uses to drop duplicates, so a retry cannot post it twice.
+func (m *mastodonRepositoryImpl) PostStatus(ctx context.Context, instanceURL string, accessToken string, params url.Values) (*Status, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate idempotency key: %w", err)
+	}
+	req, err := newFormRequest(ctx, instanceURL+"/api/v1/statuses", accessToken, params)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Idempotency-Key", hex.EncodeToString(key))
+
+	var status Status
+	if _, err := m.do(httpclient.PublicAPI, req, &status); err != nil {
+		return nil, fmt.Errorf("failed to post status: %w", err)
+	}
+	return &status, nil
+}
+
+func (m *mastodonRepositoryImpl) postForm(ctx context.Context, endpoint string, accessToken string, form url.Values, result any) (int, error) {
+	req, err := newFormRequest(ctx, endpoint, accessToken, form)
+	if err != nil {
+		return 0, err
+	}
+	return m.do(httpclient.PublicAPI, req, result)
+}
+
+func newFormRequest(ctx context.Context, endpoint string, accessToken string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return req, nil
+}
+
+// do makes a call to the instance under the shared retry policy and returns
+// the status of the response. Failures come back as a *provider.Error.
+func (m *mastodonRepositoryImpl) do(client *http.Client, req *http.Request, result any) (int, error) {
+	resp, body, err := provider.Mastodon.Do(client, req)
+	if err != nil {
+		if resp != nil {
+			return resp.StatusCode, err
+		}
+		return 0, err
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,202 @@
+package media
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const assets_path = "media_assets"
+
+type MediaRepository interface {
+	// Create stores a new asset. If the user already has an asset with the
+	// same hash, nothing is written and the existing asset is returned
+	// with created set to false.
+	Create(asset *models.MediaAsset) (*models.MediaAsset, bool, error)
+	GetByID(userID string, assetID string) (*models.MediaAsset, error)
+	GetByHash(userID string, sha256 string) (*models.MediaAsset, error)
+	List(userID string, limit int, offset int) ([]models.MediaAsset, int, error)
+	Delete(userID string, assetID string) error
+	// CountByHash counts the assets of all users that share an object.
+	CountByHash(sha256 string) (int, error)
+	// CountByObjectKey counts the assets of all users stored under key.
+	CountByObjectKey(key string) (int, error)
+}
+
+type mediaRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+}
+
+func NewMediaRepository(supabaseRepository *repo_supabase.SupabaseRepository) MediaRepository {
+	return &mediaRepositoryImpl{
+		repo_supabase: supabaseRepository,
+	}
+}
+
+func (m *mediaRepositoryImpl) Create(asset *models.MediaAsset) (*models.MediaAsset, bool, error) {
+	// A concurrent upload of the same file may win the race for the
+	// (user_id, sha256) unique key; the row it wrote is returned instead.
+	var created []models.MediaAsset
+	_, err := m.do("POST", assets_path+"?on_conflict=user_id,sha256", asset, &created, "return=representation,resolution=ignore-duplicates")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create media asset: %w", err)
+	}
+	if len(created) > 0 {
+		return &created[0], true, nil
+	}
+
+	existing, err := m.GetByHash(asset.UserID, asset.SHA256)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing == nil {
+		return nil, false, fmt.Errorf("failed to create media asset: empty response")
+	}
+	return existing, false, nil
+}
+
+func (m *mediaRepositoryImpl) GetByID(userID string, assetID string) (*models.MediaAsset, error) {
+	q := url.Values{}
+	q.Add("id", "eq."+assetID)
+	q.Add("user_id", "eq."+userID)
+
+	var assets []models.MediaAsset
+	if _, err := m.do("GET", assets_path+"?"+q.Encode(), nil, &assets, ""); err != nil {
+		return nil, fmt.Errorf("failed to get media asset %s: %w", assetID, err)
+	}
+	if len(assets) == 0 {
+		return nil, fmt.Errorf("media asset %s not found", assetID)
+	}
+	return &assets[0], nil
+}
+
+// GetByHash returns nil when the user has no asset with the hash.
+func (m *mediaRepositoryImpl) GetByHash(userID string, sha256 string) (*models.MediaAsset, error) {
+	q := url.Values{}
+	q.Add("user_id", "eq."+userID)
+	q.Add("sha256", "eq."+sha256)
+
+	var assets []models.MediaAsset
+	if _, err := m.do("GET", assets_path+"?"+q.Encode(), nil, &assets, ""); err != nil {
+		return nil, fmt.Errorf("failed to look up media asset: %w", err)
+	}
+	if len(assets) == 0 {
+		return nil, nil
+	}
+	return &assets[0], nil
+}
+
+// List returns one page of the user's assets, newest first, together with
+// the total number of assets.
+func (m *mediaRepositoryImpl) List(userID string, limit int, offset int) ([]models.MediaAsset, int, error) {
+	q := url.Values{}
+	q.Add("user_id", "eq."+userID)
+	q.Add("order", "created_at.desc")
+	q.Add("limit", strconv.Itoa(limit))
+	q.Add("offset", strconv.Itoa(offset))
+
+	var assets []models.MediaAsset
+	resp, err := m.do("GET", assets_path+"?"+q.Encode(), nil, &assets, "count=exact")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list media assets: %w", err)
+	}
+	return assets, totalFromContentRange(resp.Header.Get("Content-Range"), len(assets)), nil
+}
+
+func (m *mediaRepositoryImpl) Delete(userID string, assetID string) error {
+	q := url.Values{}
+	q.Add("id", "eq."+assetID)
+	q.Add("user_id", "eq."+userID)
+
+	if _, err := m.do("DELETE", assets_path+"?"+q.Encode(), nil, nil, ""); err != nil {
+		return fmt.Errorf("failed to delete media asset %s: %w", assetID, err)
+	}
+	return nil
+}
+
+func (m *mediaRepositoryImpl) CountByHash(sha256 string) (int, error) {
+	q := url.Values{}
+	q.Add("sha256", "eq."+sha256)
+	q.Add("select", "id")
+	q.Add("limit", "1")
+
+	var assets []models.MediaAsset
+	resp, err := m.do("GET", assets_path+"?"+q.Encode(), nil, &assets, "count=exact")
+	if err != nil {
+		return 0, fmt.Errorf("failed to count media assets: %w", err)
+	}
+	return totalFromContentRange(resp.Header.Get("Content-Range"), len(assets)), nil
+}
+
+func (m *mediaRepositoryImpl) CountByObjectKey(key string) (int, error) {
+	q := url.Values{}
+	q.Add("object_key", "eq."+key)
+	q.Add("select", "id")
+	q.Add("limit", "1")
+
+	var assets []models.MediaAsset
+	resp, err := m.do("GET", assets_path+"?"+q.Encode(), nil, &assets, "count=exact")
+	if err != nil {
+		return 0, fmt.Errorf("failed to count media assets: %w", err)
+	}
+	return totalFromContentRange(resp.Header.Get("Content-Range"), len(assets)), nil
+}
+
+// totalFromContentRange reads the total from a PostgREST Content-Range
+// header such as "0-19/42".
+func totalFromContentRange(contentRange string, fallback int) int {
+	idx := strings.LastIndex(contentRange, "/")
+	if idx < 0 {
+		return fallback
+	}
+	total, err := strconv.Atoi(contentRange[idx+1:])
+	if err != nil {
+		return fallback
+	}
+	return total
+}
+
+func (m *mediaRepositoryImpl) do(method string, path string, payload any, result any, prefer string) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := repo.NewRequest(m.repo_supabase, method, m.repo_supabase.SupabaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+
+	resp, err := m.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	if result != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,193 @@
+package post
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	posts_path      = "posts"
+	deliveries_path = "post_deliveries"
+)
+
+// DeliveryFilter narrows down the post history. Zero values are ignored.
+type DeliveryFilter struct {
+	Platform string
+	Status   string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+type PostRepository interface {
+	CreatePost(post *models.Post) (*models.Post, error)
+	CreateDelivery(delivery *models.PostDelivery) (*models.PostDelivery, error)
+	CompleteDelivery(deliveryID string, remoteID string, permalink string, now time.Time) error
+	FailDelivery(deliveryID string, errMessage string) error
+	PartialDelivery(deliveryID string, remoteID string, permalink string, errMessage string, now time.Time) error
+	ListDeliveries(userID string, filter DeliveryFilter) ([]models.PostDelivery, int, error)
+}
+
+type postRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+}
+
+func NewPostRepository(supabaseRepository *repo_supabase.SupabaseRepository) PostRepository {
+	return &postRepositoryImpl{
+		repo_supabase: supabaseRepository,
+	}
+}
+
+func (p *postRepositoryImpl) CreatePost(post *models.Post) (*models.Post, error) {
+	var created []models.Post
+	if _, err := p.do("POST", posts_path, post, &created); err != nil {
+		return nil, fmt.Errorf("failed to create post: %w", err)
+	}
+	if len(created) == 0 {
+		return nil, fmt.Errorf("failed to create post: empty response")
+	}
+	return &created[0], nil
+}
+
+func (p *postRepositoryImpl) CreateDelivery(delivery *models.PostDelivery) (*models.PostDelivery, error) {
+	var created []models.PostDelivery
+	if _, err := p.do("POST", deliveries_path, delivery, &created); err != nil {
+		return nil, fmt.Errorf("failed to create post delivery: %w", err)
+	}
+	if len(created) == 0 {
+		return nil, fmt.Errorf("failed to create post delivery: empty response")
+	}
+	return &created[0], nil
+}
+
+func (p *postRepositoryImpl) CompleteDelivery(deliveryID string, remoteID string, permalink string, now time.Time) error {
+	payload := map[string]any{
+		"status":       models.DeliveryStatusPublished,
+		"remote_id":    remoteID,
+		"permalink":    permalink,
+		"published_at": now.UTC().Format(time.RFC3339),
+	}
+	if _, err := p.do("PATCH", deliveries_path+"?id=eq."+url.QueryEscape(deliveryID), payload, nil); err != nil {
+		return fmt.Errorf("failed to update post delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
+
+func (p *postRepositoryImpl) FailDelivery(deliveryID string, errMessage string) error {
+	payload := map[string]any{
+		"status": models.DeliveryStatusFailed,
+		"error":  errMessage,
+	}
+	if _, err := p.do("PATCH", deliveries_path+"?id=eq."+url.QueryEscape(deliveryID), payload, nil); err != nil {
+		return fmt.Errorf("failed to update post delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
+
+// PartialDelivery records a delivery that failed after the part with
+// remoteID, and maybe others, was published.
+func (p *postRepositoryImpl) PartialDelivery(deliveryID string, remoteID string, permalink string, errMessage string, now time.Time) error {
+	payload := map[string]any{
+		"status":       models.DeliveryStatusPartial,
+		"remote_id":    remoteID,
+		"permalink":    permalink,
+		"error":        errMessage,
+		"published_at": now.UTC().Format(time.RFC3339),
+	}
+	if _, err := p.do("PATCH", deliveries_path+"?id=eq."+url.QueryEscape(deliveryID), payload, nil); err != nil {
+		return fmt.Errorf("failed to update post delivery %s: %w", deliveryID, err)
+	}
+	return nil
+}
+
+// ListDeliveries returns one page of the user's deliveries, newest first,
+// together with the total number of rows matching the filter.
+func (p *postRepositoryImpl) ListDeliveries(userID string, filter DeliveryFilter) ([]models.PostDelivery, int, error) {
+	q := url.Values{}
+	q.Add("user_id", "eq."+userID)
+	if filter.Platform != "" {
+		q.Add("platform", "eq."+filter.Platform)
+	}
+	if filter.Status != "" {
+		q.Add("status", "eq."+filter.Status)
+	}
+	if !filter.Since.IsZero() {
+		q.Add("created_at", "gte."+filter.Since.UTC().Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		q.Add("created_at", "lt."+filter.Until.UTC().Format(time.RFC3339))
+	}
+	q.Add("order", "created_at.desc")
+	q.Add("limit", strconv.Itoa(filter.Limit))
+	q.Add("offset", strconv.Itoa(filter.Offset))
+
+	var deliveries []models.PostDelivery
+	resp, err := p.do("GET", deliveries_path+"?"+q.Encode(), nil, &deliveries)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list post deliveries: %w", err)
+	}
+
+	return deliveries, totalFromContentRange(resp.Header.Get("Content-Range"), len(deliveries)), nil
+}
+
+// totalFromContentRange reads the total from a PostgREST Content-Range
+// header such as "0-19/42".
+func totalFromContentRange(contentRange string, fallback int) int {
+	idx := strings.LastIndex(contentRange, "/")
+	if idx < 0 {
+		return fallback
+	}
+	total, err := strconv.Atoi(contentRange[idx+1:])
+	if err != nil {
+		return fallback
+	}
+	return total
+}
+
+func (p *postRepositoryImpl) do(method string, path string, payload any, result any) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := repo.NewRequest(p.repo_supabase, method, p.repo_supabase.SupabaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if method == "GET" {
+		req.Header.Set("Prefer", "count=exact")
+	}
+
+	resp, err := p.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
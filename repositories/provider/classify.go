@@ -0,0 +1,236 @@
+package provider
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Platform is a platform API and the way it reports errors.
+type Platform struct {
+	Name     string
+	classify func(e *Error, h http.Header, body []byte)
+}
+
+var (
+	X         = Platform{Name: "twitter", classify: classifyX}
+	Instagram = Platform{Name: "instagram", classify: classifyGraph}
+	Bluesky   = Platform{Name: "bluesky", classify: classifyXRPC}
+	Mastodon  = Platform{Name: "mastodon", classify: classifyMastodon}
+)
+
+// Classify builds the error for a response with a status outside 2xx from
+// its status, headers and body.
+func (p Platform) Classify(resp *http.Response, body []byte) *Error {
+	e := &Error{
+		Platform: p.Name,
+		Class:    classifyStatus(resp.StatusCode),
+		Status:   resp.StatusCode,
+		Message:  strings.TrimSpace(string(body)),
+	}
+	p.classify(e, resp.Header, body)
+	if e.RetryAt.IsZero() {
+		if retryAt, ok := ParseRetryAfter(resp.Header); ok {
+			e.RetryAt = retryAt
+		}
+	}
+	return e
+}
+
+// xErrors holds both the problem documents of the v2 API and the error
+// lists of v1.1 and the media upload endpoints.
+type xErrors struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Type   string `json:"type"`
+	Errors []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// X error codes, see https://developer.x.com/en/support/x-api/error-troubleshooting
+var (
+	xAuthCodes      = []int{32, 89, 135, 215, 326}
+	xRateCodes      = []int{88}
+	xTransientCodes = []int{130, 131}
+	xQuotaCodes     = []int{185}
+	xContentCodes   = []int{186, 187, 324, 325}
+)
+
+func classifyX(e *Error, h http.Header, body []byte) {
+	var parsed xErrors
+	if json.Unmarshal(body, &parsed) != nil {
+		return
+	}
+
+	codes := []int{}
+	for _, xErr := range parsed.Errors {
+		codes = append(codes, xErr.Code)
+	}
+	switch {
+	case parsed.Detail != "":
+		e.Message = parsed.Detail
+	case len(parsed.Errors) > 0 && parsed.Errors[0].Message != "":
+		e.Message = parsed.Errors[0].Message
+	}
+	switch {
+	case parsed.Type != "":
+		e.Code = parsed.Type
+	case len(codes) > 0:
+		e.Code = strconv.Itoa(codes[0])
+	}
+	has := func(list []int) bool {
+		return slices.ContainsFunc(codes, func(code int) bool { return slices.Contains(list, code) })
+	}
+
+	switch {
+	case has(xQuotaCodes) || strings.HasSuffix(parsed.Type, "/usage-capped"):
+		e.Class = QuotaExceeded
+	case e.Status == http.StatusTooManyRequests || has(xRateCodes):
+		// The 24-hour limits on posting are daily quotas; the window limit
+		// resets within 15 minutes.
+		e.Class = RateLimited
+		e.RetryAt = xReset(h, "X-Rate-Limit-")
+		for _, prefix := range []string{"X-User-Limit-24hour-", "X-App-Limit-24hour-"} {
+			if h.Get(prefix+"Remaining") == "0" {
+				e.Class = QuotaExceeded
+				e.RetryAt = xReset(h, prefix)
+			}
+		}
+	case has(xAuthCodes) || strings.HasSuffix(parsed.Type, "/oauth1-permissions"):
+		e.Class = AuthRevoked
+	case has(xTransientCodes):
+		e.Class = Transient
+	case has(xContentCodes) || e.Status == http.StatusForbidden:
+		e.Class = InvalidContent
+	}
+}
+
+func xReset(h http.Header, prefix string) time.Time {
+	reset, err := strconv.ParseInt(h.Get(prefix+"Reset"), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(reset, 0)
+}
+
+type graphError struct {
+	Error struct {
+		Message      string `json:"message"`
+		Type         string `json:"type"`
+		Code         int    `json:"code"`
+		ErrorSubcode int    `json:"error_subcode"`
+		IsTransient  bool   `json:"is_transient"`
+		UserMessage  string `json:"error_user_msg"`
+	} `json:"error"`
+}
+
+// Graph API error codes, see
+// https://developers.facebook.com/docs/graph-api/guides/error-handling
+var (
+	graphAuthCodes      = []int{10, 102, 190}
+	graphRateCodes      = []int{4, 17, 32, 613}
+	graphTransientCodes = []int{1, 2}
+	// Subcodes of media Instagram failed to fetch or process on its side.
+	graphTransientSubcodes = []int{2207001, 2207003}
+	// Instagram's limit on posts per 24 hours.
+	graphQuotaSubcodes = []int{2207042}
+)
+
+func classifyGraph(e *Error, h http.Header, body []byte) {
+	var parsed graphError
+	if json.Unmarshal(body, &parsed) != nil || parsed.Error.Code == 0 {
+		return
+	}
+
+	apiErr := parsed.Error
+	e.Code = strconv.Itoa(apiErr.Code)
+	if apiErr.ErrorSubcode != 0 {
+		e.Code += "/" + strconv.Itoa(apiErr.ErrorSubcode)
+	}
+	e.Message = apiErr.Message
+	if apiErr.UserMessage != "" {
+		e.Message = apiErr.UserMessage
+	}
+
+	switch {
+	case slices.Contains(graphQuotaSubcodes, apiErr.ErrorSubcode):
+		e.Class = QuotaExceeded
+	case apiErr.IsTransient || slices.Contains(graphTransientCodes, apiErr.Code) ||
+		slices.Contains(graphTransientSubcodes, apiErr.ErrorSubcode):
+		e.Class = Transient
+	case slices.Contains(graphAuthCodes, apiErr.Code) || (apiErr.Code >= 200 && apiErr.Code < 300):
+		e.Class = AuthRevoked
+	case slices.Contains(graphRateCodes, apiErr.Code) || (apiErr.Code >= 80000 && apiErr.Code < 80100):
+		e.Class = RateLimited
+		e.RetryAt = graphRegainAccess(h)
+	case apiErr.Code == 100 || apiErr.ErrorSubcode/1000 == 2207:
+		e.Class = InvalidContent
+	}
+}
+
+// graphRegainAccess is when Meta expects the most throttled business use
+// case to be usable again, from X-Business-Use-Case-Usage.
+func graphRegainAccess(h http.Header) time.Time {
+	var usage map[string][]struct {
+		EstimatedTimeToRegainAccess int `json:"estimated_time_to_regain_access"`
+	}
+	if json.Unmarshal([]byte(h.Get("X-Business-Use-Case-Usage")), &usage) != nil {
+		return time.Time{}
+	}
+	minutes := 0
+	for _, cases := range usage {
+		for _, c := range cases {
+			minutes = max(minutes, c.EstimatedTimeToRegainAccess)
+		}
+	}
+	if minutes == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(minutes) * time.Minute)
+}
+
+// xrpcError is the error body returned by every XRPC endpoint.
+type xrpcError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func classifyXRPC(e *Error, h http.Header, body []byte) {
+	var parsed xrpcError
+	if json.Unmarshal(body, &parsed) != nil || parsed.Error == "" {
+		return
+	}
+
+	e.Code = parsed.Error
+	e.Message = parsed.Message
+	switch parsed.Error {
+	case "ExpiredToken", "InvalidToken", "AuthRequired", "AuthenticationRequired", "AccountTakedown":
+		e.Class = AuthRevoked
+	case "RateLimitExceeded":
+		e.Class = RateLimited
+		if reset, err := strconv.ParseInt(h.Get("RateLimit-Reset"), 10, 64); err == nil {
+			e.RetryAt = time.Unix(reset, 0)
+		}
+	case "InvalidRequest", "BlobTooLarge", "InvalidMimeType":
+		e.Class = InvalidContent
+	}
+}
+
+func classifyMastodon(e *Error, h http.Header, body []byte) {
+	var parsed struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error != "" {
+		e.Message = parsed.Error
+	}
+	if e.Class == RateLimited {
+		if reset, err := time.Parse(time.RFC3339, h.Get("X-RateLimit-Reset")); err == nil {
+			e.RetryAt = reset
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package provider
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	reset := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	unix := strconv.FormatInt(reset.Unix(), 10)
+
+	tests := []struct {
+		name      string
+		platform  Platform
+		status    int
+		header    http.Header
+		body      string
+		class     Class
+		code      string
+		message   string
+		retryAt   time.Time
+		wantRetry bool
+	}{
+		{
+			name:     "status only",
+			platform: X,
+			status:   http.StatusBadGateway,
+			body:     "bad gateway",
+			class:    Transient,
+			message:  "bad gateway",
+		},
+		{
+			name:     "unknown status",
+			platform: Mastodon,
+			status:   http.StatusConflict,
+			class:    Unknown,
+		},
+		{
+			name:     "x problem document",
+			platform: X,
+			status:   http.StatusForbidden,
+			body:     `{"title":"Forbidden","detail":"You are not allowed to create a Tweet with duplicate content.","type":"about:blank"}`,
+			class:    InvalidContent,
+			code:     "about:blank",
+			message:  "You are not allowed to create a Tweet with duplicate content.",
+		},
+		{
+			name:     "x expired token",
+			platform: X,
+			status:   http.StatusUnauthorized,
+			body:     `{"errors":[{"code":89,"message":"Invalid or expired token."}]}`,
+			class:    AuthRevoked,
+			code:     "89",
+			message:  "Invalid or expired token.",
+		},
+		{
+			name:      "x window rate limit",
+			platform:  X,
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"X-Rate-Limit-Reset": {unix}},
+			body:      `{"title":"Too Many Requests","detail":"Too Many Requests","type":"about:blank"}`,
+			class:     RateLimited,
+			code:      "about:blank",
+			message:   "Too Many Requests",
+			retryAt:   reset,
+			wantRetry: true,
+		},
+		{
+			name:     "x daily limit",
+			platform: X,
+			status:   http.StatusTooManyRequests,
+			header: http.Header{
+				"X-Rate-Limit-Reset":            {"1"},
+				"X-User-Limit-24hour-Remaining": {"0"},
+				"X-User-Limit-24hour-Reset":     {unix},
+			},
+			body:      `{"detail":"Too Many Requests"}`,
+			class:     QuotaExceeded,
+			message:   "Too Many Requests",
+			retryAt:   reset,
+			wantRetry: true,
+		},
+		{
+			name:     "x usage capped",
+			platform: X,
+			status:   http.StatusTooManyRequests,
+			body:     `{"detail":"Usage cap exceeded","type":"https://api.twitter.com/2/problems/usage-capped"}`,
+			class:    QuotaExceeded,
+			code:     "https://api.twitter.com/2/problems/usage-capped",
+			message:  "Usage cap exceeded",
+		},
+		{
+			name:     "x over capacity",
+			platform: X,
+			status:   http.StatusServiceUnavailable,
+			body:     `{"errors":[{"code":130,"message":"Over capacity"}]}`,
+			class:    Transient,
+			code:     "130",
+			message:  "Over capacity",
+		},
+		{
+			name:     "graph expired token",
+			platform: Instagram,
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"message":"Error validating access token","type":"OAuthException","code":190,"error_subcode":463}}`,
+			class:    AuthRevoked,
+			code:     "190/463",
+			message:  "Error validating access token",
+		},
+		{
+			name:     "graph permission",
+			platform: Instagram,
+			status:   http.StatusForbidden,
+			body:     `{"error":{"message":"Permissions error","code":200}}`,
+			class:    AuthRevoked,
+			code:     "200",
+			message:  "Permissions error",
+		},
+		{
+			name:     "graph daily publishing limit",
+			platform: Instagram,
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"message":"Application request limit reached","code":9,"error_subcode":2207042}}`,
+			class:    QuotaExceeded,
+			code:     "9/2207042",
+			message:  "Application request limit reached",
+		},
+		{
+			name:     "graph media not ready",
+			platform: Instagram,
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"message":"Media download has failed","code":9004,"error_subcode":2207003}}`,
+			class:    Transient,
+			code:     "9004/2207003",
+			message:  "Media download has failed",
+		},
+		{
+			name:     "graph transient flag",
+			platform: Instagram,
+			status:   http.StatusInternalServerError,
+			body:     `{"error":{"message":"An unexpected error has occurred","code":2,"is_transient":true}}`,
+			class:    Transient,
+			code:     "2",
+			message:  "An unexpected error has occurred",
+		},
+		{
+			name:     "graph rate limit",
+			platform: Instagram,
+			status:   http.StatusBadRequest,
+			header: http.Header{
+				"X-Business-Use-Case-Usage": {`{"1234":[{"type":"instagram","estimated_time_to_regain_access":30}]}`},
+			},
+			body:      `{"error":{"message":"Application request limit reached","code":4}}`,
+			class:     RateLimited,
+			code:      "4",
+			message:   "Application request limit reached",
+			retryAt:   time.Now().Add(30 * time.Minute),
+			wantRetry: true,
+		},
+		{
+			name:     "graph user message",
+			platform: Instagram,
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"message":"Invalid parameter","code":100,"error_subcode":2207026,"error_user_msg":"The video format is not supported."}}`,
+			class:    InvalidContent,
+			code:     "100/2207026",
+			message:  "The video format is not supported.",
+		},
+		{
+			name:     "xrpc expired token",
+			platform: Bluesky,
+			status:   http.StatusBadRequest,
+			body:     `{"error":"ExpiredToken","message":"Token has expired"}`,
+			class:    AuthRevoked,
+			code:     "ExpiredToken",
+			message:  "Token has expired",
+		},
+		{
+			name:      "xrpc rate limit",
+			platform:  Bluesky,
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"Ratelimit-Reset": {unix}},
+			body:      `{"error":"RateLimitExceeded","message":"Rate Limit Exceeded"}`,
+			class:     RateLimited,
+			code:      "RateLimitExceeded",
+			message:   "Rate Limit Exceeded",
+			retryAt:   reset,
+			wantRetry: true,
+		},
+		{
+			name:     "xrpc blob too large",
+			platform: Bluesky,
+			status:   http.StatusBadRequest,
+			body:     `{"error":"BlobTooLarge","message":"This file is too large"}`,
+			class:    InvalidContent,
+			code:     "BlobTooLarge",
+			message:  "This file is too large",
+		},
+		{
+			name:      "mastodon rate limit",
+			platform:  Mastodon,
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"X-Ratelimit-Reset": {reset.UTC().Format(time.RFC3339)}},
+			body:      `{"error":"Too many requests"}`,
+			class:     RateLimited,
+			message:   "Too many requests",
+			retryAt:   reset,
+			wantRetry: true,
+		},
+		{
+			name:     "mastodon validation",
+			platform: Mastodon,
+			status:   http.StatusUnprocessableEntity,
+			body:     `{"error":"Validation failed: Text can't be blank"}`,
+			class:    InvalidContent,
+			message:  "Validation failed: Text can't be blank",
+		},
+		{
+			name:      "retry after seconds",
+			platform:  Mastodon,
+			status:    http.StatusServiceUnavailable,
+			header:    http.Header{"Retry-After": {"120"}},
+			class:     Transient,
+			retryAt:   time.Now().Add(2 * time.Minute),
+			wantRetry: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			e := tt.platform.Classify(&http.Response{StatusCode: tt.status, Header: header}, []byte(tt.body))
+
+			if e.Platform != tt.platform.Name {
+				t.Errorf("Platform = %q, want %q", e.Platform, tt.platform.Name)
+			}
+			if e.Status != tt.status {
+				t.Errorf("Status = %d, want %d", e.Status, tt.status)
+			}
+			if e.Class != tt.class {
+				t.Errorf("Class = %q, want %q", e.Class, tt.class)
+			}
+			if e.Code != tt.code {
+				t.Errorf("Code = %q, want %q", e.Code, tt.code)
+			}
+			if e.Message != tt.message {
+				t.Errorf("Message = %q, want %q", e.Message, tt.message)
+			}
+			if !tt.wantRetry {
+				if !e.RetryAt.IsZero() {
+					t.Errorf("RetryAt = %v, want zero", e.RetryAt)
+				}
+				return
+			}
+			if diff := e.RetryAt.Sub(tt.retryAt); diff < -5*time.Second || diff > 5*time.Second {
+				t.Errorf("RetryAt = %v, want %v", e.RetryAt, tt.retryAt)
+			}
+		})
+	}
+}
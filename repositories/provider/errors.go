@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Class says what kind of failure a platform reported, which decides
+// whether a call is retried and how the failure is reported to the user.
+type Class string
+
+const (
+	// AuthRevoked means the user's tokens were revoked or have expired; the
+	// account has to be linked again.
+	AuthRevoked Class = "auth_revoked"
+	// RateLimited means too many calls were made in a short window.
+	RateLimited Class = "rate_limited"
+	// Transient covers outages, timeouts and dropped connections, which
+	// are expected to go away on their own.
+	Transient Class = "transient"
+	// InvalidContent means the platform rejected the post or its media.
+	InvalidContent Class = "invalid_content"
+	// QuotaExceeded means a daily or monthly cap was used up.
+	QuotaExceeded Class = "quota_exceeded"
+	// Unknown is any other failure.
+	Unknown Class = "unknown"
+)
+
+var descriptions = map[Class]string{
+	AuthRevoked:    "access was revoked",
+	RateLimited:    "rate limit reached",
+	Transient:      "is temporarily unavailable",
+	InvalidContent: "rejected the request",
+	QuotaExceeded:  "quota exceeded",
+	Unknown:        "request failed",
+}
+
+// Error is a failed call to a platform API. Status and Code are the HTTP
+// status and the platform's own error code, when there was a response.
+// RetryAt is when the platform said the call can be made again, if it did.
+type Error struct {
+	Platform string
+	Class    Class
+	Status   int
+	Code     string
+	Message  string
+	RetryAt  time.Time
+	Err      error
+}
+
+func (e *Error) Error() string {
+	msg := e.Platform + " " + descriptions[e.Class]
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.Status)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if !e.RetryAt.IsZero() {
+		msg += ", try again after " + e.RetryAt.UTC().Format(time.RFC3339)
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter is how long to wait from now before RetryAt, rounded up to
+// whole seconds, or 0 if the platform did not say.
+func (e *Error) RetryAfter() time.Duration {
+	if e.RetryAt.IsZero() {
+		return 0
+	}
+	return max(time.Until(e.RetryAt).Truncate(time.Second)+time.Second, 0)
+}
+
+// As returns the provider error in err's chain, if there is one.
+func As(err error) (*Error, bool) {
+	var providerErr *Error
+	ok := errors.As(err, &providerErr)
+	return providerErr, ok
+}
+
+// ClassOf returns the class of the provider error in err's chain, or "" if
+// err did not come from a platform.
+func ClassOf(err error) Class {
+	if providerErr, ok := As(err); ok {
+		return providerErr.Class
+	}
+	return ""
+}
+
+// ParseRetryAfter reads a Retry-After header, given either in seconds or
+// as an HTTP date.
+func ParseRetryAfter(h http.Header) (time.Time, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Now().Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// classifyStatus is the class of an error response that the platform did
+// not describe any further.
+func classifyStatus(status int) Class {
+	switch {
+	case status == http.StatusUnauthorized:
+		return AuthRevoked
+	case status == http.StatusTooManyRequests:
+		return RateLimited
+	case status == http.StatusRequestTimeout || status >= 500:
+		return Transient
+	case status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge ||
+		status == http.StatusUnsupportedMediaType || status == http.StatusUnprocessableEntity:
+		return InvalidContent
+	}
+	return Unknown
+}
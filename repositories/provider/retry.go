@@ -0,0 +1,185 @@
+package provider
+
+import (
+	"backend/repositories/httpclient"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Policy decides how often and after how long a failed call is retried.
+// Only transient errors and rate limits are retried. The wait before each
+// retry doubles from BaseDelay up to MaxDelay and a random part of it is
+// left out, so that calls that failed together do not retry together. When
+// the platform says when to come back, that is waited for instead, as long
+// as it is within MaxRetryAfter; a call that would have to wait longer
+// fails right away, so that the publish can be deferred instead.
+type Policy struct {
+	Attempts      int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxRetryAfter time.Duration
+}
+
+// DefaultPolicy is used for every call to a platform API.
+var DefaultPolicy = Policy{
+	Attempts:      4,
+	BaseDelay:     time.Second,
+	MaxDelay:      15 * time.Second,
+	MaxRetryAfter: time.Minute,
+}
+
+// backoff is the wait before retry number attempt, counted from 0: half of
+// the exponential delay and a random share of the other half.
+func (p Policy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << min(attempt, 30)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// wait is how long to wait before retrying after err, and whether to retry
+// at all.
+func (p Policy) wait(attempt int, err error) (time.Duration, bool) {
+	providerErr, ok := As(err)
+	if !ok || attempt+1 >= p.Attempts {
+		return 0, false
+	}
+	if providerErr.Class != Transient && providerErr.Class != RateLimited {
+		return 0, false
+	}
+
+	delay := p.backoff(attempt)
+	if !providerErr.RetryAt.IsZero() {
+		retryAfter := time.Until(providerErr.RetryAt)
+		if retryAfter > p.MaxRetryAfter {
+			return 0, false
+		}
+		delay = max(delay, retryAfter)
+	}
+	return delay, true
+}
+
+// final wraps an error that Retry returns without retrying, whatever its
+// class.
+type final struct {
+	err error
+}
+
+func (f *final) Error() string {
+	return f.err.Error()
+}
+
+// Retry calls fn until it succeeds, fails with an error the policy does not
+// retry, or ctx is done.
+func Retry[T any](ctx context.Context, policy Policy, fn func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		result, err := fn()
+		if err == nil {
+			return result, nil
+		}
+		if f, ok := err.(*final); ok {
+			return result, f.err
+		}
+		delay, retry := policy.wait(attempt, err)
+		if !retry {
+			return result, err
+		}
+		log.Printf("[PROVIDER] --- %v, retrying in %v (attempt %d of %d)", err, delay.Round(time.Millisecond), attempt+2, policy.Attempts)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
+
+// Do sends req with client under DefaultPolicy and returns the response
+// with its body read, or the classified error. A response is returned with
+// the error when the platform answered, so that its headers can still be
+// looked at. Retries send the body again from req.GetBody, which
+// http.NewRequest sets for in-memory bodies; a request whose body cannot
+// be sent again is only tried once.
+//
+// A request that failed may still have taken effect: X and Meta answer
+// with a 5xx after a post was created often enough. So only idempotent
+// requests are sent again after a transient failure: a GET, PUT or DELETE,
+// or a request marked as safe to repeat with an Idempotency-Key header,
+// which net/http does not send when its value is nil. Other requests are
+// only sent again when they were turned away for a rate limit, which
+// proves nothing was created.
+func (p Platform) Do(client *http.Client, req *http.Request) (*http.Response, []byte, error) {
+	policy := DefaultPolicy
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		policy.Attempts = 1
+	}
+
+	var resp *http.Response
+	attempt := 0
+	body, err := Retry(req.Context(), policy, func() ([]byte, error) {
+		send := req
+		if attempt > 0 && req.GetBody != nil {
+			reqBody, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			send = req.Clone(req.Context())
+			send.Body = reqBody
+		}
+		attempt++
+
+		var err error
+		resp, err = client.Do(send)
+		if err != nil {
+			resp = nil
+			return nil, p.transportError(req, err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, p.transportError(req, err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			providerErr := p.Classify(resp, body)
+			if providerErr.Class != RateLimited && !idempotent(req) {
+				return body, &final{providerErr}
+			}
+			return body, providerErr
+		}
+		return body, nil
+	})
+	return resp, body, err
+}
+
+// transportError classifies a call that got no complete response. It is
+// left as is when the caller gave up or the host is not on the internet, so
+// that it is not retried.
+func (p Platform) transportError(req *http.Request, err error) error {
+	if req.Context().Err() != nil || errors.Is(err, httpclient.ErrNotPublic) {
+		return err
+	}
+	providerErr := &Error{Platform: p.Name, Class: Transient, Message: err.Error(), Err: err}
+	if !idempotent(req) {
+		return &final{providerErr}
+	}
+	return providerErr
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, marked := req.Header["Idempotency-Key"]
+	return marked
+}
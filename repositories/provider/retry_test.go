@@ -0,0 +1,204 @@
+package provider
+
+import (
+	"backend/repositories/httpclient"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	Attempts:      4,
+	BaseDelay:     time.Millisecond,
+	MaxDelay:      4 * time.Millisecond,
+	MaxRetryAfter: time.Second,
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	policy := Policy{BaseDelay: time.Second, MaxDelay: 15 * time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 15 * time.Second},
+		{62, 15 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			delay := policy.backoff(tt.attempt)
+			if delay < tt.max/2 || delay > tt.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tt.attempt, delay, tt.max/2, tt.max)
+			}
+		}
+	}
+}
+
+func TestPolicyWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		retry   bool
+		atLeast time.Duration
+	}{
+		{"transient", 0, &Error{Class: Transient}, true, 0},
+		{"rate limited", 0, &Error{Class: RateLimited}, true, 0},
+		{"wrapped", 0, errors.Join(errors.New("upload"), &Error{Class: Transient}), true, 0},
+		{"last attempt", 3, &Error{Class: Transient}, false, 0},
+		{"auth revoked", 0, &Error{Class: AuthRevoked}, false, 0},
+		{"invalid content", 0, &Error{Class: InvalidContent}, false, 0},
+		{"quota exceeded", 0, &Error{Class: QuotaExceeded, RetryAt: time.Now().Add(time.Millisecond)}, false, 0},
+		{"not a provider error", 0, errors.New("boom"), false, 0},
+		{"retry at within limit", 0, &Error{Class: RateLimited, RetryAt: time.Now().Add(500 * time.Millisecond)}, true, 400 * time.Millisecond},
+		{"retry at too late", 0, &Error{Class: RateLimited, RetryAt: time.Now().Add(time.Hour)}, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, retry := testPolicy.wait(tt.attempt, tt.err)
+			if retry != tt.retry {
+				t.Fatalf("wait() retry = %v, want %v", retry, tt.retry)
+			}
+			if retry && delay < tt.atLeast {
+				t.Errorf("wait() delay = %v, want at least %v", delay, tt.atLeast)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		fails bool
+	}{
+		{"succeeds", nil, 1, false},
+		{"recovers", []error{&Error{Class: Transient}, &Error{Class: RateLimited}}, 3, false},
+		{"gives up", []error{&Error{Class: Transient}, &Error{Class: Transient}, &Error{Class: Transient}, &Error{Class: Transient}}, 4, true},
+		{"not retried", []error{&Error{Class: InvalidContent}}, 1, true},
+		{"final", []error{&final{&Error{Class: Transient}}}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			result, err := Retry(context.Background(), testPolicy, func() (int, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return 0, tt.errs[calls-1]
+				}
+				return 42, nil
+			})
+			if calls != tt.calls {
+				t.Errorf("calls = %d, want %d", calls, tt.calls)
+			}
+			if (err != nil) != tt.fails {
+				t.Fatalf("err = %v, want failure %v", err, tt.fails)
+			}
+			if _, ok := err.(*final); ok {
+				t.Errorf("err = %T, want it unwrapped", err)
+			}
+			if !tt.fails && result != 42 {
+				t.Errorf("result = %d, want 42", result)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := Retry(ctx, Policy{Attempts: 4, BaseDelay: time.Hour, MaxDelay: time.Hour}, func() (int, error) {
+		calls++
+		cancel()
+		return 0, &Error{Class: Transient}
+	})
+	if calls != 1 || err == nil {
+		t.Fatalf("calls = %d, err = %v, want 1 call and an error", calls, err)
+	}
+}
+
+func TestDo(t *testing.T) {
+	previous := DefaultPolicy
+	DefaultPolicy = testPolicy
+	t.Cleanup(func() { DefaultPolicy = previous })
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		idempotent bool
+		statuses   []int
+		calls      int32
+		class      Class
+	}{
+		{"get retried", http.MethodGet, "", false, []int{503, 502, 200}, 3, ""},
+		{"post not retried after 5xx", http.MethodPost, "status=hi", false, []int{503, 200}, 1, Transient},
+		{"post retried after 429", http.MethodPost, "status=hi", false, []int{429, 200}, 2, ""},
+		{"post with idempotency key retried", http.MethodPost, "status=hi", true, []int{500, 200}, 2, ""},
+		{"client error not retried", http.MethodGet, "", false, []int{400, 200}, 1, InvalidContent},
+		{"gives up", http.MethodGet, "", false, []int{503, 503, 503, 503, 200}, 4, Transient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := calls.Add(1)
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != tt.body {
+					t.Errorf("call %d sent body %q, want %q", call, body, tt.body)
+				}
+				w.WriteHeader(tt.statuses[call-1])
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.idempotent {
+				req.Header["Idempotency-Key"] = nil
+			}
+
+			_, _, err = X.Do(server.Client(), req)
+			if calls.Load() != tt.calls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tt.calls)
+			}
+			if ClassOf(err) != tt.class {
+				t.Errorf("class = %q, want %q (err %v)", ClassOf(err), tt.class, err)
+			}
+		})
+	}
+}
+
+func TestDoRefusesPrivateHosts(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = Mastodon.Do(httpclient.PublicAPI, req)
+	if !errors.Is(err, httpclient.ErrNotPublic) {
+		t.Fatalf("err = %v, want ErrNotPublic", err)
+	}
+	if _, ok := As(err); ok {
+		t.Errorf("err = %v, want it left unclassified", err)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("calls = %d, want none", calls.Load())
+	}
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// xLimits are the header prefixes of the limits X reports on a response,
+// with the suffix the tracker adds to the endpoint name for each. The
+// window limit applies to the endpoint; the 24-hour limits apply to posting
+// endpoints, per user and for the whole app.
+var xLimits = []struct {
+	prefix string
+	suffix string
+	shared bool
+	daily  bool
+}{
+	{prefix: "X-Rate-Limit-"},
+	{prefix: "X-User-Limit-24hour-", suffix: " (24h per user)", daily: true},
+	{prefix: "X-App-Limit-24hour-", suffix: " (24h per app)", shared: true, daily: true},
+}
+
+// XQuotas returns the limits in the x-rate-limit-* style headers of an X
+// API response.
+func XQuotas(platform string, endpoint string, h http.Header) []Quota {
+	quotas := []Quota{}
+	for _, l := range xLimits {
+		limit, err := strconv.Atoi(h.Get(l.prefix + "Limit"))
+		if err != nil || limit <= 0 {
+			continue
+		}
+		remaining, err := strconv.Atoi(h.Get(l.prefix + "Remaining"))
+		if err != nil {
+			continue
+		}
+		quota := Quota{
+			Platform:  platform,
+			Endpoint:  endpoint + l.suffix,
+			Shared:    l.shared,
+			Daily:     l.daily,
+			Limit:     limit,
+			Remaining: remaining,
+		}
+		if reset, err := strconv.ParseInt(h.Get(l.prefix+"Reset"), 10, 64); err == nil {
+			resetAt := time.Unix(reset, 0)
+			quota.ResetAt = &resetAt
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas
+}
+
+// XNeeds returns the needs of making calls to an X API endpoint: its window
+// limit and, on posting endpoints, the 24-hour limits.
+func XNeeds(endpoint string, calls int) []Need {
+	needs := make([]Need, len(xLimits))
+	for idx, l := range xLimits {
+		needs[idx] = Need{Endpoint: endpoint + l.suffix, Calls: calls}
+	}
+	return needs
+}
+
+// metaUsage is the usage Meta reports in X-App-Usage and, per business
+// object, in X-Business-Use-Case-Usage. Each figure is a percentage of the
+// allowance.
+type metaUsage struct {
+	Type                        string  `json:"type"`
+	CallCount                   float64 `json:"call_count"`
+	TotalCPUTime                float64 `json:"total_cputime"`
+	TotalTime                   float64 `json:"total_time"`
+	EstimatedTimeToRegainAccess int     `json:"estimated_time_to_regain_access"`
+}
+
+func (u *metaUsage) quota(platform string, endpoint string, shared bool) Quota {
+	quota := Quota{
+		Platform:    platform,
+		Endpoint:    endpoint,
+		Shared:      shared,
+		UsedPercent: max(u.CallCount, u.TotalCPUTime, u.TotalTime),
+	}
+	// Meta only gives a reset time once it has started throttling.
+	if u.EstimatedTimeToRegainAccess > 0 {
+		resetAt := time.Now().Add(time.Duration(u.EstimatedTimeToRegainAccess) * time.Minute)
+		quota.ResetAt = &resetAt
+		quota.UsedPercent = max(quota.UsedPercent, 100)
+	}
+	return quota
+}
+
+// MetaQuotas returns the usage in the X-App-Usage and
+// X-Business-Use-Case-Usage headers of a Graph API response. App usage is
+// shared by every account; business use case usage is named after its type.
+func MetaQuotas(platform string, h http.Header) []Quota {
+	quotas := []Quota{}
+
+	var app metaUsage
+	if value := h.Get("X-App-Usage"); value != "" && json.Unmarshal([]byte(value), &app) == nil {
+		quotas = append(quotas, app.quota(platform, "app", true))
+	}
+
+	var business map[string][]metaUsage
+	if value := h.Get("X-Business-Use-Case-Usage"); value != "" && json.Unmarshal([]byte(value), &business) == nil {
+		for _, usages := range business {
+			for _, usage := range usages {
+				quotas = append(quotas, usage.quota(platform, "business_use_case/"+usage.Type, false))
+			}
+		}
+	}
+	return quotas
+}
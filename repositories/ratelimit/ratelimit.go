@@ -0,0 +1,243 @@
+package ratelimit
+
+import (
+	"backend/repositories/provider"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Meta only reports usage as a percentage and starts throttling at 100,
+	// so a publish is held back once a usage quota reaches this.
+	nearLimitPercent = 95
+	// A quota that comes without a reset time, such as Meta's rolling
+	// usage, is only trusted for this long after it was reported.
+	unknownResetHold = 15 * time.Minute
+	// Quotas that have not been reported for this long are forgotten.
+	retention     = 24 * time.Hour
+	sweepInterval = time.Hour
+)
+
+// Quota is the state of one rate limit as last reported by a platform.
+// Limit and Remaining count calls, or posts for Instagram's publishing
+// quota. Meta reports its API usage only as a percentage; those quotas have
+// no Limit and only UsedPercent is set. Shared quotas are counted for the
+// whole app rather than for one account. Daily quotas cap a day's use
+// rather than a short window.
+type Quota struct {
+	Platform    string     `json:"platform"`
+	Endpoint    string     `json:"endpoint"`
+	Shared      bool       `json:"shared,omitempty"`
+	Daily       bool       `json:"daily,omitempty"`
+	Limit       int        `json:"limit,omitempty"`
+	Remaining   int        `json:"remaining"`
+	UsedPercent float64    `json:"used_percent"`
+	ResetAt     *time.Time `json:"reset_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	// Limited and RetryAt are filled in by List: whether a call made now
+	// would be held back, and until when.
+	Limited bool       `json:"limited"`
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+}
+
+// limited reports whether making that many more calls would exceed the
+// quota, and when the quota is expected to have room again.
+func (q *Quota) limited(calls int, now time.Time) (time.Time, bool) {
+	retryAt := q.UpdatedAt.Add(unknownResetHold)
+	if q.ResetAt != nil {
+		retryAt = *q.ResetAt
+	}
+	if !retryAt.After(now) {
+		// The window the quota was reported for is over.
+		return time.Time{}, false
+	}
+	if q.Limit > 0 {
+		// A publish that needs more calls than a whole window allows is let
+		// through while there is room, rather than held back forever.
+		return retryAt, q.Remaining < min(calls, q.Limit)
+	}
+	return retryAt, q.UsedPercent >= nearLimitPercent
+}
+
+// Need is the number of calls a publish is about to make to an endpoint.
+type Need struct {
+	Endpoint string
+	Calls    int
+}
+
+type accountKey struct{}
+
+// WithAccount tags ctx with the account that the platform calls made with
+// it are counted against.
+func WithAccount(ctx context.Context, account string) context.Context {
+	return context.WithValue(ctx, accountKey{}, account)
+}
+
+// AccountFrom returns the account ctx was tagged with, or "".
+func AccountFrom(ctx context.Context) string {
+	account, _ := ctx.Value(accountKey{}).(string)
+	return account
+}
+
+type key struct {
+	platform string
+	account  string
+	endpoint string
+}
+
+// Tracker keeps the rate limits reported by the platforms in memory, per
+// account and endpoint, so that publishes that would run into them can be
+// held back before anything is uploaded.
+type Tracker struct {
+	mu     sync.Mutex
+	quotas map[key]*Quota
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{quotas: map[key]*Quota{}}
+}
+
+// Record stores a quota reported for the account. Shared quotas are stored
+// once for the app; other quotas are dropped when the account is unknown.
+func (t *Tracker) Record(account string, quota Quota) {
+	if quota.Shared {
+		account = ""
+	} else if account == "" {
+		return
+	}
+	if quota.UpdatedAt.IsZero() {
+		quota.UpdatedAt = time.Now()
+	}
+	if quota.Limit > 0 {
+		quota.UsedPercent = float64(quota.Limit-quota.Remaining) * 100 / float64(quota.Limit)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.quotas[key{quota.Platform, account, quota.Endpoint}] = &quota
+}
+
+// Consume counts calls against a quota that the platform does not report
+// on every call, such as Instagram's publishing quota.
+func (t *Tracker) Consume(platform string, account string, endpoint string, calls int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if q, ok := t.quotas[key{platform, account, endpoint}]; ok && q.Limit > 0 {
+		q.Remaining = max(q.Remaining-calls, 0)
+		q.UsedPercent = float64(q.Limit-q.Remaining) * 100 / float64(q.Limit)
+	}
+}
+
+// Fresh reports whether the quota was reported less than maxAge ago.
+func (t *Tracker) Fresh(platform string, account string, endpoint string, maxAge time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.quotas[key{platform, account, endpoint}]
+	return ok && time.Since(q.UpdatedAt) < maxAge
+}
+
+// exceeded is the error for a call held back by the quota.
+func (q *Quota) exceeded(retryAt time.Time) *provider.Error {
+	class := provider.RateLimited
+	if q.Daily {
+		class = provider.QuotaExceeded
+	}
+	return &provider.Error{
+		Platform: q.Platform,
+		Class:    class,
+		Message:  fmt.Sprintf("not enough calls left for %s", q.Endpoint),
+		RetryAt:  retryAt,
+	}
+}
+
+// Check returns a *provider.Error if one of needs would exceed the account's
+// or the app's quota for that endpoint. Usage quotas cover every call to
+// the platform, so they are checked whatever the needs are.
+func (t *Tracker) Check(platform string, account string, needs ...Need) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for _, need := range needs {
+		for _, owner := range []string{account, ""} {
+			if q, ok := t.quotas[key{platform, owner, need.Endpoint}]; ok {
+				if retryAt, limited := q.limited(need.Calls, now); limited {
+					return q.exceeded(retryAt)
+				}
+			}
+		}
+	}
+	for k, q := range t.quotas {
+		if k.platform != platform || (k.account != account && k.account != "") || q.Limit > 0 {
+			continue
+		}
+		if retryAt, limited := q.limited(1, now); limited {
+			return q.exceeded(retryAt)
+		}
+	}
+	return nil
+}
+
+// List returns the account's quotas on every platform and the quotas shared
+// by the app, sorted by platform and endpoint.
+func (t *Tracker) List(account string) []Quota {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	quotas := []Quota{}
+	for k, q := range t.quotas {
+		if k.account != account && k.account != "" {
+			continue
+		}
+		quota := *q
+		if retryAt, limited := q.limited(1, now); limited {
+			quota.Limited = true
+			quota.RetryAt = &retryAt
+		}
+		quotas = append(quotas, quota)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Platform != quotas[j].Platform {
+			return quotas[i].Platform < quotas[j].Platform
+		}
+		return quotas[i].Endpoint < quotas[j].Endpoint
+	})
+	return quotas
+}
+
+// Run forgets quotas that have not been reported for a day until ctx is
+// cancelled.
+func (t *Tracker) Run(ctx context.Context) {
+	log.Println("[RATE_LIMIT] --- Worker started")
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("[RATE_LIMIT] --- Worker stopped")
+			return
+		case <-ticker.C:
+			t.sweep(time.Now().Add(-retention))
+		}
+	}
+}
+
+func (t *Tracker) sweep(before time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, q := range t.quotas {
+		if q.UpdatedAt.Before(before) {
+			delete(t.quotas, k)
+		}
+	}
+}
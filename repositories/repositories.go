@@ -2,6 +2,7 @@ package repositories
 
 import (
 	repo_supabase "backend/repositories/supabase"
+	"context"
 	"io"
 	"net/http"
 )
@@ -9,7 +10,12 @@ import (
 // NewRequest creates a new HTTP request with Supabase authentication headers
 // This is a package-level function that can be called directly after importing the package
 func NewRequest(supabaseRepository *repo_supabase.SupabaseRepository, method, url string, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, body)
+	return NewRequestWithContext(context.Background(), supabaseRepository, method, url, body)
+}
+
+// NewRequestWithContext is NewRequest for a request that is cancelled with ctx.
+func NewRequestWithContext(ctx context.Context, supabaseRepository *repo_supabase.SupabaseRepository, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,103 @@
+package staging
+
+import (
+	"backend/models"
+	repo "backend/repositories"
+	repo_supabase "backend/repositories/supabase"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const staged_objects_path = "staged_objects"
+
+type StagingRepository interface {
+	// Track records a staged object. Tracking a key again replaces its
+	// purpose and expiry.
+	Track(object *models.StagedObject) error
+	Untrack(key string) error
+	// ListExpired returns up to limit objects that expired before the
+	// given time, oldest first.
+	ListExpired(before time.Time, limit int) ([]models.StagedObject, error)
+}
+
+type stagingRepositoryImpl struct {
+	repo_supabase *repo_supabase.SupabaseRepository
+}
+
+func NewStagingRepository(supabaseRepository *repo_supabase.SupabaseRepository) StagingRepository {
+	return &stagingRepositoryImpl{
+		repo_supabase: supabaseRepository,
+	}
+}
+
+func (s *stagingRepositoryImpl) Track(object *models.StagedObject) error {
+	if err := s.do("POST", staged_objects_path+"?on_conflict=key", object, nil, "return=minimal,resolution=merge-duplicates"); err != nil {
+		return fmt.Errorf("failed to track staged object %s: %w", object.Key, err)
+	}
+	return nil
+}
+
+func (s *stagingRepositoryImpl) Untrack(key string) error {
+	q := url.Values{}
+	q.Add("key", "eq."+key)
+
+	if err := s.do("DELETE", staged_objects_path+"?"+q.Encode(), nil, nil, "return=minimal"); err != nil {
+		return fmt.Errorf("failed to untrack staged object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *stagingRepositoryImpl) ListExpired(before time.Time, limit int) ([]models.StagedObject, error) {
+	q := url.Values{}
+	q.Add("expires_at", "lt."+before.UTC().Format(time.RFC3339))
+	q.Add("order", "expires_at.asc")
+	q.Add("limit", strconv.Itoa(limit))
+
+	var objects []models.StagedObject
+	if err := s.do("GET", staged_objects_path+"?"+q.Encode(), nil, &objects, ""); err != nil {
+		return nil, fmt.Errorf("failed to list expired staged objects: %w", err)
+	}
+	return objects, nil
+}
+
+func (s *stagingRepositoryImpl) do(method string, path string, payload any, result any, prefer string) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := repo.NewRequest(s.repo_supabase, method, s.repo_supabase.SupabaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if prefer != "" {
+		req.Header.Set("Prefer", prefer)
+	}
+
+	resp, err := s.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status: %d, response: %s", resp.StatusCode, string(respBody))
+	}
+
+	if result != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"backend/models"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoutePath is where the server exposes a LocalStore.
+const RoutePath = "/storage"
+
+// multipartDir holds the parts of unfinished multipart uploads, one
+// directory per upload. Keys can't point into it.
+const multipartDir = ".multipart"
+
+var (
+	// ErrInvalidSignature is returned for presigned requests that were
+	// tampered with or have expired.
+	ErrInvalidSignature = errors.New("invalid or expired signature")
+	// ErrInvalidUpload is returned for presigned requests whose body does
+	// not match what was signed.
+	ErrInvalidUpload = errors.New("invalid upload")
+)
+
+// LocalStore keeps objects as files under a directory, for self-hosting and
+// test environments without Cloudflare. The server serves them under
+// RoutePath and accepts uploads there that were presigned with an HMAC of
+// the request.
+type LocalStore struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+var _ ObjectStore = (*LocalStore)(nil)
+
+// NewLocalStore stores files under root. publicURL is the address the
+// server is reachable at, such as http://localhost:8080; for Instagram it
+// must be reachable from the internet.
+func NewLocalStore(root string, publicURL string, secret []byte) (*LocalStore, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("local storage needs a signing secret")
+	}
+	if err := os.MkdirAll(filepath.Join(root, multipartDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStore{
+		root:    root,
+		baseURL: strings.TrimSuffix(publicURL, "/") + RoutePath + "/",
+		secret:  secret,
+	}, nil
+}
+
+// FilePath returns the file an object is stored in.
+func (l *LocalStore) FilePath(fileName string) (string, error) {
+	if fileName == "" || path.Clean("/" + fileName)[1:] != fileName || strings.HasPrefix(fileName, multipartDir) {
+		return "", fmt.Errorf("invalid key %q", fileName)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(fileName)), nil
+}
+
+func (l *LocalStore) UploadFile(ctx context.Context, file io.Reader, fileName string, mimeType string) (string, error) {
+	if seeker, ok := file.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to rewind file: %w", err)
+		}
+	}
+	filePath, err := l.FilePath(fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writeFile(ctx, filePath, file); err != nil {
+		return "", fmt.Errorf("failed to upload file: %w", err)
+	}
+	return l.PublicURL(fileName), nil
+}
+
+func (l *LocalStore) OpenFile(ctx context.Context, fileName string) (io.ReadCloser, error) {
+	filePath, err := l.FilePath(fileName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file %s: %w", fileName, err)
+	}
+	return f, nil
+}
+
+func (l *LocalStore) FileSize(ctx context.Context, fileName string) (int64, error) {
+	filePath, err := l.FilePath(fileName)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up file %s: %w", fileName, err)
+	}
+	return stat.Size(), nil
+}
+
+// FileReaderAt opens the file for every read, so that nothing needs to be
+// closed, just like the ranged requests of the R2 implementation.
+func (l *LocalStore) FileReaderAt(ctx context.Context, fileName string, size int64) io.ReaderAt {
+	return &fileReaderAt{ctx: ctx, l: l, fileName: fileName}
+}
+
+type fileReaderAt struct {
+	ctx      context.Context
+	l        *LocalStore
+	fileName string
+}
+
+func (r *fileReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	filePath, err := r.l.FilePath(r.fileName)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file %s: %w", r.fileName, err)
+	}
+	defer f.Close()
+	return f.ReadAt(p, off)
+}
+
+func (l *LocalStore) CopyFile(ctx context.Context, fromName string, toName string, contentType string) error {
+	src, err := l.OpenFile(ctx, fromName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := l.FilePath(toName)
+	if err != nil {
+		return err
+	}
+	if _, err := writeFile(ctx, dst, src); err != nil {
+		return fmt.Errorf("failed to copy file %s to %s: %w", fromName, toName, err)
+	}
+	return nil
+}
+
+// DeleteFile succeeds for files that don't exist, like an S3 delete.
+func (l *LocalStore) DeleteFile(ctx context.Context, fileName string) error {
+	filePath, err := l.FilePath(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file %s: %w", fileName, err)
+	}
+	return nil
+}
+
+func (l *LocalStore) PublicURL(fileName string) string {
+	return l.baseURL + escapeKey(fileName)
+}
+
+func (l *LocalStore) PresignPut(ctx context.Context, fileName string, contentType string, size int64, expires time.Duration) (*models.PresignedRequest, error) {
+	if _, err := l.FilePath(fileName); err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("content_type", contentType)
+	q.Set("size", strconv.FormatInt(size, 10))
+	return l.presign(fileName, q, expires, map[string]string{"Content-Type": contentType}), nil
+}
+
+func (l *LocalStore) CreateMultipartUpload(ctx context.Context, fileName string, contentType string) (string, error) {
+	if _, err := l.FilePath(fileName); err != nil {
+		return "", err
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to start multipart upload of %s: %w", fileName, err)
+	}
+	uploadID := hex.EncodeToString(token)
+
+	// The key is kept with the parts so an upload ID can't be completed
+	// into another object.
+	dir := filepath.Join(l.root, multipartDir, uploadID)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to start multipart upload of %s: %w", fileName, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(fileName), 0o644); err != nil {
+		return "", fmt.Errorf("failed to start multipart upload of %s: %w", fileName, err)
+	}
+	return uploadID, nil
+}
+
+func (l *LocalStore) PresignUploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, size int64, expires time.Duration) (*models.PresignedRequest, error) {
+	if _, err := l.uploadDir(fileName, uploadID); err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("upload_id", uploadID)
+	q.Set("part", strconv.Itoa(int(partNumber)))
+	q.Set("size", strconv.FormatInt(size, 10))
+	return l.presign(fileName, q, expires, map[string]string{}), nil
+}
+
+func (l *LocalStore) CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, etags map[int32]string) error {
+	dir, err := l.uploadDir(fileName, uploadID)
+	if err != nil {
+		return err
+	}
+
+	parts := make([]io.Reader, 0, len(etags))
+	for number := int32(1); number <= int32(len(etags)); number++ {
+		etag, ok := etags[number]
+		if !ok {
+			return fmt.Errorf("part %d of %s is missing", number, fileName)
+		}
+		part, err := os.Open(filepath.Join(dir, strconv.Itoa(int(number))))
+		if err != nil {
+			return fmt.Errorf("part %d of %s is missing", number, fileName)
+		}
+		defer part.Close()
+
+		sum := md5.New()
+		if _, err := io.Copy(sum, part); err != nil {
+			return fmt.Errorf("failed to read part %d of %s: %w", number, fileName, err)
+		}
+		if strings.Trim(etag, `"`) != hex.EncodeToString(sum.Sum(nil)) {
+			return fmt.Errorf("part %d of %s does not match its ETag", number, fileName)
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read part %d of %s: %w", number, fileName, err)
+		}
+		parts = append(parts, part)
+	}
+
+	filePath, err := l.FilePath(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := writeFile(ctx, filePath, io.MultiReader(parts...)); err != nil {
+		return fmt.Errorf("failed to complete multipart upload of %s: %w", fileName, err)
+	}
+	return os.RemoveAll(dir)
+}
+
+func (l *LocalStore) AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error {
+	dir, err := l.uploadDir(fileName, uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to abort multipart upload of %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// PutSigned stores the body of a presigned PUT request, either as the
+// object itself or as one part of a multipart upload. It returns the ETag
+// of what was written, the hex MD5 in quotes as S3 does.
+func (l *LocalStore) PutSigned(ctx context.Context, fileName string, query url.Values, contentType string, size int64, body io.Reader) (string, error) {
+	if !l.validSignature(fileName, query) {
+		return "", ErrInvalidSignature
+	}
+	if query.Get("size") != strconv.FormatInt(size, 10) {
+		return "", fmt.Errorf("%w: expected %s bytes", ErrInvalidUpload, query.Get("size"))
+	}
+	if expected := query.Get("content_type"); expected != "" && expected != contentType {
+		return "", fmt.Errorf("%w: expected content type %s", ErrInvalidUpload, expected)
+	}
+
+	filePath, err := l.FilePath(fileName)
+	if err != nil {
+		return "", err
+	}
+	if uploadID := query.Get("upload_id"); uploadID != "" {
+		dir, err := l.uploadDir(fileName, uploadID)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidUpload, err)
+		}
+		part, err := strconv.Atoi(query.Get("part"))
+		if err != nil || part < 1 {
+			return "", fmt.Errorf("%w: invalid part number", ErrInvalidUpload)
+		}
+		filePath = filepath.Join(dir, strconv.Itoa(part))
+	}
+
+	sum := md5.New()
+	written, err := writeFile(ctx, filePath, io.TeeReader(io.LimitReader(body, size+1), sum))
+	if err != nil {
+		return "", err
+	}
+	if written != size {
+		os.Remove(filePath)
+		return "", fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidUpload, size, written)
+	}
+	return `"` + hex.EncodeToString(sum.Sum(nil)) + `"`, nil
+}
+
+func (l *LocalStore) uploadDir(fileName string, uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("unknown multipart upload %s", uploadID)
+	}
+	dir := filepath.Join(l.root, multipartDir, uploadID)
+	key, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil || string(key) != fileName {
+		return "", fmt.Errorf("unknown multipart upload %s", uploadID)
+	}
+	return dir, nil
+}
+
+// presign signs the key and query together with an expiry. Nothing else is
+// needed to accept the request later, so no state is kept for it.
+func (l *LocalStore) presign(fileName string, q url.Values, expires time.Duration, headers map[string]string) *models.PresignedRequest {
+	q.Set("expires", strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+	q.Set("signature", l.signature(fileName, q))
+	return &models.PresignedRequest{
+		Method:  "PUT",
+		URL:     l.baseURL + escapeKey(fileName) + "?" + q.Encode(),
+		Headers: headers,
+	}
+}
+
+func (l *LocalStore) validSignature(fileName string, query url.Values) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	expected := l.signature(fileName, query)
+	return hmac.Equal([]byte(expected), []byte(query.Get("signature")))
+}
+
+func (l *LocalStore) signature(fileName string, query url.Values) string {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != "signature" {
+			signed[name] = values
+		}
+	}
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte("PUT\n" + fileName + "\n" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// writeFile writes r to a temporary file next to filePath and renames it
+// into place, so readers never see a partial file. It stops, leaving no
+// file behind, once ctx is cancelled.
+func writeFile(ctx context.Context, filePath string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return written, os.Rename(tmp.Name(), filePath)
+}
+
+// contextReader fails its reads once ctx is cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func escapeKey(fileName string) string {
+	segments := strings.Split(fileName, "/")
+	for idx, segment := range segments {
+		segments[idx] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
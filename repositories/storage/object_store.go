@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"backend/models"
+	"context"
+	"io"
+	"time"
+)
+
+// ObjectStore is where uploaded and staged media is kept. Objects are
+// addressed by key and are publicly readable under PublicURL, so that
+// platforms such as Instagram can fetch them. Every call that touches the
+// store gives up once its context is cancelled.
+type ObjectStore interface {
+	// UploadFile stores file under fileName and returns its public URL.
+	// Seekable files are rewound first.
+	UploadFile(ctx context.Context, file io.Reader, fileName string, mimeType string) (string, error)
+	// OpenFile streams an object. The caller must close the returned body.
+	OpenFile(ctx context.Context, fileName string) (io.ReadCloser, error)
+	// FileSize returns the size of an object, failing if it does not exist.
+	FileSize(ctx context.Context, fileName string) (int64, error)
+	// FileReaderAt reads parts of an object of the given size without
+	// fetching all of it. The reads are cancelled with ctx.
+	FileReaderAt(ctx context.Context, fileName string, size int64) io.ReaderAt
+	CopyFile(ctx context.Context, fromName string, toName string, contentType string) error
+	DeleteFile(ctx context.Context, fileName string) error
+	PublicURL(fileName string) string
+
+	// PresignPut returns a request the browser can send to upload exactly
+	// size bytes of contentType without credentials.
+	PresignPut(ctx context.Context, fileName string, contentType string, size int64, expires time.Duration) (*models.PresignedRequest, error)
+	CreateMultipartUpload(ctx context.Context, fileName string, contentType string) (string, error)
+	// PresignUploadPart returns a request for one part of a multipart
+	// upload. The response carries the part's ETag.
+	PresignUploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, size int64, expires time.Duration) (*models.PresignedRequest, error)
+	// CompleteMultipartUpload assembles the uploaded parts, given as part
+	// number to ETag, into the final object.
+	CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, etags map[int32]string) error
+	AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error
+}
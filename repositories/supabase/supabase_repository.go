@@ -1,6 +1,7 @@
 package supabase
 
 import (
+	"backend/repositories/httpclient"
 	"net/http"
 )
 
@@ -14,6 +15,6 @@ func NewSupabaseRepository(supabaseUrl, supabaseKey string) *SupabaseRepository
 	return &SupabaseRepository{
 		SupabaseKey: supabaseKey,
 		SupabaseURL: supabaseUrl,
-		HttpClient: &http.Client{},
+		HttpClient: httpclient.API,
 	}
 }
\ No newline at end of file
@@ -2,8 +2,14 @@ package twitter
 
 import (
 	"backend/models"
+	repo "backend/repositories"
+	"backend/repositories/encryption"
+	"backend/repositories/httpclient"
+	"backend/repositories/provider"
+	"backend/repositories/ratelimit"
 	repo_supabase "backend/repositories/supabase"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,10 +17,23 @@ import (
 	"mime/multipart"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/dghubble/oauth1"
 )
 
+const platform = "twitter"
+
+// The endpoints the tracker keeps quotas for, named as in the X API docs.
+const (
+	EndpointTweets        = "POST /2/tweets"
+	EndpointMediaInit     = "POST /2/media/upload/initialize"
+	EndpointMediaAppend   = "POST /2/media/upload/:id/append"
+	EndpointMediaFinalize = "POST /2/media/upload/:id/finalize"
+	EndpointMediaStatus   = "GET /2/media/upload"
+	EndpointMediaMetadata = "POST /2/media/metadata"
+)
+
 type v2StatusResponse struct {
 	Data struct {
 		ProcessingInfo struct {
@@ -35,40 +54,80 @@ type v2InitResponse struct {
 }
 
 type TwitterRepository interface {
-	SaveToken(userID string, accessToken string, accessSecret string) error
-	GetCredentials(userID string) (string, string, error)
-	CheckTokens(accessToken, accessSecret string) (error)
-	InitUpload(httpClient *http.Client, mediaData []byte, mediaType string, mediaCategory string) (string, error)
-	AppendUpload(httpClient *http.Client, mediaID string, mediaData []byte, segmentIndex int) (int, error)
-	FinalizeUpload(httpClient *http.Client, mediaID string) error
-	StatusUpload(httpClient *http.Client, mediaID string) (*v2StatusResponse, error)
-	PostTweet(client *http.Client, postURL string, payload map[string]interface{}) error
+	SaveToken(ctx context.Context, userID string, accessToken string, accessSecret string) error
+	GetCredentials(ctx context.Context, userID string) (string, string, error)
+	DeleteToken(ctx context.Context, userID string) error
+	InvalidateToken(ctx context.Context, accessToken string, accessSecret string) error
+	ReencryptTokens(ctx context.Context) (int, error)
+	CheckTokens(ctx context.Context, accessToken, accessSecret string) (error)
+	InitUpload(ctx context.Context, httpClient *http.Client, totalBytes int64, mediaType string, mediaCategory string) (string, error)
+	AppendUpload(ctx context.Context, httpClient *http.Client, mediaID string, segment *io.SectionReader, segmentIndex int) (int, error)
+	FinalizeUpload(ctx context.Context, httpClient *http.Client, mediaID string) error
+	StatusUpload(ctx context.Context, httpClient *http.Client, mediaID string) (*v2StatusResponse, error)
+	CreateMediaMetadata(ctx context.Context, httpClient *http.Client, mediaID string, altText string) error
+	PostTweet(ctx context.Context, client *http.Client, postURL string, payload map[string]interface{}) (string, error)
 }
 
 type twitterRepositoryImpl struct {
 	repo_supabase *repo_supabase.SupabaseRepository
 	twitterConfig *oauth1.Config
+	keyring       *encryption.Keyring
+	limits        *ratelimit.Tracker
+}
+
+// NewOAuthClient returns a client that signs its requests with the user's
+// tokens. It runs on the shared transport and gives up after timeout.
+func NewOAuthClient(config *oauth1.Config, accessToken string, accessSecret string, timeout time.Duration) *http.Client {
+	ctx := context.WithValue(context.Background(), oauth1.HTTPClient, httpclient.API)
+	client := config.Client(ctx, oauth1.NewToken(accessToken, accessSecret))
+	client.Timeout = timeout
+	return client
 }
 
-func NewTwitterRepository(supabaseRepository *repo_supabase.SupabaseRepository, twitterConfig *oauth1.Config) TwitterRepository {
+func NewTwitterRepository(supabaseRepository *repo_supabase.SupabaseRepository, twitterConfig *oauth1.Config, keyring *encryption.Keyring, limits *ratelimit.Tracker) TwitterRepository {
 	return &twitterRepositoryImpl{
 		repo_supabase: supabaseRepository,
 		twitterConfig: twitterConfig,
+		keyring:       keyring,
+		limits:        limits,
 	}
 }
 
-func (t *twitterRepositoryImpl) SaveToken(userID string, accessToken string, accessSecret string) error {
+// send makes a call to the X API under the shared retry policy and
+// records the rate limits reported on the last response against the
+// account of the request's context. Failures come back as a
+// *provider.Error.
+func (t *twitterRepositoryImpl) send(client *http.Client, endpoint string, req *http.Request) (*http.Response, []byte, error) {
+	resp, body, err := provider.X.Do(client, req)
+	if resp != nil && endpoint != "" {
+		account := ratelimit.AccountFrom(req.Context())
+		for _, quota := range ratelimit.XQuotas(platform, endpoint, resp.Header) {
+			t.limits.Record(account, quota)
+		}
+	}
+	return resp, body, err
+}
+
+func (t *twitterRepositoryImpl) SaveToken(ctx context.Context, userID string, accessToken string, accessSecret string) error {
+    encryptedToken, err := t.keyring.Encrypt(accessToken)
+    if err != nil {
+        return err
+    }
+    encryptedSecret, err := t.keyring.Encrypt(accessSecret)
+    if err != nil {
+        return err
+    }
     payload := map[string]string{
         "user_id":      userID,
-        "access_token": accessToken,
-        "access_secret": accessSecret,
+        "access_token": encryptedToken,
+        "access_secret": encryptedSecret,
     }
     payloadBytes, err := json.Marshal(payload)
     if err != nil {
         return err
     }
     url := t.repo_supabase.SupabaseURL + "twitter"
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+    req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payloadBytes))
     if err != nil {
         return err
     }
@@ -76,7 +135,7 @@ func (t *twitterRepositoryImpl) SaveToken(userID string, accessToken string, acc
     req.Header.Set("Authorization", "Bearer "+t.repo_supabase.SupabaseKey)
     req.Header.Set("Content-Type", "application/json")
     req.Header.Set("Prefer", "return=representation")
-    resp, err := http.DefaultClient.Do(req)
+    resp, err := t.repo_supabase.HttpClient.Do(req)
     if err != nil {
         return err
     }
@@ -88,8 +147,11 @@ func (t *twitterRepositoryImpl) SaveToken(userID string, accessToken string, acc
     return nil
 }
 
-func (t *twitterRepositoryImpl) GetCredentials(userID string) (string, string, error) {
-	req, _ := http.NewRequest("GET", t.repo_supabase.SupabaseURL + "twitter", nil)
+func (t *twitterRepositoryImpl) GetCredentials(ctx context.Context, userID string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", t.repo_supabase.SupabaseURL + "twitter", nil)
+	if err != nil {
+		return "", "", err
+	}
 	req.Header.Set("apikey", t.repo_supabase.SupabaseKey)
 	req.Header.Set("Authorization", "Bearer "+t.repo_supabase.SupabaseKey)
 
@@ -97,8 +159,7 @@ func (t *twitterRepositoryImpl) GetCredentials(userID string) (string, string, e
 	q.Add("user_id", "eq."+userID)
 	req.URL.RawQuery = q.Encode()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := t.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return "", "", err
 	}
@@ -118,40 +179,149 @@ func (t *twitterRepositoryImpl) GetCredentials(userID string) (string, string, e
 		return "", "", fmt.Errorf("twitter tokens not found")
 	}
 
-	return twitterModel[0].AccessToken, twitterModel[0].AccessSecret, nil
+	accessToken, err := t.keyring.Decrypt(twitterModel[0].AccessToken)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt twitter tokens: %w", err)
+	}
+	accessSecret, err := t.keyring.Decrypt(twitterModel[0].AccessSecret)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to decrypt twitter tokens: %w", err)
+	}
+
+	return accessToken, accessSecret, nil
+}
+
+// ReencryptTokens encrypts plaintext rows and rows sealed with an old key
+// with the current key, returning the number of rows updated.
+func (t *twitterRepositoryImpl) ReencryptTokens(ctx context.Context) (int, error) {
+	req, err := repo.NewRequestWithContext(ctx, t.repo_supabase, "GET", t.repo_supabase.SupabaseURL+"twitter", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := t.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("failed to fetch twitter tokens, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	var rows []models.TwitterModel
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	updated := 0
+	for _, row := range rows {
+		if !t.keyring.NeedsReencryption(row.AccessToken) && !t.keyring.NeedsReencryption(row.AccessSecret) {
+			continue
+		}
+
+		accessToken, err := t.keyring.Reencrypt(row.AccessToken)
+		if err != nil {
+			return updated, fmt.Errorf("failed to re-encrypt twitter tokens of user %s: %w", row.UserID, err)
+		}
+		accessSecret, err := t.keyring.Reencrypt(row.AccessSecret)
+		if err != nil {
+			return updated, fmt.Errorf("failed to re-encrypt twitter tokens of user %s: %w", row.UserID, err)
+		}
+
+		if err := t.updateToken(ctx, row.UserID, map[string]string{"access_token": accessToken, "access_secret": accessSecret}); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func (t *twitterRepositoryImpl) updateToken(ctx context.Context, userID string, payload map[string]string) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := repo.NewRequestWithContext(ctx, t.repo_supabase, "PATCH", t.repo_supabase.SupabaseURL+"twitter?user_id=eq."+userID, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.repo_supabase.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to update twitter tokens, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
 }
 
-func (t *twitterRepositoryImpl) CheckTokens(accessToken string, accessSecret string) (error) {
-	token := oauth1.NewToken(accessToken, accessSecret)
-	client := t.twitterConfig.Client(oauth1.NoContext, token)
+func (t *twitterRepositoryImpl) DeleteToken(ctx context.Context, userID string) error {
+	req, err := repo.NewRequestWithContext(ctx, t.repo_supabase, "DELETE", t.repo_supabase.SupabaseURL+"twitter?user_id=eq."+userID, nil)
+	if err != nil {
+		return err
+	}
 
-	resp, err := client.Get("https://api.twitter.com/1.1/account/verify_credentials.json")
+	resp, err := t.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		return nil
-	case http.StatusUnauthorized:
-		return fmt.Errorf("tokens invalid or revoked")
-	default:
-		return fmt.Errorf("unexpected response from Twitter API")
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to delete twitter tokens, status: %d, response: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// InvalidateToken revokes the user's access token on Twitter's side.
+func (t *twitterRepositoryImpl) InvalidateToken(ctx context.Context, accessToken string, accessSecret string) error {
+	client := NewOAuthClient(t.twitterConfig, accessToken, accessSecret, httpclient.API.Timeout)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.twitter.com/1.1/oauth/invalidate_token", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if _, _, err := t.send(client, "", req); err != nil {
+		return fmt.Errorf("failed to invalidate twitter token: %w", err)
+	}
+	return nil
+}
+
+func (t *twitterRepositoryImpl) CheckTokens(ctx context.Context, accessToken string, accessSecret string) (error) {
+	client := NewOAuthClient(t.twitterConfig, accessToken, accessSecret, httpclient.API.Timeout)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.twitter.com/1.1/account/verify_credentials.json", nil)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := t.send(client, "", req); err != nil {
+		return fmt.Errorf("failed to verify twitter tokens: %w", err)
 	}
+	return nil
 }
 
-func (t *twitterRepositoryImpl) InitUpload(httpClient *http.Client, mediaData []byte, mediaType string, mediaCategory string) (string, error) {
+func (t *twitterRepositoryImpl) InitUpload(ctx context.Context, httpClient *http.Client, totalBytes int64, mediaType string, mediaCategory string) (string, error) {
 	log.Println("--- twitterService.initUpload: START ---")
 
 	const initializeURL = "https://api.x.com/2/media/upload/initialize"
 
 	payload := struct {
-		TotalBytes    int    `json:"total_bytes"`
+		TotalBytes    int64  `json:"total_bytes"`
 		MediaType     string `json:"media_type"`
 		MediaCategory string `json:"media_category"`
 	}{
-		TotalBytes:    len(mediaData),
+		TotalBytes:    totalBytes,
 		MediaType:     mediaType,
 		MediaCategory: mediaCategory,
 	}
@@ -161,23 +331,17 @@ func (t *twitterRepositoryImpl) InitUpload(httpClient *http.Client, mediaData []
 		return "", fmt.Errorf("failed to create INIT payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", initializeURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", initializeURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return "", fmt.Errorf("failed to create INIT request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	// An upload started twice only leaves an unused media ID behind.
+	req.Header["Idempotency-Key"] = nil
 
-	resp, err := httpClient.Do(req)
+	_, body, err := t.send(httpClient, EndpointMediaInit, req)
 	if err != nil {
-		return "", fmt.Errorf("http client failed during INIT: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		// This log is still useful if the status is not 200
-		return "", fmt.Errorf("bad status on INIT: %s, response: %s", resp.Status, string(body))
+		return "", fmt.Errorf("INIT failed: %w", err)
 	}
 
 	var initResp v2InitResponse
@@ -195,92 +359,92 @@ func (t *twitterRepositoryImpl) InitUpload(httpClient *http.Client, mediaData []
 	return initResp.Data.ID, nil
 }
 
-func (t *twitterRepositoryImpl) AppendUpload(httpClient *http.Client, mediaID string, mediaData []byte, segmentIndex int) (int, error) {
+// AppendUpload sends one segment of the media. The segment is streamed from
+// its reader as the request body is written, so only the small multipart
+// framing around it is held in memory.
+func (t *twitterRepositoryImpl) AppendUpload(ctx context.Context, httpClient *http.Client, mediaID string, segment *io.SectionReader, segmentIndex int) (int, error) {
 	appendURL := fmt.Sprintf("https://api.x.com/2/media/upload/%s/append", mediaID)
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	head := &bytes.Buffer{}
+	writer := multipart.NewWriter(head)
 
-	// Part 1: The media chunk itself. The field name MUST be "media".
-	mediaPart, err := writer.CreateFormFile("media", "media.bin") // filename is arbitrary
-	if err != nil {
-		return 0, fmt.Errorf("failed to create append request: %w", err)
-	}
-	_, err = mediaPart.Write(mediaData)
+	// The segment_index is a regular form field.
+	// The field name MUST be "segment_index".
+	err := writer.WriteField("segment_index", strconv.Itoa(segmentIndex))
 	if err != nil {
-		return 0, fmt.Errorf("failed to write media data to form: %w", err)
+		return 0, fmt.Errorf("failed to write segment_index to form: %w", err)
 	}
 
-	// Part 2: The segment_index. This is a regular form field.
-	// The field name MUST be "segment_index".
-	err = writer.WriteField("segment_index", strconv.Itoa(segmentIndex))
+	// The media chunk itself comes last, so that its content can be streamed
+	// between the part header and the closing boundary. The field name MUST
+	// be "media".
+	_, err = writer.CreateFormFile("media", "media.bin") // filename is arbitrary
 	if err != nil {
-		return 0, fmt.Errorf("failed to write segment_index to form: %w", err)
+		return 0, fmt.Errorf("failed to create append request: %w", err)
 	}
+	prefix := head.Len()
 
-	// This finalizes the multipart body.
+	// This writes the closing boundary after the part header.
 	writer.Close()
+	framing := head.Bytes()
 
-	req, err := http.NewRequest("POST", appendURL, body)
+	body := func() io.Reader {
+		// Each attempt reads the segment from its start.
+		return io.MultiReader(bytes.NewReader(framing[:prefix]), io.NewSectionReader(segment, 0, segment.Size()), bytes.NewReader(framing[prefix:]))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", appendURL, body())
 	if err != nil {
 		return 0, fmt.Errorf("failed to create append request: %w", err)
 	}
+	req.ContentLength = int64(len(framing)) + segment.Size()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(body()), nil
+	}
 
 	// CRITICAL FIX: The Content-Type must be set from the multipart writer,
 	// as it includes the unique boundary string.
 	req.Header.Set("Content-Type", writer.FormDataContentType())
+	// A segment sent twice replaces itself, so a dropped connection is
+	// retried like an error response.
+	req.Header["Idempotency-Key"] = nil
 
-	// --- The rest of your function is correct ---
-	resp, err := httpClient.Do(req)
+	resp, _, err := t.send(httpClient, EndpointMediaAppend, req)
 	if err != nil {
+		log.Printf("--> ERROR from Twitter API (APPEND): %v", err)
 		return 0, err
 	}
-	defer resp.Body.Close()
-
-	// A successful APPEND to the v2 endpoint returns a 204 No Content status.
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		log.Printf("--> ERROR from Twitter API (APPEND): status=%d, body=%s", resp.StatusCode, string(respBody))
-		return 0, fmt.Errorf("bad status on APPEND: %s, response: %s", resp.Status, string(respBody))
-	}
 
 	// On success, return the status code we received.
 	return resp.StatusCode, nil
 }
 
-func (t *twitterRepositoryImpl) FinalizeUpload(httpClient *http.Client, mediaID string) error {
+func (t *twitterRepositoryImpl) FinalizeUpload(ctx context.Context, httpClient *http.Client, mediaID string) error {
 	log.Println("--- twitterService.finalizeUpload: START ---")
 	finalizeURL := fmt.Sprintf("https://api.x.com/2/media/upload/%s/finalize", mediaID)
 
 	// The v2 finalize request has an empty body.
-	req, err := http.NewRequest("POST", finalizeURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", finalizeURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create finalize request: %w", err)
 	}
+	req.Header["Idempotency-Key"] = nil
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
+	if _, _, err := t.send(httpClient, EndpointMediaFinalize, req); err != nil {
+		log.Printf("--> ERROR from Twitter API (FINALIZE): %v", err)
 		return err
 	}
-	defer resp.Body.Close()
-
-	// The documented success code is 204 No Content.
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		log.Printf("--> ERROR from Twitter API (FINALIZE): status=%d, body=%s", resp.StatusCode, string(respBody))
-		return fmt.Errorf("bad status on FINALIZE: %s, response: %s", resp.Status, string(respBody))
-	}
 
 	log.Println("--- twitterService.finalizeUpload: SUCCESS ---")
 	return nil
 }
 
-func (t *twitterRepositoryImpl) StatusUpload(httpClient *http.Client, mediaID string) (*v2StatusResponse, error) {
+func (t *twitterRepositoryImpl) StatusUpload(ctx context.Context, httpClient *http.Client, mediaID string) (*v2StatusResponse, error) {
 	log.Println("--- twitterService.statusUpload: START ---")
 	statusURL := "https://api.x.com/2/media/upload"
 
 	// The status check is a GET request.
-	req, err := http.NewRequest("GET", statusURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", statusURL, nil)
 	if err != nil {
 		log.Println("--- twitterService.statusUpload: ERROR creating request ---")
 		return nil, err
@@ -291,18 +455,11 @@ func (t *twitterRepositoryImpl) StatusUpload(httpClient *http.Client, mediaID st
 	q.Add("command", "STATUS")
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := httpClient.Do(req)
+	_, body, err := t.send(httpClient, EndpointMediaStatus, req)
 	if err != nil {
-		log.Println("--- twitterService.statusUpload: ERROR during httpClient.Do ---")
+		log.Printf("--> ERROR from Twitter API (STATUS): %v", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("--> ERROR from Twitter API (STATUS): status=%d, body=%s", resp.StatusCode, string(body))
-		return nil, fmt.Errorf("bad status on STATUS check: %s, response: %s", resp.Status, string(body))
-	}
 
 	var statusResp v2StatusResponse
 	if err := json.Unmarshal(body, &statusResp); err != nil {
@@ -313,32 +470,61 @@ func (t *twitterRepositoryImpl) StatusUpload(httpClient *http.Client, mediaID st
 	return &statusResp, nil
 }
 
-func (t *twitterRepositoryImpl) PostTweet(client *http.Client, postURL string, payload map[string]interface{}) error {
+// CreateMediaMetadata sets the alt text of an uploaded image or GIF. It must
+// be called before the media is attached to a tweet.
+func (t *twitterRepositoryImpl) CreateMediaMetadata(ctx context.Context, httpClient *http.Client, mediaID string, altText string) error {
+	payload := map[string]interface{}{
+		"id": mediaID,
+		"metadata": map[string]interface{}{
+			"alt_text": map[string]string{"text": altText},
+		},
+	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal tweet payload: %w", err)
+		return fmt.Errorf("failed to marshal media metadata: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", postURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.x.com/2/media/metadata", bytes.NewBuffer(payloadBytes))
 	if err != nil {
-		return fmt.Errorf("failed to create tweet request: %w", err)
+		return fmt.Errorf("failed to create media metadata request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header["Idempotency-Key"] = nil
+
+	if _, _, err := t.send(httpClient, EndpointMediaMetadata, req); err != nil {
+		return fmt.Errorf("failed to set media metadata: %w", err)
+	}
+	return nil
+}
 
-	resp, err := client.Do(req)
+// PostTweet creates the tweet and returns its ID.
+func (t *twitterRepositoryImpl) PostTweet(ctx context.Context, client *http.Client, postURL string, payload map[string]interface{}) (string, error) {
+	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("tweet request failed: %w", err)
+		return "", fmt.Errorf("failed to marshal tweet payload: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to post tweet, status: %d, response: %s", resp.StatusCode, string(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", postURL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to create tweet request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	_, body, err := t.send(client, EndpointTweets, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to post tweet: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusCreated {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to post tweet, status: %d, response: %s", resp.StatusCode, string(body))
+	var created struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
 	}
-	return nil
-}
\ No newline at end of file
+	if err := json.Unmarshal(body, &created); err != nil {
+		return "", fmt.Errorf("failed to parse tweet response: %w", err)
+	}
+	if created.Data.ID == "" {
+		return "", fmt.Errorf("tweet response did not contain an id")
+	}
+	return created.Data.ID, nil
+}
@@ -4,6 +4,7 @@ import (
 	"backend/models"
 	repo_supabase "backend/repositories/supabase"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -20,10 +21,10 @@ type UserIDResponse struct {
 }
 
 type UserRepository interface {
-	Create(user *models.User) error
-	FindByEmail(email string) (*models.User, error)
-	ExistsByEmail(email string) (bool, error)
-	UserIDByEmail(email string) (string, error)
+	Create(ctx context.Context, user *models.User) error
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	ExistsByEmail(ctx context.Context, email string) (bool, error)
+	UserIDByEmail(ctx context.Context, email string) (string, error)
 }
 
 type userRepositoryImpl struct {
@@ -36,7 +37,7 @@ func NewUserRepository(supabaseRepository *repo_supabase.SupabaseRepository) Use
 	}
 }
 
-func (u *userRepositoryImpl) Create(user *models.User) error {
+func (u *userRepositoryImpl) Create(ctx context.Context, user *models.User) error {
 	supabaseUserData := models.User{
 		Email:    user.Email,
 		Password: user.Password,
@@ -47,10 +48,12 @@ func (u *userRepositoryImpl) Create(user *models.User) error {
 	}
 	
 	url := u.repo_supabase.SupabaseURL + profile_path
-	req, err := u.newRequest("POST", url, bytes.NewBuffer(data))
+	req, err := u.newRequest(ctx, "POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := u.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("request error: %w", err)
 	}
@@ -63,14 +66,14 @@ func (u *userRepositoryImpl) Create(user *models.User) error {
 	return nil
 }
 
-func (u *userRepositoryImpl) FindByEmail(email string) (*models.User, error) {
+func (u *userRepositoryImpl) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	url := u.repo_supabase.SupabaseURL + profile_path + "?email=eq." + url.QueryEscape(email)
-	req, err := u.newRequest("GET", url, nil)
+	req, err := u.newRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := u.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -92,13 +95,13 @@ func (u *userRepositoryImpl) FindByEmail(email string) (*models.User, error) {
 	return &users[0], nil
 }
 
-func (u *userRepositoryImpl) ExistsByEmail(email string) (bool, error) {
-	req, err := u.newRequest("GET", u.repo_supabase.SupabaseURL+profile_path+"?email=eq."+email, nil)
+func (u *userRepositoryImpl) ExistsByEmail(ctx context.Context, email string) (bool, error) {
+	req, err := u.newRequest(ctx, "GET", u.repo_supabase.SupabaseURL+profile_path+"?email=eq."+email, nil)
 	if err != nil {
 		return false, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := u.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return false, err
 	}
@@ -110,15 +113,15 @@ func (u *userRepositoryImpl) ExistsByEmail(email string) (bool, error) {
 	return len(users) > 0, nil
 }
 
-func (u *userRepositoryImpl) UserIDByEmail(email string) (string, error) {
+func (u *userRepositoryImpl) UserIDByEmail(ctx context.Context, email string) (string, error) {
 	url := u.repo_supabase.SupabaseURL + profile_path + "?email=eq." + url.QueryEscape(email) + "&select=id"
 
-	req, err := u.newRequest("GET", url, nil)
+	req, err := u.newRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := u.repo_supabase.HttpClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -145,8 +148,8 @@ func (u *userRepositoryImpl) UserIDByEmail(email string) (string, error) {
 
 
 
-func (u *userRepositoryImpl) newRequest(method, url string, body io.Reader) (*http.Request, error) {
-    req, err := http.NewRequest(method, url, body)
+func (u *userRepositoryImpl) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+    req, err := http.NewRequestWithContext(ctx, method, url, body)
     if err != nil {
         return nil, err
     }
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterBlueskyRoutes(api *echo.Group, h *handlers.BlueskyHandler) {
+	bluesky := api.Group("/bluesky")
+
+	bluesky.POST("/link", h.LinkBluesky)     // POST /api/bluesky/link
+	bluesky.POST("/unlink", h.UnlinkBluesky) // POST /api/bluesky/unlink
+}
@@ -9,6 +9,7 @@ func RegisterInstagramRoutes(api *echo.Group, h *handlers.InstagramHandler) {
 	twitter := api.Group("/instagram")
 	
 	twitter.GET("/link/begin", h.BeginInstagramLink) // GET /api/instagram/link/begin
+	twitter.POST("/unlink", h.UnlinkInstagram)       // POST /api/instagram/unlink
 
 	
 }
\ No newline at end of file
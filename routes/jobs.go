@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterJobRoutes(api *echo.Group, h *handlers.SchedulerHandler) {
+	jobs := api.Group("/jobs")
+
+	jobs.GET("", h.ListJobs)         // GET /api/jobs
+	jobs.DELETE("/:id", h.CancelJob) // DELETE /api/jobs/:id
+}
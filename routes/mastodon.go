@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterMastodonRoutes(api *echo.Group, h *handlers.MastodonHandler) {
+	mastodon := api.Group("/mastodon")
+
+	mastodon.GET("/link/begin", h.BeginMastodonLink) // GET /api/mastodon/link/begin?instance=mastodon.social
+	mastodon.POST("/unlink", h.UnlinkMastodon)       // POST /api/mastodon/unlink
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterMediaRoutes(api *echo.Group, h *handlers.MediaHandler) {
+	media := api.Group("/media")
+
+	media.POST("", h.UploadMedia)       // POST /api/media
+	media.GET("", h.ListMedia)          // GET /api/media
+	media.GET("/:id", h.GetMedia)       // GET /api/media/:id
+	media.DELETE("/:id", h.DeleteMedia) // DELETE /api/media/:id
+
+	media.POST("/uploads", h.CreateUpload)            // POST /api/media/uploads
+	media.POST("/uploads/complete", h.CompleteUpload) // POST /api/media/uploads/complete
+	media.POST("/uploads/abort", h.AbortUpload)       // POST /api/media/uploads/abort
+}
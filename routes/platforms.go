@@ -2,9 +2,28 @@ package routes
 
 import (
 	"backend/handlers"
+	"backend/services/publisher"
 	"github.com/labstack/echo/v4"
 )
 
-func RegisterPlatformRoute(api *echo.Group, p *handlers.PlatformHandler) {
-	api.POST("/create", p.PostToPlatform)
-}
\ No newline at end of file
+// RegisterPlatformRoute adds the publish routes, which are wrapped in the
+// idempotency middleware, and the platform list.
+func RegisterPlatformRoute(api *echo.Group, p *handlers.PlatformHandler, idempotency echo.MiddlewareFunc) {
+	api.POST("/create", p.PostToPlatform, idempotency)
+	api.POST("/create/multi", p.PostToPlatforms, idempotency)
+	api.GET("/create/:id/events", p.StreamProgress)
+	api.GET("/platforms", p.ListPlatforms)
+}
+
+// RegisterLinkRoutes adds the account linking routes of every registered
+// platform: the authenticated ones under api, the OAuth callbacks on e.
+func RegisterLinkRoutes(e *echo.Echo, api *echo.Group, registry *publisher.Registry) {
+	for _, lr := range registry.LinkRoutes() {
+		if lr.Register != nil {
+			lr.Register(api)
+		}
+		if lr.CallbackPath != "" && lr.Callback != nil {
+			e.GET(lr.CallbackPath, lr.Callback)
+		}
+	}
+}
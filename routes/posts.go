@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterPostRoutes(api *echo.Group, h *handlers.PostHandler) {
+	api.GET("/posts", h.ListPosts) // GET /api/posts
+}
@@ -0,0 +1,10 @@
+package routes
+
+import (
+	"backend/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+func RegisterRateLimitRoutes(api *echo.Group, h *handlers.RateLimitHandler) {
+	api.GET("/rate-limits", h.ListRateLimits) // GET /api/rate-limits
+}
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"backend/handlers"
+	repo_storage "backend/repositories/storage"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterStorageRoutes serves local storage. It is not under /api since
+// platforms fetch media from it without a session.
+func RegisterStorageRoutes(e *echo.Echo, h *handlers.StorageHandler) {
+	storage := e.Group(repo_storage.RoutePath)
+
+	storage.GET("/*", h.GetObject)  // GET /storage/:key
+	storage.HEAD("/*", h.GetObject) // HEAD /storage/:key
+	storage.PUT("/*", h.PutObject)  // PUT /storage/:key (presigned)
+}
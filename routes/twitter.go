@@ -9,4 +9,5 @@ func RegisterTwitterRoutes(api *echo.Group, h *handlers.TwitterHandler) {
 	twitter := api.Group("/twitter")
 	
 	twitter.GET("/link/begin", h.BeginTwitterLink) // GET /api/twitter/link/begin
+	twitter.POST("/unlink", h.UnlinkTwitter)       // POST /api/twitter/unlink
 }
\ No newline at end of file
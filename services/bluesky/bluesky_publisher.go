@@ -0,0 +1,104 @@
+package bluesky
+
+import (
+	"backend/services/media"
+	"backend/services/publisher"
+	"context"
+	"fmt"
+)
+
+const (
+	maxPostLength = 300
+	maxPostImages = 4
+	// The Bluesky app limits image descriptions to 2000 characters.
+	maxAltTextLength = 2000
+)
+
+// Bluesky posts carry up to four images and no video.
+var postMediaLimits = media.Limits{
+	MaxItems:      maxPostImages,
+	ImageTypes:    []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+	MaxImageBytes: maxImageBytes,
+}
+
+type blueskyData struct {
+	Content string   `json:"content"`
+	Langs   []string `json:"langs"`
+	AltText []string `json:"alt_text"`
+}
+
+type blueskyPublisher struct {
+	blueskyService BlueskyService
+}
+
+// NewBlueskyPublisher exposes the Bluesky service through the publisher registry.
+func NewBlueskyPublisher(blueskyService BlueskyService) publisher.Publisher {
+	return &blueskyPublisher{
+		blueskyService: blueskyService,
+	}
+}
+
+func (p *blueskyPublisher) Platform() string {
+	return "bluesky"
+}
+
+func (p *blueskyPublisher) Capabilities() publisher.Capabilities {
+	return publisher.Capabilities{
+		Text:          true,
+		Images:        true,
+		MaxMedia:      maxPostImages,
+		MaxTextLength: maxPostLength,
+		Media:         postMediaLimits,
+
+		MaxAltTextLength: maxAltTextLength,
+	}
+}
+
+func (p *blueskyPublisher) Validate(req *publisher.Request) error {
+	var data blueskyData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return err
+	}
+	if data.Content == "" && len(req.Files) == 0 {
+		return publisher.Invalid("A Bluesky post must have either text content or images.")
+	}
+	if len(req.Files) > maxPostImages {
+		return publisher.Invalid("A Bluesky post can have at most %d images.", maxPostImages)
+	}
+	if countGraphemes(data.Content) > maxPostLength {
+		return publisher.Invalid("A Bluesky post can be at most %d characters long.", maxPostLength)
+	}
+	if err := publisher.CheckAltText("Bluesky", data.AltText, req.Files, maxAltTextLength, false); err != nil {
+		return err
+	}
+	return publisher.CheckMedia("Bluesky", postMediaLimits, req.Files)
+}
+
+func (p *blueskyPublisher) Publish(ctx context.Context, req *publisher.Request) (*publisher.Result, error) {
+	// The session is fetched once and used for the whole post. Whether the
+	// PDS still accepts it shows when the post is created, so it is not
+	// checked separately the way IsLinked does.
+	session, err := p.blueskyService.Session(ctx, req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("Bluesky %w", publisher.ErrNotLinked)
+	}
+
+	var data blueskyData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return nil, err
+	}
+
+	uri, postURL, err := p.blueskyService.PostToBluesky(ctx, session, data.Content, data.Langs, req.Files, publisher.AltTextFor(data.AltText, len(req.Files)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to post to Bluesky: %w", err)
+	}
+	return &publisher.Result{
+		Message:   "Bluesky post published successfully!",
+		RemoteID:  uri,
+		Permalink: postURL,
+	}, nil
+}
+
+func (p *blueskyPublisher) IsLinked(ctx context.Context, userID string) bool {
+	return p.blueskyService.IsLinked(ctx, userID)
+}
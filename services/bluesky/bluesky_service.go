@@ -0,0 +1,219 @@
+package bluesky
+
+import (
+	"backend/models"
+	repo_bluesky "backend/repositories/bluesky"
+	"backend/services/publisher"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	postCollection = "app.bsky.feed.post"
+	// Refresh the access token when it is this close to expiring.
+	refreshMargin = 2 * time.Minute
+	maxImageBytes = 1000000
+)
+
+type BlueskyService interface {
+	LinkAccount(ctx context.Context, userID string, identifier string, appPassword string) (string, error)
+	Session(ctx context.Context, userID string) (*models.BlueskyModel, error)
+	PostToBluesky(ctx context.Context, session *models.BlueskyModel, text string, langs []string, files []*multipart.FileHeader, altText []string) (string, string, error)
+	IsLinked(ctx context.Context, userID string) bool
+	Unlink(ctx context.Context, userID string) error
+}
+
+type blueskyServiceImpl struct {
+	repo_bluesky repo_bluesky.BlueskyRepository
+}
+
+func NewBlueskyService(repo repo_bluesky.BlueskyRepository) BlueskyService {
+	return &blueskyServiceImpl{
+		repo_bluesky: repo,
+	}
+}
+
+// LinkAccount creates a session with an app password and stores it for the
+// user. The app password is kept so a new session can be created once the
+// refresh token has expired as well.
+func (s *blueskyServiceImpl) LinkAccount(ctx context.Context, userID string, identifier string, appPassword string) (string, error) {
+	identifier = strings.TrimPrefix(strings.TrimSpace(identifier), "@")
+
+	session, err := s.repo_bluesky.CreateSession(ctx, repo_bluesky.DefaultPDSURL, identifier, appPassword)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo_bluesky.SaveSession(ctx, &models.BlueskyModel{
+		UserID:      userID,
+		DID:         session.DID,
+		Handle:      session.Handle,
+		PDSURL:      session.PDSURL(repo_bluesky.DefaultPDSURL),
+		AccessJWT:   session.AccessJWT,
+		RefreshJWT:  session.RefreshJWT,
+		AppPassword: appPassword,
+	})
+	if err != nil {
+		return "", err
+	}
+	return session.Handle, nil
+}
+
+// Session returns the stored session for the user, refreshing it first if
+// the access token is about to expire.
+func (s *blueskyServiceImpl) Session(ctx context.Context, userID string) (*models.BlueskyModel, error) {
+	stored, err := s.repo_bluesky.GetSession(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tokenExpiresWithin(stored.AccessJWT, refreshMargin) {
+		return stored, nil
+	}
+
+	log.Printf("[BLUESKY_SERVICE] --- Refreshing session for %s", stored.Handle)
+	session, err := s.repo_bluesky.RefreshSession(ctx, stored.PDSURL, stored.RefreshJWT)
+	if err != nil {
+		log.Printf("[BLUESKY_SERVICE] --- Refresh failed, creating a new session: %v", err)
+		session, err = s.repo_bluesky.CreateSession(ctx, stored.PDSURL, stored.DID, stored.AppPassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stored.AccessJWT = session.AccessJWT
+	stored.RefreshJWT = session.RefreshJWT
+	if session.Handle != "" {
+		stored.Handle = session.Handle
+	}
+	stored.PDSURL = session.PDSURL(stored.PDSURL)
+
+	// The PDS has already rotated the refresh token, so the new one is saved
+	// even if the caller has given up.
+	if err := s.repo_bluesky.SaveSession(context.WithoutCancel(ctx), stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// tokenExpiresWithin reads the exp claim of a JWT without verifying it; the
+// PDS is the one that checks the signature.
+func tokenExpiresWithin(token string, margin time.Duration) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return true
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return true
+	}
+	return time.Until(exp.Time) < margin
+}
+
+// PostToBluesky creates the post with a session from Session and returns
+// its at:// URI and bsky.app link.
+func (s *blueskyServiceImpl) PostToBluesky(ctx context.Context, session *models.BlueskyModel, text string, langs []string, files []*multipart.FileHeader, altText []string) (string, string, error) {
+	record := map[string]any{
+		"$type":     postCollection,
+		"text":      text,
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	facets := buildFacets(text, func(handle string) (string, error) {
+		return s.repo_bluesky.ResolveHandle(ctx, session.PDSURL, handle)
+	})
+	if len(facets) > 0 {
+		record["facets"] = facets
+	}
+	if len(langs) > 0 {
+		record["langs"] = langs
+	}
+
+	if len(files) > 0 {
+		images := []map[string]any{}
+		publisher.Uploading(ctx, 0, len(files))
+		for idx, fh := range files {
+			blob, err := s.uploadImage(ctx, session, fh)
+			if err != nil {
+				return "", "", err
+			}
+			images = append(images, map[string]any{"alt": altText[idx], "image": blob})
+			publisher.Uploading(ctx, idx+1, len(files))
+		}
+		record["embed"] = map[string]any{
+			"$type":  "app.bsky.embed.images",
+			"images": images,
+		}
+	}
+
+	publisher.Publishing(ctx)
+	created, err := s.repo_bluesky.CreateRecord(ctx, session.PDSURL, session.AccessJWT, session.DID, postCollection, record)
+	if err != nil {
+		return "", "", err
+	}
+
+	return created.URI, postURL(session.Handle, created.URI), nil
+}
+
+func (s *blueskyServiceImpl) uploadImage(ctx context.Context, session *models.BlueskyModel, fh *multipart.FileHeader) (any, error) {
+	if fh.Size > maxImageBytes {
+		return nil, fmt.Errorf("image %s is larger than %d bytes", fh.Filename, maxImageBytes)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", fh.Filename, err)
+	}
+
+	mimeType := http.DetectContentType(data)
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return nil, fmt.Errorf("unsupported media type: %s", mimeType)
+	}
+
+	return s.repo_bluesky.UploadBlob(ctx, session.PDSURL, session.AccessJWT, data, mimeType)
+}
+
+// postURL turns an at:// record URI into a bsky.app link.
+func postURL(handle string, uri string) string {
+	parts := strings.Split(uri, "/")
+	if len(parts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://bsky.app/profile/%s/post/%s", handle, parts[len(parts)-1])
+}
+
+func (s *blueskyServiceImpl) IsLinked(ctx context.Context, userID string) bool {
+	session, err := s.Session(ctx, userID)
+	if err != nil {
+		return false
+	}
+	return s.repo_bluesky.CheckSession(ctx, session.PDSURL, session.AccessJWT) == nil
+}
+
+// Unlink ends the session on the PDS and deletes it. The app password
+// itself can only be revoked by the user in the Bluesky settings.
+func (s *blueskyServiceImpl) Unlink(ctx context.Context, userID string) error {
+	stored, err := s.repo_bluesky.GetSession(ctx, userID)
+	if err == nil {
+		if err := s.repo_bluesky.RevokeSession(ctx, stored.PDSURL, stored.RefreshJWT); err != nil {
+			log.Printf("[BLUESKY_SERVICE] --- %v", err)
+		}
+	}
+	return s.repo_bluesky.DeleteSession(ctx, userID)
+}
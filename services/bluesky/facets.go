@@ -0,0 +1,60 @@
+package bluesky
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	linkRegex    = regexp.MustCompile(`https?://[^\s<>"]+`)
+	mentionRegex = regexp.MustCompile(`(?:^|[\s(])(@([a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)+))`)
+)
+
+type facetIndex struct {
+	ByteStart int `json:"byteStart"`
+	ByteEnd   int `json:"byteEnd"`
+}
+
+type facetFeature struct {
+	Type string `json:"$type"`
+	URI  string `json:"uri,omitempty"`
+	DID  string `json:"did,omitempty"`
+}
+
+type facet struct {
+	Index    facetIndex     `json:"index"`
+	Features []facetFeature `json:"features"`
+}
+
+// buildFacets detects links and @mentions in text. Facet offsets are UTF-8
+// byte offsets, which is what Go's regexp reports. Mentions are only kept
+// when resolveHandle finds a DID for them.
+func buildFacets(text string, resolveHandle func(handle string) (string, error)) []facet {
+	facets := []facet{}
+
+	for _, m := range linkRegex.FindAllStringIndex(text, -1) {
+		start, end := m[0], m[1]
+		// Trailing punctuation is almost always part of the sentence.
+		uri := strings.TrimRight(text[start:end], ".,;:!?)'")
+		end = start + len(uri)
+		facets = append(facets, facet{
+			Index:    facetIndex{ByteStart: start, ByteEnd: end},
+			Features: []facetFeature{{Type: "app.bsky.richtext.facet#link", URI: uri}},
+		})
+	}
+
+	for _, m := range mentionRegex.FindAllStringSubmatchIndex(text, -1) {
+		start, end := m[2], m[3]
+		handle := text[m[4]:m[5]]
+		did, err := resolveHandle(handle)
+		if err != nil || did == "" {
+			continue
+		}
+		facets = append(facets, facet{
+			Index:    facetIndex{ByteStart: start, ByteEnd: end},
+			Features: []facetFeature{{Type: "app.bsky.richtext.facet#mention", DID: did}},
+		})
+	}
+
+	return facets
+}
@@ -0,0 +1,103 @@
+package bluesky
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const zeroWidthJoiner = 0x200D
+
+// countGraphemes returns the number of user-perceived characters in text,
+// which is what Bluesky limits posts by. It follows the Unicode extended
+// grapheme cluster rules that matter in practice: a line break made of
+// \r\n, combining marks, emoji modifiers and zero width joiner sequences,
+// flags made of two regional indicators, and Hangul syllables written as
+// separate jamo.
+func countGraphemes(text string) int {
+	count := 0
+	prev := rune(-1)
+	pairedIndicator := false
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		text = text[size:]
+
+		joined := false
+		switch {
+		case prev < 0:
+		case prev == '\r' && r == '\n':
+			joined = true
+		case extendsGrapheme(r):
+			joined = true
+		case prev == zeroWidthJoiner && isPictographic(r):
+			joined = true
+		case isRegionalIndicator(prev) && isRegionalIndicator(r) && !pairedIndicator:
+			joined = true
+		case joinsHangul(prev, r):
+			joined = true
+		}
+
+		pairedIndicator = joined && isRegionalIndicator(r)
+		if !joined {
+			count++
+		}
+		prev = r
+	}
+	return count
+}
+
+// extendsGrapheme reports combining marks, variation selectors, emoji skin
+// tones, tag characters and the zero width joiner, which all belong to the
+// character before them.
+func extendsGrapheme(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		r == zeroWidthJoiner ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) ||
+		(r >= 0xE0020 && r <= 0xE007F)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isPictographic(r rune) bool {
+	return (r >= 0x1F000 && r <= 0x1FAFF) ||
+		(r >= 0x2600 && r <= 0x27BF) ||
+		(r >= 0x2300 && r <= 0x23FF) ||
+		(r >= 0x2B00 && r <= 0x2BFF) ||
+		r == 0x00A9 || r == 0x00AE || r == 0x203C || r == 0x2049 || r == 0x2122
+}
+
+// joinsHangul reports whether the Hangul jamo or syllable r continues the
+// syllable that prev is part of.
+func joinsHangul(prev rune, r rune) bool {
+	prevType, rType := hangulType(prev), hangulType(r)
+	switch prevType {
+	case 'L':
+		return rType == 'L' || rType == 'V' || rType == 'v' || rType == 't'
+	case 'V', 'v':
+		return rType == 'V' || rType == 'T'
+	case 'T', 't':
+		return rType == 'T'
+	}
+	return false
+}
+
+// hangulType classifies r as a leading consonant (L), vowel (V), trailing
+// consonant (T), precomposed syllable without (v) or with (t) a trailing
+// consonant, or 0 for anything else.
+func hangulType(r rune) byte {
+	switch {
+	case (r >= 0x1100 && r <= 0x115F) || (r >= 0xA960 && r <= 0xA97C):
+		return 'L'
+	case (r >= 0x1160 && r <= 0x11A7) || (r >= 0xD7B0 && r <= 0xD7C6):
+		return 'V'
+	case (r >= 0x11A8 && r <= 0x11FF) || (r >= 0xD7CB && r <= 0xD7FB):
+		return 'T'
+	case r >= 0xAC00 && r <= 0xD7A3:
+		if (r-0xAC00)%28 == 0 {
+			return 'v'
+		}
+		return 't'
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package bluesky
+
+import (
+	"backend/services/publisher"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCountGraphemes(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"crlf is one", "a\r\nb", 3},
+		{"lf and cr are separate", "\n\r", 2},
+		{"combining mark", "cafe\u0301", 4},
+		{"several combining marks", "a\u0301\u0302\u0303", 1},
+		{"devanagari", "नमस्ते", 4},
+		{"emoji", "👍", 1},
+		{"skin tone", "👍🏽", 1},
+		{"variation selector", "❤️", 1},
+		{"keycap", "1️⃣", 1},
+		{"zwj family", "👨‍👩‍👧‍👦", 1},
+		{"zwj profession", "👩🏽‍💻", 1},
+		{"flag", "🇯🇵", 1},
+		{"two flags", "🇯🇵🇫🇷", 2},
+		{"odd regional indicators", "🇯🇵🇫", 2},
+		{"tag sequence", "🏴\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", 1},
+		{"precomposed hangul", "한국어", 3},
+		{"hangul jamo", "\u1112\u1161\u11ab\u1100\u116e\u11a8", 2},
+		{"hangul lvt syllable", "\uac01", 1},
+		{"hangul lvt does not take a vowel", "\uac01\u1161", 2},
+		{"cjk", "日本語", 3},
+		{"mixed", "hi 👋🏼 🇺🇸!", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countGraphemes(tt.text); got != tt.want {
+				t.Errorf("countGraphemes(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCountsGraphemes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"at limit", strings.Repeat("a", maxPostLength), true},
+		{"over limit", strings.Repeat("a", maxPostLength+1), false},
+		{"emoji at limit", strings.Repeat("👨‍👩‍👧", maxPostLength), true},
+		{"emoji over limit", strings.Repeat("👍🏽", maxPostLength+1), false},
+	}
+	p := NewBlueskyPublisher(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(map[string]string{"content": tt.content})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.Validate(&publisher.Request{PlatformData: data})
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package idempotency
+
+import (
+	"backend/models"
+	repo_idempotency "backend/repositories/idempotency"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	// A repeat waits this long for the first request to finish before it
+	// is turned away.
+	waitTimeout  = 10 * time.Second
+	pollInterval = 500 * time.Millisecond
+	// A key whose request has been running for longer than any upload can
+	// take belongs to a server that died, and can be claimed again.
+	abandonAfter  = 15 * time.Minute
+	sweepInterval = time.Hour
+)
+
+var (
+	// ErrInProgress is returned when the first request with a key is still
+	// running after a repeat has waited for it.
+	ErrInProgress = errors.New("a request with this Idempotency-Key is still in progress")
+	// ErrKeyReused is returned when a key is sent again with a different
+	// request.
+	ErrKeyReused = errors.New("this Idempotency-Key was already used for a different request")
+)
+
+type IdempotencyService interface {
+	// Begin claims key for a request with the given fingerprint and
+	// returns nil. If the key was used before, it returns the stored
+	// response instead, waiting for it if the first request is still
+	// running.
+	Begin(ctx context.Context, userID string, key string, fingerprint string) (*models.IdempotencyKey, error)
+	// Complete stores the response to replay for the key.
+	Complete(userID string, key string, status int, body json.RawMessage) error
+	// Release forgets the key, so the request can be retried with it.
+	Release(userID string, key string) error
+	// Run deletes expired keys until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+type idempotencyServiceImpl struct {
+	repo_idempotency repo_idempotency.IdempotencyRepository
+	ttl              time.Duration
+}
+
+// NewIdempotencyService keeps keys for ttl after their first use.
+func NewIdempotencyService(repo repo_idempotency.IdempotencyRepository, ttl time.Duration) IdempotencyService {
+	return &idempotencyServiceImpl{
+		repo_idempotency: repo,
+		ttl:              ttl,
+	}
+}
+
+func (s *idempotencyServiceImpl) Begin(ctx context.Context, userID string, key string, fingerprint string) (*models.IdempotencyKey, error) {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		now := time.Now()
+		if err := s.repo_idempotency.Expire(userID, key, now, now.Add(-abandonAfter)); err != nil {
+			return nil, err
+		}
+
+		claimed, err := s.repo_idempotency.Claim(&models.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   now.Add(s.ttl).UTC(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return nil, nil
+		}
+
+		existing, err := s.repo_idempotency.Get(userID, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			if existing.Fingerprint != fingerprint {
+				return nil, ErrKeyReused
+			}
+			if existing.Completed() {
+				return existing, nil
+			}
+		}
+		if now.After(deadline) {
+			return nil, ErrInProgress
+		}
+
+		// The first request is still running, or released the key since
+		// the claim; either way, try again shortly.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (s *idempotencyServiceImpl) Complete(userID string, key string, status int, body json.RawMessage) error {
+	return s.repo_idempotency.Complete(userID, key, status, body)
+}
+
+func (s *idempotencyServiceImpl) Release(userID string, key string) error {
+	return s.repo_idempotency.Delete(userID, key)
+}
+
+func (s *idempotencyServiceImpl) Run(ctx context.Context) {
+	log.Println("[IDEMPOTENCY] --- Worker started")
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		deleted, err := s.repo_idempotency.DeleteExpired(time.Now())
+		if err != nil {
+			log.Printf("[IDEMPOTENCY] --- %v", err)
+		} else if deleted > 0 {
+			log.Printf("[IDEMPOTENCY] --- Deleted %d expired keys", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("[IDEMPOTENCY] --- Worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
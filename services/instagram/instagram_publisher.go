@@ -0,0 +1,159 @@
+package repo_instagram
+
+import (
+	repo_instagram "backend/repositories/instagram"
+	"backend/repositories/ratelimit"
+	"backend/services/media"
+	"backend/services/publisher"
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxCaptionLength = 2200
+	maxCarouselItems = 10
+	// The Instagram app caps alt text at 100 characters, and the API only
+	// takes it on images.
+	maxAltTextLength = 100
+)
+
+// Instagram only publishes JPEG images between 4:5 portrait and 1.91:1
+// landscape. A single video is published as a reel; videos inside a
+// carousel have tighter limits.
+var (
+	reelMediaLimits = media.Limits{
+		MaxItems:        maxCarouselItems,
+		ImageTypes:      []string{"image/jpeg"},
+		MaxImageBytes:   8 << 20,
+		MinAspectRatio:  0.8,
+		MaxAspectRatio:  1.91,
+		VideoTypes:      []string{"video/mp4", "video/quicktime"},
+		VideoCodecs:     []string{"h264", "hevc"},
+		MaxVideoBytes:   300 << 20,
+		MinVideoSeconds: 3,
+		MaxVideoSeconds: 15 * 60,
+		MinFrameRate:    23,
+		MaxFrameRate:    60,
+	}
+	carouselMediaLimits = carouselLimits(reelMediaLimits)
+)
+
+func carouselLimits(limits media.Limits) media.Limits {
+	limits.MaxVideoBytes = 100 << 20
+	limits.MaxVideoSeconds = 60
+	return limits
+}
+
+type instagramData struct {
+	Caption string   `json:"caption"`
+	AltText []string `json:"alt_text"`
+}
+
+type instagramPublisher struct {
+	instagramService InstagramService
+	repo_instagram   repo_instagram.InstagramRepository
+	limits           *ratelimit.Tracker
+}
+
+// NewInstagramPublisher exposes the Instagram service through the publisher registry.
+func NewInstagramPublisher(instagramService InstagramService, repo repo_instagram.InstagramRepository, limits *ratelimit.Tracker) publisher.Publisher {
+	return &instagramPublisher{
+		instagramService: instagramService,
+		repo_instagram:   repo,
+		limits:           limits,
+	}
+}
+
+func (p *instagramPublisher) Platform() string {
+	return "instagram"
+}
+
+func (p *instagramPublisher) Capabilities() publisher.Capabilities {
+	return publisher.Capabilities{
+		Text:          true,
+		Images:        true,
+		Videos:        true,
+		RequiresMedia: true,
+		MaxMedia:      maxCarouselItems,
+		MaxTextLength: maxCaptionLength,
+		Media:         reelMediaLimits,
+
+		MaxAltTextLength: maxAltTextLength,
+	}
+}
+
+func (p *instagramPublisher) Validate(req *publisher.Request) error {
+	var data instagramData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return err
+	}
+	if len(req.Files) == 0 {
+		return publisher.Invalid("An Instagram post must have media.")
+	}
+	if len(req.Files) > maxCarouselItems {
+		return publisher.Invalid("An Instagram carousel can have at most %d items.", maxCarouselItems)
+	}
+	if utf8.RuneCountInString(data.Caption) > maxCaptionLength {
+		return publisher.Invalid("An Instagram caption can be at most %d characters long.", maxCaptionLength)
+	}
+	if err := publisher.CheckAltText("Instagram", data.AltText, req.Files, maxAltTextLength, true); err != nil {
+		return err
+	}
+	limits := reelMediaLimits
+	if len(req.Files) > 1 {
+		limits = carouselMediaLimits
+	}
+	return publisher.CheckMedia("Instagram", limits, req.Files)
+}
+
+// CheckRateLimit checks the tracked publishing quota and API usage. The
+// quota is fetched from Instagram again when the post is published.
+func (p *instagramPublisher) CheckRateLimit(req *publisher.Request) error {
+	return p.limits.Check("instagram", req.UserID, ratelimit.Need{Endpoint: repo_instagram.EndpointContentPublishing, Calls: 1})
+}
+
+func (p *instagramPublisher) Publish(ctx context.Context, req *publisher.Request) (*publisher.Result, error) {
+	accessToken, instagramID, err := p.repo_instagram.GetCredentials(ctx, req.UserID)
+	if err != nil || accessToken == "" || instagramID == "" {
+		return nil, fmt.Errorf("Instagram %w", publisher.ErrNotLinked)
+	}
+
+	var data instagramData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return nil, err
+	}
+
+	postID, permalink, err := p.instagramService.PostToInstagram(ctx, req.UserID, accessToken, instagramID, data.Caption, req.Files, publisher.AltTextFor(data.AltText, len(req.Files)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to post to Instagram: %w", err)
+	}
+	return &publisher.Result{
+		Message:   "Instagram post published successfully!",
+		RemoteID:  postID,
+		Permalink: permalink,
+	}, nil
+}
+
+func (p *instagramPublisher) IsLinked(ctx context.Context, userID string) bool {
+	accessToken, _, err := p.repo_instagram.GetCredentials(ctx, userID)
+	if err != nil || accessToken == "" {
+		return false
+	}
+	return p.repo_instagram.CheckTokens(ctx, accessToken) == nil
+}
+
+// NeedsReconnect reports whether the stored token has expired or could not
+// be refreshed by the token refresher.
+func (p *instagramPublisher) NeedsReconnect(ctx context.Context, userID string) bool {
+	token, err := p.repo_instagram.GetToken(ctx, userID)
+	if err != nil || token == nil {
+		return false
+	}
+	if token.RefreshError != "" {
+		return true
+	}
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	return err == nil && time.Now().After(expiresAt)
+}
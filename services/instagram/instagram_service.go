@@ -1,7 +1,12 @@
 package repo_instagram
 
 import (
+	"backend/models"
 	repo_instagram "backend/repositories/instagram"
+	"backend/repositories/ratelimit"
+	"backend/services/publisher"
+	service_staging "backend/services/staging"
+	"backend/repositories/httpclient"
 	"context"
 	"fmt"
 	"golang.org/x/oauth2"
@@ -10,29 +15,40 @@ import (
 	"mime/multipart"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type InstagramService interface {
 	HandleLogin(w http.ResponseWriter, r *http.Request, state string)
-	GetAccessToken(code string) (string, int, error)
-	CheckTokensValid(accessToken string) error
-	createContainer(accessToken string, instagramID string, caption string, file *multipart.FileHeader, isCarouselItem bool) (string, error)
-	createCarouselContainer(accessToken string, instagramID string, caption string, files []*multipart.FileHeader) (string, error)
-	publishMedia(accessToken string, instagramID string, creationID string) (string, error)
-	containerStatus(accessToken string, containerID string) (string, error)
-	checkPublishLimit(instagramID string, accessToken string) (bool, error)
-	PostToInstagram(accessToken string, instagramID string, caption string, files []*multipart.FileHeader) (string, error)
+	GetAccessToken(ctx context.Context, code string) (string, int, error)
+	CheckTokensValid(ctx context.Context, accessToken string) error
+	createContainer(ctx context.Context, userID string, accessToken string, instagramID string, caption string, file *multipart.FileHeader, altText string, isCarouselItem bool) (string, string, error)
+	createCarouselContainer(ctx context.Context, userID string, accessToken string, instagramID string, caption string, files []*multipart.FileHeader, altText []string) (string, []string, error)
+	publishMedia(ctx context.Context, accessToken string, instagramID string, creationID string) (string, error)
+	containerStatus(ctx context.Context, accessToken string, containerID string) (string, error)
+	checkPublishLimit(ctx context.Context, instagramID string, accessToken string) (*ratelimit.Quota, error)
+	PostToInstagram(ctx context.Context, userID string, accessToken string, instagramID string, caption string, files []*multipart.FileHeader, altText []string) (string, string, error)
+	Unlink(ctx context.Context, userID string) error
 }
 
+// publishQuotaRefresh is how long the tracked publishing quota is used
+// before it is fetched from Instagram again. Posts published in between are
+// counted against it.
+const publishQuotaRefresh = 10 * time.Minute
+
 type instagramServiceImpl struct {
 	instagramConfig *oauth2.Config
 	repo_instagram  repo_instagram.InstagramRepository
+	staging         service_staging.StagingService
+	limits          *ratelimit.Tracker
 }
 
-func NewInstagramService(config *oauth2.Config, repo repo_instagram.InstagramRepository) InstagramService {
+func NewInstagramService(config *oauth2.Config, repo repo_instagram.InstagramRepository, staging service_staging.StagingService, limits *ratelimit.Tracker) InstagramService {
 	return &instagramServiceImpl{
 		instagramConfig: config,
 		repo_instagram:  repo,
+		staging:         staging,
+		limits:          limits,
 	}
 }
 
@@ -41,38 +57,50 @@ func (i *instagramServiceImpl) HandleLogin(w http.ResponseWriter, r *http.Reques
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
-func (i *instagramServiceImpl) GetAccessToken(code string) (string, int, error) {
-	shortTermToken, err := i.instagramConfig.Exchange(context.Background(), code)
+func (i *instagramServiceImpl) GetAccessToken(ctx context.Context, code string) (string, int, error) {
+	shortTermToken, err := i.instagramConfig.Exchange(context.WithValue(ctx, oauth2.HTTPClient, httpclient.API), code)
 	if err != nil {
 		return "", 0, err
 	}
 
 	fmt.Printf("[INSTAGRAM_SERVICE] --- Short-term token obtained ---")
 
-	return i.repo_instagram.GetAccessToken(shortTermToken.AccessToken, i.instagramConfig.ClientSecret)
+	return i.repo_instagram.GetAccessToken(ctx, shortTermToken.AccessToken, i.instagramConfig.ClientSecret)
 }
 
-func (i *instagramServiceImpl) CheckTokensValid(accessToken string) error {
-	return i.repo_instagram.CheckTokens(accessToken)
+func (i *instagramServiceImpl) CheckTokensValid(ctx context.Context, accessToken string) error {
+	return i.repo_instagram.CheckTokens(ctx, accessToken)
 }
 
-func (i *instagramServiceImpl) checkPublishLimit(accessToken string, instagramID string) (bool, error) {
-	return i.repo_instagram.CheckPublishLimit(accessToken, instagramID)
+// Unlink deletes the user's token. Instagram has no endpoint to revoke a
+// token issued through Instagram Login; it expires on its own.
+func (i *instagramServiceImpl) Unlink(ctx context.Context, userID string) error {
+	return i.repo_instagram.DeleteToken(ctx, userID)
 }
 
-func (i *instagramServiceImpl) uploadMedia(file multipart.File, ext string, mimeType string) (string, error) {
+func (i *instagramServiceImpl) checkPublishLimit(ctx context.Context, accessToken string, instagramID string) (*ratelimit.Quota, error) {
+	return i.repo_instagram.CheckPublishLimit(ctx, accessToken, instagramID)
+}
 
-	return i.repo_instagram.UploadMedia(file, ext, mimeType)
+// uploadMedia stages the file in the bucket for the Graph API to fetch. It
+// returns the public URL and the key to release once the post is published.
+func (i *instagramServiceImpl) uploadMedia(ctx context.Context, userID string, file multipart.File, ext string, mimeType string) (string, string, error) {
+	key := fmt.Sprintf("instagram_%d%s", time.Now().UnixNano(), ext)
+	mediaURL, err := i.staging.Stage(ctx, userID, models.StagePurposeInstagram, file, key, mimeType)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to upload media to Cloudflare: %w", err)
+	}
+	return mediaURL, key, nil
 }
 
-func (i *instagramServiceImpl) createContainer(accessToken string, instagramID string, caption string, file *multipart.FileHeader, isCarouselItem bool) (string, error) {
+func (i *instagramServiceImpl) createContainer(ctx context.Context, userID string, accessToken string, instagramID string, caption string, file *multipart.FileHeader, altText string, isCarouselItem bool) (string, string, error) {
 	log.Println("[CREATE_CONTAINER] --- Starting container creation ---")
 	log.Printf("[CREATE_CONTAINER] --- InstagramID: %s, Caption length: %d, IsCarouselItem: %t ---", instagramID, len(caption), isCarouselItem)
 	
 	f, err := file.Open()
 	if err != nil {
 		log.Printf("[CREATE_CONTAINER] --- Failed to open uploaded file: %v", err)
-		return "", fmt.Errorf("failed to open uploaded file: %w", err)
+		return "", "", fmt.Errorf("failed to open uploaded file: %w", err)
 	}
 	defer func() {
 		err := f.Close()
@@ -85,7 +113,7 @@ func (i *instagramServiceImpl) createContainer(accessToken string, instagramID s
 	bytesRead, err := f.Read(buffer)
 	if err != nil {
 		log.Printf("[CREATE_CONTAINER] --- Failed to read media file: %v", err)
-		return "", fmt.Errorf("failed to read media file: %w", err)
+		return "", "", fmt.Errorf("failed to read media file: %w", err)
 	}
 	mimeType := http.DetectContentType(buffer[:bytesRead])
 	ext, err := getFileExtension(mimeType)
@@ -104,107 +132,144 @@ func (i *instagramServiceImpl) createContainer(accessToken string, instagramID s
 		mediaType = "VIDEO"
 	default:
 		log.Printf("[CREATE_CONTAINER] --- Unsupported media type: %s", mimeType)
-		return "", fmt.Errorf("unsupported media type: %s", mimeType)
+		return "", "", fmt.Errorf("unsupported media type: %s", mimeType)
 	}
 
 	log.Println("[CREATE_CONTAINER] --- Uploading media...")
-	mediaURL, err := i.uploadMedia(f, ext, mimeType)
+	mediaURL, key, err := i.uploadMedia(ctx, userID, f, ext, mimeType)
 	if err != nil {
 		log.Printf("[CREATE_CONTAINER] --- Failed to upload media: %v", err)
-		return "", fmt.Errorf("failed to upload media: %w", err)
+		return "", "", fmt.Errorf("failed to upload media: %w", err)
 	}
 	log.Println("[CREATE_CONTAINER] --- Media uploaded successfully")
 
-	containerID, err := i.repo_instagram.CreateContainer(accessToken, instagramID, caption, mediaURL, mediaType, isCarouselItem)
+	containerID, err := i.repo_instagram.CreateContainer(ctx, accessToken, instagramID, caption, mediaURL, mediaType, altText, isCarouselItem)
 	if err != nil {
 		log.Printf("[CREATE_CONTAINER] --- Failed to create media container: %v", err)
-		return "", fmt.Errorf("failed to create media container: %w", err)
+		return "", "", fmt.Errorf("failed to create media container: %w", err)
 	}
 
 	log.Printf("[CREATE_CONTAINER] --- Media container created successfully with ContainerID: %s", containerID)
-	return containerID, nil
+	return containerID, key, nil
 }
 
-func (i *instagramServiceImpl) createCarouselContainer(accessToken string, instagramID string, caption string, files []*multipart.FileHeader) (string, error) {
+func (i *instagramServiceImpl) createCarouselContainer(ctx context.Context, userID string, accessToken string, instagramID string, caption string, files []*multipart.FileHeader, altText []string) (string, []string, error) {
 	containerIDs := []string{}
-	for _, file := range files {
-		containerID, err := i.createContainer(accessToken, instagramID, caption, file, true)
+	keys := []string{}
+	publisher.Uploading(ctx, 0, len(files))
+	for idx, file := range files {
+		containerID, key, err := i.createContainer(ctx, userID, accessToken, instagramID, caption, file, altText[idx], true)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 		containerIDs = append(containerIDs, containerID)
+		keys = append(keys, key)
+		publisher.Uploading(ctx, idx+1, len(files))
 	}
 
 	// Create a carousel container
-	containerID, err := i.repo_instagram.CreateCarouselContainer(accessToken, instagramID, caption, containerIDs)
+	containerID, err := i.repo_instagram.CreateCarouselContainer(ctx, accessToken, instagramID, caption, containerIDs)
 	if err != nil {
-		return "", fmt.Errorf("failed to create carousel container: %w", err)
+		return "", nil, fmt.Errorf("failed to create carousel container: %w", err)
 	}
-	return containerID, nil
+	return containerID, keys, nil
 }
 
-func (i *instagramServiceImpl) containerStatus(accessToken string, containerID string) (string, error) {
-	return i.repo_instagram.WaitForContainerReady(accessToken, containerID)
+func (i *instagramServiceImpl) containerStatus(ctx context.Context, accessToken string, containerID string) (string, error) {
+	return i.repo_instagram.WaitForContainerReady(ctx, accessToken, containerID)
 }
 
-func (i *instagramServiceImpl) publishMedia(accessToken string, instagramID string, creationID string) (string, error) {
-	return i.repo_instagram.PublishMedia(accessToken, instagramID, creationID)
+func (i *instagramServiceImpl) publishMedia(ctx context.Context, accessToken string, instagramID string, creationID string) (string, error) {
+	return i.repo_instagram.PublishMedia(ctx, accessToken, instagramID, creationID)
 }
 
-func (i *instagramServiceImpl) PostToInstagram(accessToken string, instagramID string, caption string, files []*multipart.FileHeader) (string, error) {
+// PostToInstagram publishes the post and returns its media ID and permalink.
+// The media staged for the Graph API is deleted once the post is published;
+// if publishing fails it is left for the staging janitor, since Instagram
+// may still be fetching it.
+func (i *instagramServiceImpl) PostToInstagram(ctx context.Context, userID string, accessToken string, instagramID string, caption string, files []*multipart.FileHeader, altText []string) (string, string, error) {
 	var containerID string
+	var stagedKeys []string
 	var err error
 
 	fmt.Printf("--------POST TO INSTAGRAM STARTING-----------")
 
-	// 0. Publish Limit Check
-	canPublish, err := i.checkPublishLimit(accessToken, instagramID)
-	if err != nil {
-		return "", err
+	// 0. Publish Limit Check. The quota is only fetched when the tracked
+	// one is stale.
+	account := ratelimit.AccountFrom(ctx)
+	if !i.limits.Fresh("instagram", account, repo_instagram.EndpointContentPublishing, publishQuotaRefresh) {
+		quota, err := i.checkPublishLimit(ctx, accessToken, instagramID)
+		if err != nil {
+			return "", "", err
+		}
+		i.limits.Record(account, *quota)
 	}
-	if !canPublish {
-		return "", fmt.Errorf("Publish limit reached for today")
+	if err := i.limits.Check("instagram", account, ratelimit.Need{Endpoint: repo_instagram.EndpointContentPublishing, Calls: 1}); err != nil {
+		return "", "", err
 	}
 
 	fmt.Printf("--------CAN PUBLISH-----------")
 
 	// 1. Upload media / Create containers
 	if len(files) == 0 {
-		return "", fmt.Errorf("No files attached")
+		return "", "", fmt.Errorf("No files attached")
 	}
 	if len(files) == 1 {
-		containerID, err = i.createContainer(accessToken, instagramID, caption, files[0], false)
+		var key string
+		publisher.Uploading(ctx, 0, 1)
+		containerID, key, err = i.createContainer(ctx, userID, accessToken, instagramID, caption, files[0], altText[0], false)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
+		stagedKeys = append(stagedKeys, key)
+		publisher.Uploading(ctx, 1, 1)
 	} else {
-		containerID, err = i.createCarouselContainer(accessToken, instagramID, caption, files)
+		containerID, stagedKeys, err = i.createCarouselContainer(ctx, userID, accessToken, instagramID, caption, files, altText)
 		if err != nil {
-			return "", err
+			return "", "", err
 		}
 	}
 
 	fmt.Printf("--------CONTAINER CREATED: %s-----------", containerID)
 
 	// 2. Check container status
-	_, err = i.containerStatus(accessToken, containerID)
+	publisher.Processing(ctx, 0)
+	_, err = i.containerStatus(ctx, accessToken, containerID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	fmt.Printf("--------CONTAINER STATUS CHECKED-----------")
 
 	// 3. Publish media
-	postID, err := i.repo_instagram.PublishMedia(accessToken, instagramID, containerID)
+	publisher.Publishing(ctx)
+	postID, err := i.repo_instagram.PublishMedia(ctx, accessToken, instagramID, containerID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
 	fmt.Printf("--------MEDIA PUBLISHED: %s-----------", postID)
+	i.limits.Consume("instagram", account, repo_instagram.EndpointContentPublishing, 1)
+
+	// The post is live, so the clean-up below is finished even if the
+	// caller has gone away.
+	ctx = context.WithoutCancel(ctx)
+
+	// The container is FINISHED and published, so Instagram has its own copy.
+	for _, key := range stagedKeys {
+		if err := i.staging.Release(ctx, key); err != nil {
+			log.Printf("[POST_TO_INSTAGRAM] --- Failed to release staged media: %v", err)
+		}
+	}
 
 	// 4. Return Post URL
+	permalink, err := i.repo_instagram.GetPermalink(ctx, accessToken, postID)
+	if err != nil {
+		// The post is live at this point, so a missing link is not a failure.
+		log.Printf("[POST_TO_INSTAGRAM] --- Failed to fetch permalink for %s: %v", postID, err)
+	}
 
-	return "", nil
+	return postID, permalink, nil
 }
 
 func getFileExtension(mimeType string) (string, error) {
@@ -0,0 +1,88 @@
+package repo_instagram
+
+import (
+	"backend/models"
+	repo_instagram "backend/repositories/instagram"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	refreshInterval = 6 * time.Hour
+	// Long-lived tokens last 60 days; they are refreshed once they are this
+	// close to expiring. Instagram only refreshes tokens that are at least a
+	// day old, which every token in this window is.
+	refreshWindow = 7 * 24 * time.Hour
+)
+
+// TokenRefresher keeps long-lived Instagram tokens from expiring.
+type TokenRefresher interface {
+	Run(ctx context.Context)
+}
+
+type tokenRefresherImpl struct {
+	repo_instagram repo_instagram.InstagramRepository
+}
+
+func NewTokenRefresher(repo repo_instagram.InstagramRepository) TokenRefresher {
+	return &tokenRefresherImpl{
+		repo_instagram: repo,
+	}
+}
+
+// Run refreshes tokens that are close to expiring until ctx is cancelled.
+func (r *tokenRefresherImpl) Run(ctx context.Context) {
+	log.Println("[INSTAGRAM_REFRESHER] --- Worker started")
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		r.refreshExpiring(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("[INSTAGRAM_REFRESHER] --- Worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *tokenRefresherImpl) refreshExpiring(ctx context.Context) {
+	tokens, err := r.repo_instagram.ListExpiring(ctx, time.Now().Add(refreshWindow))
+	if err != nil {
+		log.Printf("[INSTAGRAM_REFRESHER] --- Failed to list expiring tokens: %v", err)
+		return
+	}
+
+	for idx := range tokens {
+		if ctx.Err() != nil {
+			return
+		}
+		r.refresh(ctx, &tokens[idx])
+	}
+}
+
+// refresh renews a single token. On failure the error is stored on the row
+// so the profile page can ask the user to reconnect; the old token is kept
+// and used until it expires.
+func (r *tokenRefresherImpl) refresh(ctx context.Context, token *models.InstagramModel) {
+	accessToken, expiresIn, err := r.repo_instagram.RefreshToken(ctx, token.AccessToken)
+	if err != nil {
+		log.Printf("[INSTAGRAM_REFRESHER] --- Refresh failed for user %s: %v", token.UserID, err)
+		if err := r.repo_instagram.RecordRefreshFailure(ctx, token.UserID, err.Error()); err != nil {
+			log.Printf("[INSTAGRAM_REFRESHER] --- %v", err)
+		}
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second).UTC().Format(time.RFC3339)
+	if err := r.repo_instagram.SaveToken(ctx, accessToken, token.UserID, token.InstagramID, expiresAt); err != nil {
+		log.Printf("[INSTAGRAM_REFRESHER] --- Failed to save refreshed token for user %s: %v", token.UserID, err)
+		return
+	}
+
+	log.Printf("[INSTAGRAM_REFRESHER] --- Refreshed token for user %s, expires at %s", token.UserID, expiresAt)
+}
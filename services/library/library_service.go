@@ -0,0 +1,341 @@
+package library
+
+import (
+	"backend/models"
+	repo_media "backend/repositories/media"
+	repo_storage "backend/repositories/storage"
+	"backend/services/media"
+	service_staging "backend/services/staging"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	objectPrefix = "media/"
+	uploadPrefix = "uploads/"
+
+	// Direct uploads are meant for videos that are too large to go through
+	// the server. Parts are signed one by one so their sizes are enforced.
+	maxDirectUploadBytes = 1 << 30
+	multipartThreshold   = 64 << 20
+	partSize             = 16 << 20
+	uploadExpiry         = time.Hour
+
+	// Assets larger than this are spooled to disk when they are posted.
+	maxSpoolMemory = 32 << 20
+)
+
+type LibraryService interface {
+	// Upload adds a file to the user's library. Uploading bytes the user
+	// already has returns the existing asset with created set to false.
+	Upload(ctx context.Context, userID string, fh *multipart.FileHeader) (*models.MediaAsset, bool, error)
+	Get(userID string, assetID string) (*models.MediaAsset, error)
+	List(userID string, limit int, offset int) ([]models.MediaAsset, int, error)
+	Delete(ctx context.Context, userID string, assetID string) error
+	// Files streams assets into file headers so they can be posted like
+	// uploaded files. The returned form must be cleaned up with RemoveAll.
+	Files(ctx context.Context, userID string, assetIDs []string) ([]*multipart.FileHeader, *multipart.Form, error)
+	// CreateUpload returns presigned requests for uploading a video
+	// straight to object storage.
+	CreateUpload(ctx context.Context, userID string, fileName string, contentType string, size int64) (*models.DirectUpload, error)
+	// CompleteUpload checks a finished direct upload and adds it to the
+	// library like Upload does.
+	CompleteUpload(ctx context.Context, userID string, upload *models.CompletedUpload) (*models.MediaAsset, bool, error)
+	AbortUpload(ctx context.Context, userID string, key string, uploadID string) error
+}
+
+type libraryServiceImpl struct {
+	repo_media   repo_media.MediaRepository
+	repo_storage repo_storage.ObjectStore
+	staging      service_staging.StagingService
+}
+
+func NewLibraryService(repoMedia repo_media.MediaRepository, repoStorage repo_storage.ObjectStore, staging service_staging.StagingService) LibraryService {
+	return &libraryServiceImpl{
+		repo_media:   repoMedia,
+		repo_storage: repoStorage,
+		staging:      staging,
+	}
+}
+
+func (s *libraryServiceImpl) Upload(ctx context.Context, userID string, fh *multipart.FileHeader) (*models.MediaAsset, bool, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open file %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, false, fmt.Errorf("failed to read file %s: %w", fh.Filename, err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	existing, err := s.repo_media.GetByHash(userID, sum)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+
+	info, err := media.Inspect(fh)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", fh.Filename, err)
+	}
+
+	// Other users may have stored the same bytes already. Writing the
+	// object again is harmless since the key is derived from the content.
+	key := objectKey(sum, info.MimeType)
+	publicURL, err := s.repo_storage.UploadFile(ctx, f, key, info.MimeType)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to store file %s: %w", fh.Filename, err)
+	}
+
+	return s.repo_media.Create(newAsset(userID, sum, key, publicURL, fh.Filename, info))
+}
+
+func objectKey(sum string, mimeType string) string {
+	ext := ""
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+	return objectPrefix + sum + ext
+}
+
+func newAsset(userID string, sum string, key string, publicURL string, fileName string, info *media.Info) *models.MediaAsset {
+	return &models.MediaAsset{
+		UserID:     userID,
+		SHA256:     sum,
+		ObjectKey:  key,
+		URL:        publicURL,
+		FileName:   fileName,
+		MimeType:   info.MimeType,
+		Size:       info.Size,
+		Width:      info.Width,
+		Height:     info.Height,
+		DurationMs: info.Duration.Milliseconds(),
+	}
+}
+
+func (s *libraryServiceImpl) Get(userID string, assetID string) (*models.MediaAsset, error) {
+	return s.repo_media.GetByID(userID, assetID)
+}
+
+func (s *libraryServiceImpl) List(userID string, limit int, offset int) ([]models.MediaAsset, int, error) {
+	return s.repo_media.List(userID, limit, offset)
+}
+
+// Delete removes the asset from the user's library. The object is only
+// deleted once no other user's library refers to it.
+func (s *libraryServiceImpl) Delete(ctx context.Context, userID string, assetID string) error {
+	asset, err := s.repo_media.GetByID(userID, assetID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo_media.Delete(userID, assetID); err != nil {
+		return err
+	}
+
+	remaining, err := s.repo_media.CountByHash(asset.SHA256)
+	if err != nil {
+		log.Printf("[LIBRARY_SERVICE] --- %v", err)
+		return nil
+	}
+	if remaining == 0 {
+		if err := s.repo_storage.DeleteFile(ctx, asset.ObjectKey); err != nil {
+			log.Printf("[LIBRARY_SERVICE] --- %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *libraryServiceImpl) Files(ctx context.Context, userID string, assetIDs []string) ([]*multipart.FileHeader, *multipart.Form, error) {
+	sources := make([]media.Source, 0, len(assetIDs))
+	for _, assetID := range assetIDs {
+		asset, err := s.repo_media.GetByID(userID, assetID)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := asset.ObjectKey
+		sources = append(sources, media.Source{
+			FileName:    asset.FileName,
+			ContentType: asset.MimeType,
+			Open:        func() (io.ReadCloser, error) { return s.repo_storage.OpenFile(ctx, key) },
+		})
+	}
+	return media.SpoolFiles(sources, maxSpoolMemory)
+}
+
+func (s *libraryServiceImpl) CreateUpload(ctx context.Context, userID string, fileName string, contentType string, size int64) (*models.DirectUpload, error) {
+	if !strings.HasPrefix(contentType, "video/") {
+		return nil, fmt.Errorf("only videos can be uploaded directly, got %s", contentType)
+	}
+	if size <= 0 || size > maxDirectUploadBytes {
+		return nil, fmt.Errorf("size must be between 1 byte and %d bytes", maxDirectUploadBytes)
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate upload key: %w", err)
+	}
+	upload := &models.DirectUpload{
+		Key:       uploadPrefix + userID + "/" + hex.EncodeToString(token) + uploadExt(fileName),
+		ExpiresAt: time.Now().Add(uploadExpiry).UTC(),
+	}
+
+	// Uploads that are never completed or aborted are swept by the staging
+	// janitor.
+	if err := s.staging.Track(ctx, userID, models.StagePurposeDirectUpload, upload.Key); err != nil {
+		return nil, err
+	}
+
+	if size <= multipartThreshold {
+		put, err := s.repo_storage.PresignPut(ctx, upload.Key, contentType, size, uploadExpiry)
+		if err != nil {
+			return nil, err
+		}
+		upload.Put = put
+		return upload, nil
+	}
+
+	uploadID, err := s.repo_storage.CreateMultipartUpload(ctx, upload.Key, contentType)
+	if err != nil {
+		return nil, err
+	}
+	upload.UploadID = uploadID
+	upload.PartSize = partSize
+	for number, offset := int32(1), int64(0); offset < size; number, offset = number+1, offset+partSize {
+		length := min(partSize, size-offset)
+		req, err := s.repo_storage.PresignUploadPart(ctx, upload.Key, uploadID, number, length, uploadExpiry)
+		if err != nil {
+			if err := s.repo_storage.AbortMultipartUpload(ctx, upload.Key, uploadID); err != nil {
+				log.Printf("[LIBRARY_SERVICE] --- %v", err)
+			}
+			return nil, err
+		}
+		upload.Parts = append(upload.Parts, models.DirectPart{PartNumber: number, Size: length, Request: req})
+	}
+	return upload, nil
+}
+
+// CompleteUpload assembles the parts of a multipart upload, inspects the
+// video with ranged reads and hashes it while streaming it once. The object
+// is then moved to its content-addressed key, or dropped if the user
+// already has the same bytes in the library.
+func (s *libraryServiceImpl) CompleteUpload(ctx context.Context, userID string, upload *models.CompletedUpload) (*models.MediaAsset, bool, error) {
+	if !s.ownsUpload(userID, upload.Key) {
+		return nil, false, fmt.Errorf("unknown upload %s", upload.Key)
+	}
+
+	if upload.UploadID != "" {
+		etags := map[int32]string{}
+		for _, part := range upload.Parts {
+			etags[part.PartNumber] = part.ETag
+		}
+		if err := s.repo_storage.CompleteMultipartUpload(ctx, upload.Key, upload.UploadID, etags); err != nil {
+			return nil, false, err
+		}
+	}
+
+	asset, created, err := s.storeUpload(ctx, userID, upload)
+	if err != nil {
+		if err := s.staging.Release(ctx, upload.Key); err != nil {
+			log.Printf("[LIBRARY_SERVICE] --- %v", err)
+		}
+		return nil, false, err
+	}
+	return asset, created, nil
+}
+
+func (s *libraryServiceImpl) storeUpload(ctx context.Context, userID string, upload *models.CompletedUpload) (*models.MediaAsset, bool, error) {
+	size, err := s.repo_storage.FileSize(ctx, upload.Key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	info, err := media.InspectReader(s.repo_storage.FileReaderAt(ctx, upload.Key, size), size)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", upload.FileName, err)
+	}
+	if info.Kind != media.KindVideo {
+		return nil, false, fmt.Errorf("%s: only videos can be uploaded directly", upload.FileName)
+	}
+
+	body, err := s.repo_storage.OpenFile(ctx, upload.Key)
+	if err != nil {
+		return nil, false, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, body)
+	body.Close()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read upload %s: %w", upload.Key, err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	existing, err := s.repo_media.GetByHash(userID, sum)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing == nil {
+		key := objectKey(sum, info.MimeType)
+		if err := s.repo_storage.CopyFile(ctx, upload.Key, key, info.MimeType); err != nil {
+			return nil, false, err
+		}
+		existing, _, err = s.repo_media.Create(newAsset(userID, sum, key, s.repo_storage.PublicURL(key), upload.FileName, info))
+		if err != nil {
+			return nil, false, err
+		}
+		if err := s.staging.Release(ctx, upload.Key); err != nil {
+			log.Printf("[LIBRARY_SERVICE] --- %v", err)
+		}
+		return existing, true, nil
+	}
+
+	if err := s.staging.Release(ctx, upload.Key); err != nil {
+		log.Printf("[LIBRARY_SERVICE] --- %v", err)
+	}
+	return existing, false, nil
+}
+
+func (s *libraryServiceImpl) AbortUpload(ctx context.Context, userID string, key string, uploadID string) error {
+	if !s.ownsUpload(userID, key) {
+		return fmt.Errorf("unknown upload %s", key)
+	}
+	if uploadID != "" {
+		if err := s.repo_storage.AbortMultipartUpload(ctx, key, uploadID); err != nil {
+			return err
+		}
+	}
+	return s.staging.Release(ctx, key)
+}
+
+// uploadExt keeps the extension of the browser's file name when it is a
+// plain one such as ".mp4".
+func uploadExt(fileName string) string {
+	ext := path.Ext(fileName)
+	for _, r := range ext[min(1, len(ext)):] {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// ownsUpload checks that a key handed back by the browser is one of the
+// user's direct uploads, so it can't be used to reach other objects.
+func (s *libraryServiceImpl) ownsUpload(userID string, key string) bool {
+	prefix := uploadPrefix + userID + "/"
+	return strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/")
+}
@@ -0,0 +1,130 @@
+package mastodon
+
+import (
+	"backend/services/media"
+	"backend/services/publisher"
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// Instances can raise this, but 500 is the default and the only limit
+	// that holds everywhere.
+	maxStatusLength = 500
+	maxStatusMedia  = 4
+	// Media descriptions are limited separately from the status text.
+	maxAltTextLength = 1500
+)
+
+// statusMediaLimits are the defaults of a stock Mastodon instance. A status
+// has up to four images, or a single video.
+var statusMediaLimits = media.Limits{
+	MaxItems:      maxStatusMedia,
+	MaxVideos:     1,
+	NoMixedMedia:  true,
+	ImageTypes:    []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+	MaxImageBytes: 16 << 20,
+	VideoTypes:    []string{"video/mp4", "video/quicktime"},
+	MaxVideoBytes: 99 << 20,
+}
+
+type mastodonData struct {
+	Content     string   `json:"content"`
+	Visibility  string   `json:"visibility"`
+	SpoilerText string   `json:"spoiler_text"`
+	Language    string   `json:"language"`
+	Sensitive   bool     `json:"sensitive"`
+	AltText     []string `json:"alt_text"`
+}
+
+var validVisibilities = map[string]bool{
+	"":         true,
+	"public":   true,
+	"unlisted": true,
+	"private":  true,
+	"direct":   true,
+}
+
+type mastodonPublisher struct {
+	mastodonService MastodonService
+}
+
+// NewMastodonPublisher exposes the Mastodon service through the publisher registry.
+func NewMastodonPublisher(mastodonService MastodonService) publisher.Publisher {
+	return &mastodonPublisher{
+		mastodonService: mastodonService,
+	}
+}
+
+func (p *mastodonPublisher) Platform() string {
+	return "mastodon"
+}
+
+func (p *mastodonPublisher) Capabilities() publisher.Capabilities {
+	return publisher.Capabilities{
+		Text:          true,
+		Images:        true,
+		Videos:        true,
+		MaxMedia:      maxStatusMedia,
+		MaxTextLength: maxStatusLength,
+		Media:         statusMediaLimits,
+
+		MaxAltTextLength: maxAltTextLength,
+	}
+}
+
+func (p *mastodonPublisher) Validate(req *publisher.Request) error {
+	var data mastodonData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return err
+	}
+	if data.Content == "" && len(req.Files) == 0 {
+		return publisher.Invalid("A Mastodon post must have either text content or media.")
+	}
+	if len(req.Files) > maxStatusMedia {
+		return publisher.Invalid("A Mastodon post can have at most %d media items.", maxStatusMedia)
+	}
+	// The content warning counts towards the character limit.
+	if utf8.RuneCountInString(data.Content)+utf8.RuneCountInString(data.SpoilerText) > maxStatusLength {
+		return publisher.Invalid("A Mastodon post and its content warning can be at most %d characters long.", maxStatusLength)
+	}
+	if !validVisibilities[data.Visibility] {
+		return publisher.Invalid("Invalid visibility %q, expected public, unlisted, private or direct.", data.Visibility)
+	}
+	if err := publisher.CheckAltText("Mastodon", data.AltText, req.Files, maxAltTextLength, false); err != nil {
+		return err
+	}
+	return publisher.CheckMedia("Mastodon", statusMediaLimits, req.Files)
+}
+
+func (p *mastodonPublisher) Publish(ctx context.Context, req *publisher.Request) (*publisher.Result, error) {
+	if !p.mastodonService.IsLinked(ctx, req.UserID) {
+		return nil, fmt.Errorf("Mastodon %w", publisher.ErrNotLinked)
+	}
+
+	var data mastodonData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return nil, err
+	}
+
+	statusID, statusURL, err := p.mastodonService.PostStatus(ctx, req.UserID, data.Content, StatusOptions{
+		Visibility:  data.Visibility,
+		SpoilerText: data.SpoilerText,
+		Language:    data.Language,
+		Sensitive:   data.Sensitive,
+		AltText:     publisher.AltTextFor(data.AltText, len(req.Files)),
+	}, req.Files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post to Mastodon: %w", err)
+	}
+	return &publisher.Result{
+		Message:   "Mastodon post published successfully!",
+		RemoteID:  statusID,
+		Permalink: statusURL,
+	}, nil
+}
+
+func (p *mastodonPublisher) IsLinked(ctx context.Context, userID string) bool {
+	return p.mastodonService.IsLinked(ctx, userID)
+}
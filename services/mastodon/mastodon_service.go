@@ -0,0 +1,264 @@
+package mastodon
+
+import (
+	"backend/models"
+	repo_mastodon "backend/repositories/mastodon"
+	"backend/services/publisher"
+	"context"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	mediaProcessingTimeout = 2 * time.Minute
+	initialMediaBackoff    = time.Second
+	maxMediaBackoff        = 8 * time.Second
+)
+
+// StatusOptions are the per-post settings carried in platformData.
+type StatusOptions struct {
+	Visibility  string
+	SpoilerText string
+	Language    string
+	Sensitive   bool
+	// AltText describes each file, by index, with one entry per file.
+	AltText []string
+}
+
+type MastodonService interface {
+	GetAuthorizationURL(ctx context.Context, instance string, state string) (string, string, error)
+	CompleteLink(ctx context.Context, userID string, instanceURL string, code string) (string, error)
+	PostStatus(ctx context.Context, userID string, content string, options StatusOptions, files []*multipart.FileHeader) (string, string, error)
+	IsLinked(ctx context.Context, userID string) bool
+	Unlink(ctx context.Context, userID string) error
+}
+
+type mastodonServiceImpl struct {
+	repo_mastodon repo_mastodon.MastodonRepository
+	redirectURL   string
+}
+
+func NewMastodonService(repo repo_mastodon.MastodonRepository, redirectURL string) MastodonService {
+	return &mastodonServiceImpl{
+		repo_mastodon: repo,
+		redirectURL:   redirectURL,
+	}
+}
+
+// NormalizeInstanceURL turns user input such as "mastodon.social" or
+// "https://mastodon.social/" into "https://mastodon.social".
+func NormalizeInstanceURL(instance string) (string, error) {
+	instance = strings.TrimSpace(instance)
+	if instance == "" {
+		return "", fmt.Errorf("instance is required")
+	}
+	if !strings.Contains(instance, "://") {
+		instance = "https://" + instance
+	}
+
+	u, err := url.Parse(instance)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid instance URL")
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("instance must be served over https")
+	}
+	return "https://" + strings.ToLower(u.Host), nil
+}
+
+// app returns the OAuth app for the instance, registering one on the fly
+// the first time a user from that instance links their account.
+func (s *mastodonServiceImpl) app(ctx context.Context, instanceURL string) (*models.MastodonAppModel, error) {
+	app, err := s.repo_mastodon.GetApp(ctx, instanceURL)
+	if err != nil {
+		return nil, err
+	}
+	if app != nil && app.RedirectURI == s.redirectURL {
+		return app, nil
+	}
+
+	log.Printf("[MASTODON_SERVICE] --- Registering app on %s", instanceURL)
+	app, err = s.repo_mastodon.RegisterApp(ctx, instanceURL, s.redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo_mastodon.SaveApp(ctx, app); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// GetAuthorizationURL returns the instance's authorize URL and the
+// normalized instance URL, which the caller keeps for the callback.
+func (s *mastodonServiceImpl) GetAuthorizationURL(ctx context.Context, instance string, state string) (string, string, error) {
+	instanceURL, err := NormalizeInstanceURL(instance)
+	if err != nil {
+		return "", "", err
+	}
+
+	app, err := s.app(ctx, instanceURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := url.Values{}
+	q.Add("client_id", app.ClientID)
+	q.Add("redirect_uri", app.RedirectURI)
+	q.Add("response_type", "code")
+	q.Add("scope", repo_mastodon.Scopes)
+	q.Add("state", state)
+
+	return instanceURL + "/oauth/authorize?" + q.Encode(), instanceURL, nil
+}
+
+func (s *mastodonServiceImpl) CompleteLink(ctx context.Context, userID string, instanceURL string, code string) (string, error) {
+	app, err := s.repo_mastodon.GetApp(ctx, instanceURL)
+	if err != nil {
+		return "", err
+	}
+	if app == nil {
+		return "", fmt.Errorf("no app registered for %s", instanceURL)
+	}
+
+	accessToken, err := s.repo_mastodon.ExchangeCode(ctx, app, code)
+	if err != nil {
+		return "", err
+	}
+
+	username, err := s.repo_mastodon.VerifyCredentials(ctx, instanceURL, accessToken)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.repo_mastodon.SaveToken(ctx, &models.MastodonModel{
+		UserID:      userID,
+		InstanceURL: instanceURL,
+		AccessToken: accessToken,
+		Username:    username,
+	})
+	if err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// PostStatus posts the status and returns its ID and URL.
+func (s *mastodonServiceImpl) PostStatus(ctx context.Context, userID string, content string, options StatusOptions, files []*multipart.FileHeader) (string, string, error) {
+	creds, err := s.repo_mastodon.GetCredentials(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	params := url.Values{}
+	params.Add("status", content)
+	if options.Visibility != "" {
+		params.Add("visibility", options.Visibility)
+	}
+	if options.SpoilerText != "" {
+		params.Add("spoiler_text", options.SpoilerText)
+	}
+	if options.Language != "" {
+		params.Add("language", options.Language)
+	}
+	if options.Sensitive {
+		params.Add("sensitive", "true")
+	}
+
+	if len(files) > 0 {
+		publisher.Uploading(ctx, 0, len(files))
+	}
+	for idx, fh := range files {
+		mediaID, err := s.uploadMedia(ctx, creds, fh, options.AltText[idx])
+		if err != nil {
+			return "", "", err
+		}
+		params.Add("media_ids[]", mediaID)
+		publisher.Uploading(ctx, idx+1, len(files))
+	}
+
+	publisher.Publishing(ctx)
+	status, err := s.repo_mastodon.PostStatus(ctx, creds.InstanceURL, creds.AccessToken, params)
+	if err != nil {
+		return "", "", err
+	}
+	return status.ID, status.URL, nil
+}
+
+// uploadMedia uploads a file and waits for the instance to finish
+// processing it, since statuses cannot reference media that is still being
+// transcoded.
+func (s *mastodonServiceImpl) uploadMedia(ctx context.Context, creds *models.MastodonModel, fh *multipart.FileHeader, description string) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open file %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	media, ready, err := s.repo_mastodon.UploadMedia(ctx, creds.InstanceURL, creds.AccessToken, f, fh.Filename, description)
+	if err != nil {
+		return "", err
+	}
+
+	if !ready {
+		publisher.Processing(ctx, 0)
+	}
+	backoff := initialMediaBackoff
+	deadline := time.Now().Add(mediaProcessingTimeout)
+	for !ready {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("timed out while waiting for media %s to be processed", media.ID)
+		}
+
+		log.Printf("[MASTODON_SERVICE] --- Media %s still processing, checking again in %v", media.ID, backoff)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < maxMediaBackoff {
+			backoff *= 2
+		}
+
+		media, ready, err = s.repo_mastodon.GetMedia(ctx, creds.InstanceURL, creds.AccessToken, media.ID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return media.ID, nil
+}
+
+func (s *mastodonServiceImpl) IsLinked(ctx context.Context, userID string) bool {
+	creds, err := s.repo_mastodon.GetCredentials(ctx, userID)
+	if err != nil {
+		return false
+	}
+	_, err = s.repo_mastodon.VerifyCredentials(ctx, creds.InstanceURL, creds.AccessToken)
+	return err == nil
+}
+
+// Unlink revokes the token on the instance and deletes it.
+func (s *mastodonServiceImpl) Unlink(ctx context.Context, userID string) error {
+	creds, err := s.repo_mastodon.GetCredentials(ctx, userID)
+	if err == nil {
+		if err := s.revoke(ctx, creds); err != nil {
+			log.Printf("[MASTODON_SERVICE] --- %v", err)
+		}
+	}
+	return s.repo_mastodon.DeleteToken(ctx, userID)
+}
+
+func (s *mastodonServiceImpl) revoke(ctx context.Context, creds *models.MastodonModel) error {
+	app, err := s.repo_mastodon.GetApp(ctx, creds.InstanceURL)
+	if err != nil {
+		return err
+	}
+	if app == nil {
+		return fmt.Errorf("no app registered for %s", creds.InstanceURL)
+	}
+	return s.repo_mastodon.RevokeToken(ctx, app, creds.AccessToken)
+}
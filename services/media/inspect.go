@@ -0,0 +1,134 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindImage Kind = "image"
+	KindVideo Kind = "video"
+)
+
+// Info is what Inspect found out about a media file. Video fields are only
+// set for videos.
+type Info struct {
+	Kind      Kind
+	MimeType  string
+	Size      int64
+	Width     int
+	Height    int
+	Duration  time.Duration
+	Codec     string
+	FrameRate float64
+}
+
+// AspectRatio returns width divided by height, or 0 when the dimensions are
+// unknown.
+func (i *Info) AspectRatio() float64 {
+	if i.Width == 0 || i.Height == 0 {
+		return 0
+	}
+	return float64(i.Width) / float64(i.Height)
+}
+
+// Inspect opens an uploaded file and reads its type, dimensions and, for
+// videos, the duration, codec and frame rate. Only the headers are read, so
+// it is cheap even for large videos.
+func Inspect(fh *multipart.FileHeader) (*Info, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	return InspectReader(f, fh.Size)
+}
+
+// InspectReader inspects size bytes of media read from r.
+func InspectReader(r io.ReaderAt, size int64) (*Info, error) {
+	head := make([]byte, 512)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read media header: %w", err)
+	}
+	head = head[:n]
+
+	info := &Info{Size: size, MimeType: detectType(head)}
+	switch info.MimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		info.Kind = KindImage
+		config, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image dimensions: %w", err)
+		}
+		info.Width, info.Height = config.Width, config.Height
+	case "image/webp":
+		info.Kind = KindImage
+		info.Width, info.Height, err = webpSize(head)
+		if err != nil {
+			return nil, err
+		}
+	case "video/mp4", "video/quicktime":
+		info.Kind = KindVideo
+		if err := inspectMP4(r, size, info); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported media type %s", info.MimeType)
+	}
+	return info, nil
+}
+
+// detectType sniffs the content type. MP4 and QuickTime files are told
+// apart by the major brand of their ftyp box, which http.DetectContentType
+// does not do.
+func detectType(head []byte) string {
+	if len(head) >= 12 && string(head[4:8]) == "ftyp" {
+		if string(head[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	}
+	return http.DetectContentType(head)
+}
+
+// webpSize reads the canvas size from the first chunk of a WebP file, which
+// is VP8 (lossy), VP8L (lossless) or VP8X (extended).
+func webpSize(head []byte) (int, int, error) {
+	if len(head) < 30 || string(head[0:4]) != "RIFF" || string(head[8:12]) != "WEBP" {
+		return 0, 0, fmt.Errorf("invalid WebP header")
+	}
+	chunk := head[20:]
+	switch string(head[12:16]) {
+	case "VP8 ":
+		if !bytes.Equal(chunk[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, fmt.Errorf("invalid VP8 frame header")
+		}
+		width := int(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height := int(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+		return width, height, nil
+	case "VP8L":
+		if chunk[0] != 0x2f {
+			return 0, 0, fmt.Errorf("invalid VP8L header")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	case "VP8X":
+		width := int(chunk[4]) | int(chunk[5])<<8 | int(chunk[6])<<16
+		height := int(chunk[7]) | int(chunk[8])<<8 | int(chunk[9])<<16
+		return width + 1, height + 1, nil
+	}
+	return 0, 0, fmt.Errorf("unsupported WebP chunk %q", head[12:16])
+}
@@ -0,0 +1,164 @@
+package media
+
+import (
+	"fmt"
+	"mime/multipart"
+	"strings"
+)
+
+// Limits describes the media a platform accepts in a single post. Zero
+// values mean the platform does not restrict that property.
+type Limits struct {
+	MaxItems int `json:"max_items"`
+	// MaxVideos is the number of videos allowed per post when it is lower
+	// than MaxItems. With NoMixedMedia, images and videos can't be combined.
+	MaxVideos    int  `json:"max_videos,omitempty"`
+	NoMixedMedia bool `json:"no_mixed_media,omitempty"`
+	// GIFs are counted and sized as images unless MaxGIFBytes is set. Then
+	// they have their own size limit, at most MaxGIFs are allowed per post
+	// and, with NoMixedMedia, they can't be combined with any other media.
+	MaxGIFs     int   `json:"max_gifs,omitempty"`
+	MaxGIFBytes int64 `json:"max_gif_bytes,omitempty"`
+
+	ImageTypes    []string `json:"image_types"`
+	MaxImageBytes int64    `json:"max_image_bytes,omitempty"`
+	// Aspect ratios are width divided by height, e.g. 0.8 for 4:5.
+	MinAspectRatio float64 `json:"min_aspect_ratio,omitempty"`
+	MaxAspectRatio float64 `json:"max_aspect_ratio,omitempty"`
+
+	VideoTypes      []string `json:"video_types"`
+	VideoCodecs     []string `json:"video_codecs,omitempty"`
+	MaxVideoBytes   int64    `json:"max_video_bytes,omitempty"`
+	MinVideoSeconds float64  `json:"min_video_seconds,omitempty"`
+	MaxVideoSeconds float64  `json:"max_video_seconds,omitempty"`
+	MinFrameRate    float64  `json:"min_frame_rate,omitempty"`
+	MaxFrameRate    float64  `json:"max_frame_rate,omitempty"`
+}
+
+// ConstraintError names the file that broke a limit and how.
+type ConstraintError struct {
+	Filename string
+	Message  string
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Filename == "" {
+		return e.Message
+	}
+	return e.Filename + ": " + e.Message
+}
+
+// Check inspects every file and checks it against the limits. The platform
+// name is only used in the error messages.
+func (l Limits) Check(platform string, files []*multipart.FileHeader) error {
+	if l.MaxItems > 0 && len(files) > l.MaxItems {
+		return &ConstraintError{Message: fmt.Sprintf("%s allows at most %d media items per post, got %d", platform, l.MaxItems, len(files))}
+	}
+
+	images, videos, gifs := 0, 0, 0
+	for _, fh := range files {
+		info, err := Inspect(fh)
+		if err != nil {
+			return &ConstraintError{Filename: fh.Filename, Message: err.Error()}
+		}
+		if msg := l.checkOne(platform, info); msg != "" {
+			return &ConstraintError{Filename: fh.Filename, Message: msg}
+		}
+		if info.Kind == KindVideo {
+			videos++
+		} else if l.separateGIF(info) {
+			gifs++
+		} else {
+			images++
+		}
+	}
+
+	if l.MaxVideos > 0 && videos > l.MaxVideos {
+		return &ConstraintError{Message: fmt.Sprintf("%s allows at most %d videos per post, got %d", platform, l.MaxVideos, videos)}
+	}
+	if l.NoMixedMedia && images > 0 && videos > 0 {
+		return &ConstraintError{Message: fmt.Sprintf("%s does not allow images and videos in the same post", platform)}
+	}
+	if l.MaxGIFs > 0 && gifs > l.MaxGIFs {
+		return &ConstraintError{Message: fmt.Sprintf("%s allows at most %d GIFs per post, got %d", platform, l.MaxGIFs, gifs)}
+	}
+	if l.NoMixedMedia && gifs > 0 && images+videos > 0 {
+		return &ConstraintError{Message: fmt.Sprintf("%s does not allow GIFs and other media in the same post", platform)}
+	}
+	return nil
+}
+
+// separateGIF reports whether info is a GIF that has limits of its own.
+func (l Limits) separateGIF(info *Info) bool {
+	return l.MaxGIFBytes > 0 && info.MimeType == "image/gif"
+}
+
+func (l Limits) checkOne(platform string, info *Info) string {
+	if info.Kind == KindImage {
+		if !contains(l.ImageTypes, info.MimeType) {
+			return fmt.Sprintf("%s images are not supported by %s (supported: %s)", info.MimeType, platform, typeList(l.ImageTypes))
+		}
+		if l.separateGIF(info) {
+			if info.Size > l.MaxGIFBytes {
+				return fmt.Sprintf("GIF is %s, %s allows at most %s", formatBytes(info.Size), platform, formatBytes(l.MaxGIFBytes))
+			}
+		} else if l.MaxImageBytes > 0 && info.Size > l.MaxImageBytes {
+			return fmt.Sprintf("image is %s, %s allows at most %s", formatBytes(info.Size), platform, formatBytes(l.MaxImageBytes))
+		}
+		ratio := info.AspectRatio()
+		if l.MinAspectRatio > 0 && ratio < l.MinAspectRatio || l.MaxAspectRatio > 0 && ratio > l.MaxAspectRatio {
+			return fmt.Sprintf("image is %dx%d (aspect ratio %.2f), %s requires an aspect ratio between %.2f and %.2f", info.Width, info.Height, ratio, platform, l.MinAspectRatio, l.MaxAspectRatio)
+		}
+		return ""
+	}
+
+	if !contains(l.VideoTypes, info.MimeType) {
+		return fmt.Sprintf("%s videos are not supported by %s (supported: %s)", info.MimeType, platform, typeList(l.VideoTypes))
+	}
+	if len(l.VideoCodecs) > 0 && !contains(l.VideoCodecs, info.Codec) {
+		return fmt.Sprintf("video uses the %s codec, %s requires %s", info.Codec, platform, strings.Join(l.VideoCodecs, " or "))
+	}
+	if l.MaxVideoBytes > 0 && info.Size > l.MaxVideoBytes {
+		return fmt.Sprintf("video is %s, %s allows at most %s", formatBytes(info.Size), platform, formatBytes(l.MaxVideoBytes))
+	}
+	seconds := info.Duration.Seconds()
+	if l.MinVideoSeconds > 0 && seconds < l.MinVideoSeconds {
+		return fmt.Sprintf("video is %.1f seconds long, %s requires at least %g seconds", seconds, platform, l.MinVideoSeconds)
+	}
+	if l.MaxVideoSeconds > 0 && seconds > l.MaxVideoSeconds {
+		return fmt.Sprintf("video is %.1f seconds long, %s allows at most %g seconds", seconds, platform, l.MaxVideoSeconds)
+	}
+	if l.MinFrameRate > 0 && info.FrameRate < l.MinFrameRate-0.01 {
+		return fmt.Sprintf("video is %.2f frames per second, %s requires at least %g", info.FrameRate, platform, l.MinFrameRate)
+	}
+	if l.MaxFrameRate > 0 && info.FrameRate > l.MaxFrameRate+0.01 {
+		return fmt.Sprintf("video is %.2f frames per second, %s allows at most %g", info.FrameRate, platform, l.MaxFrameRate)
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func typeList(types []string) string {
+	if len(types) == 0 {
+		return "none"
+	}
+	return strings.Join(types, ", ")
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,281 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MP4 and QuickTime files are trees of boxes, each starting with a 32-bit
+// size and a four character type. Only the boxes on the path to the
+// metadata are read: moov/mvhd for the duration and, for the first video
+// track, tkhd for the display size, mdhd and stts for the frame rate and
+// stsd for the codec.
+
+// maxBoxRead caps how much of a single metadata box is loaded into memory.
+const maxBoxRead = 16 << 20
+
+type box struct {
+	typ  string
+	data int64 // offset of the payload
+	size int64 // size of the payload
+}
+
+var codecNames = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"vp09": "vp9",
+	"av01": "av1",
+	"mp4v": "mpeg4",
+}
+
+// readBoxes lists the boxes between start and end.
+func readBoxes(r io.ReaderAt, start int64, end int64) ([]box, error) {
+	boxes := []box{}
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("failed to read box header: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("failed to read box header: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return nil, fmt.Errorf("malformed %q box", typ)
+		}
+		boxes = append(boxes, box{typ: typ, data: offset + headerSize, size: size - headerSize})
+		offset += size
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// children lists the boxes inside the box at path, e.g. "mdia", "minf".
+func children(r io.ReaderAt, parent box, path ...string) ([]box, error) {
+	boxes, err := readBoxes(r, parent.data, parent.data+parent.size)
+	if err != nil {
+		return nil, err
+	}
+	for _, typ := range path {
+		b, ok := findBox(boxes, typ)
+		if !ok {
+			return nil, fmt.Errorf("missing %q box", typ)
+		}
+		if boxes, err = readBoxes(r, b.data, b.data+b.size); err != nil {
+			return nil, err
+		}
+	}
+	return boxes, nil
+}
+
+func readPayload(r io.ReaderAt, b box) ([]byte, error) {
+	if b.size > maxBoxRead {
+		return nil, fmt.Errorf("%q box is too large", b.typ)
+	}
+	data := make([]byte, b.size)
+	if _, err := r.ReadAt(data, b.data); err != nil {
+		return nil, fmt.Errorf("failed to read %q box: %w", b.typ, err)
+	}
+	return data, nil
+}
+
+func inspectMP4(r io.ReaderAt, size int64, info *Info) error {
+	top, err := readBoxes(r, 0, size)
+	if err != nil {
+		return err
+	}
+	moov, ok := findBox(top, "moov")
+	if !ok {
+		return fmt.Errorf("video has no moov box")
+	}
+	boxes, err := readBoxes(r, moov.data, moov.data+moov.size)
+	if err != nil {
+		return err
+	}
+
+	mvhd, ok := findBox(boxes, "mvhd")
+	if !ok {
+		return fmt.Errorf("video has no mvhd box")
+	}
+	data, err := readPayload(r, mvhd)
+	if err != nil {
+		return err
+	}
+	timescale, duration, err := headerDuration(data, 12, 20)
+	if err != nil {
+		return fmt.Errorf("malformed mvhd box: %w", err)
+	}
+	info.Duration = scaledDuration(duration, timescale)
+
+	for _, trak := range boxes {
+		if trak.typ != "trak" {
+			continue
+		}
+		isVideo, err := inspectTrack(r, trak, info)
+		if err != nil {
+			return err
+		}
+		if isVideo {
+			return nil
+		}
+	}
+	return fmt.Errorf("video has no video track")
+}
+
+// inspectTrack fills in the video fields of info from a trak box and
+// reports whether it was a video track.
+func inspectTrack(r io.ReaderAt, trak box, info *Info) (bool, error) {
+	boxes, err := readBoxes(r, trak.data, trak.data+trak.size)
+	if err != nil {
+		return false, err
+	}
+	mdia, ok := findBox(boxes, "mdia")
+	if !ok {
+		return false, nil
+	}
+	mdiaBoxes, err := readBoxes(r, mdia.data, mdia.data+mdia.size)
+	if err != nil {
+		return false, err
+	}
+
+	hdlr, ok := findBox(mdiaBoxes, "hdlr")
+	if !ok {
+		return false, nil
+	}
+	data, err := readPayload(r, hdlr)
+	if err != nil {
+		return false, err
+	}
+	if len(data) < 12 || string(data[8:12]) != "vide" {
+		return false, nil
+	}
+
+	if tkhd, ok := findBox(boxes, "tkhd"); ok {
+		data, err := readPayload(r, tkhd)
+		if err != nil {
+			return false, err
+		}
+		if err := trackSize(data, info); err != nil {
+			return false, fmt.Errorf("malformed tkhd box: %w", err)
+		}
+	}
+
+	var timescale, duration uint64
+	if mdhd, ok := findBox(mdiaBoxes, "mdhd"); ok {
+		data, err := readPayload(r, mdhd)
+		if err != nil {
+			return false, err
+		}
+		if timescale, duration, err = headerDuration(data, 12, 20); err != nil {
+			return false, fmt.Errorf("malformed mdhd box: %w", err)
+		}
+	}
+
+	stbl, err := children(r, mdia, "minf", "stbl")
+	if err != nil {
+		return false, err
+	}
+	if stsd, ok := findBox(stbl, "stsd"); ok {
+		data, err := readPayload(r, stsd)
+		if err != nil {
+			return false, err
+		}
+		if len(data) >= 16 {
+			fourcc := string(data[12:16])
+			info.Codec = fourcc
+			if name, ok := codecNames[fourcc]; ok {
+				info.Codec = name
+			}
+		}
+	}
+	if stts, ok := findBox(stbl, "stts"); ok && duration > 0 {
+		data, err := readPayload(r, stts)
+		if err != nil {
+			return false, err
+		}
+		samples := sampleCount(data)
+		info.FrameRate = float64(samples) * float64(timescale) / float64(duration)
+	}
+	return true, nil
+}
+
+// headerDuration reads the timescale and duration of an mvhd or mdhd box.
+// Version 0 boxes use 32-bit times and version 1 boxes 64-bit ones, which
+// moves the timescale from offset v0 to v1.
+func headerDuration(data []byte, v0 int, v1 int) (uint64, uint64, error) {
+	if len(data) < 1 {
+		return 0, 0, fmt.Errorf("box is empty")
+	}
+	if data[0] == 1 {
+		if len(data) < v1+12 {
+			return 0, 0, fmt.Errorf("box is truncated")
+		}
+		return uint64(binary.BigEndian.Uint32(data[v1 : v1+4])), binary.BigEndian.Uint64(data[v1+4 : v1+12]), nil
+	}
+	if len(data) < v0+8 {
+		return 0, 0, fmt.Errorf("box is truncated")
+	}
+	return uint64(binary.BigEndian.Uint32(data[v0 : v0+4])), uint64(binary.BigEndian.Uint32(data[v0+4 : v0+8])), nil
+}
+
+// trackSize reads the display size of a track, swapping width and height
+// when the transformation matrix rotates it by 90 or 270 degrees.
+func trackSize(data []byte, info *Info) error {
+	matrix := 40
+	if len(data) > 0 && data[0] == 1 {
+		matrix = 52
+	}
+	if len(data) < matrix+44 {
+		return fmt.Errorf("box is truncated")
+	}
+	a := int32(binary.BigEndian.Uint32(data[matrix : matrix+4]))
+	b := int32(binary.BigEndian.Uint32(data[matrix+4 : matrix+8]))
+	width := int(binary.BigEndian.Uint32(data[matrix+36:matrix+40]) >> 16)
+	height := int(binary.BigEndian.Uint32(data[matrix+40:matrix+44]) >> 16)
+	if a == 0 && b != 0 {
+		width, height = height, width
+	}
+	info.Width, info.Height = width, height
+	return nil
+}
+
+// sampleCount adds up the samples listed in an stts box.
+func sampleCount(data []byte) uint64 {
+	if len(data) < 8 {
+		return 0
+	}
+	entries := int(binary.BigEndian.Uint32(data[4:8]))
+	var total uint64
+	for idx := 0; idx < entries && 8+idx*8+8 <= len(data); idx++ {
+		total += uint64(binary.BigEndian.Uint32(data[8+idx*8 : 12+idx*8]))
+	}
+	return total
+}
+
+func scaledDuration(duration uint64, timescale uint64) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
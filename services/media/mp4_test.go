@@ -0,0 +1,136 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, typ...), body...)
+}
+
+func u32(values ...uint32) []byte {
+	out := []byte{}
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+// timeHeader is the payload of an mvhd or mdhd box.
+func timeHeader(version byte, timescale uint32, duration uint64) []byte {
+	if version == 1 {
+		out := append([]byte{1, 0, 0, 0}, make([]byte, 16)...)
+		out = binary.BigEndian.AppendUint32(out, timescale)
+		return binary.BigEndian.AppendUint64(out, duration)
+	}
+	return append(u32(0, 0, 0, timescale, uint32(duration)), make([]byte, 80)...)
+}
+
+// trackHeader is the payload of a version 0 tkhd box; rotated turns it by
+// 90 degrees.
+func trackHeader(width uint32, height uint32, rotated bool) []byte {
+	matrix := u32(0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000)
+	if rotated {
+		matrix = u32(0, 0x10000, 0, 0xFFFF0000, 0, 0, 0, 0, 0x40000000)
+	}
+	out := append(make([]byte, 40), matrix...)
+	return append(out, u32(width<<16, height<<16)...)
+}
+
+type testTrack struct {
+	handler   string
+	codec     string
+	width     uint32
+	height    uint32
+	rotated   bool
+	timescale uint32
+	duration  uint64
+	samples   uint32
+}
+
+func (tr testTrack) box(version byte) []byte {
+	stsd := append(u32(0, 1, 86), tr.codec...)
+	stts := u32(0, 2, tr.samples-1, 1000, 1, 1000)
+	return mp4Box("trak",
+		mp4Box("tkhd", trackHeader(tr.width, tr.height, tr.rotated)),
+		mp4Box("mdia",
+			mp4Box("mdhd", timeHeader(version, tr.timescale, tr.duration)),
+			mp4Box("hdlr", u32(0, 0), []byte(tr.handler), make([]byte, 12)),
+			mp4Box("minf", mp4Box("stbl", mp4Box("stsd", stsd), mp4Box("stts", stts))),
+		),
+	)
+}
+
+var (
+	video = testTrack{handler: "vide", codec: "avc1", width: 1920, height: 1080, timescale: 30000, duration: 300000, samples: 300}
+	audio = testTrack{handler: "soun", codec: "mp4a", timescale: 48000, duration: 480000, samples: 469}
+)
+
+func testMP4(version byte, tracks ...testTrack) []byte {
+	moov := [][]byte{mp4Box("mvhd", timeHeader(version, 1000, 10000))}
+	for _, tr := range tracks {
+		moov = append(moov, tr.box(version))
+	}
+	return append(mp4Box("ftyp", []byte("isom"), u32(0x200), []byte("isomavc1")), mp4Box("moov", moov...)...)
+}
+
+func TestInspectMP4(t *testing.T) {
+	hevc := video
+	hevc.codec = "hvc1"
+	rotated := video
+	rotated.rotated = true
+	unknown := video
+	unknown.codec = "xyz1"
+	slow := video
+	slow.samples = 240
+
+	largeMdat := append(u32(1), "mdat"...)
+	largeMdat = binary.BigEndian.AppendUint64(largeMdat, 16+4)
+	largeMdat = append(largeMdat, 1, 2, 3, 4)
+	openEnded := append(testMP4(0, video)[:24:24], append(u32(0), testMP4(0, video)[28:]...)...)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		want   Info
+		failed bool
+	}{
+		{"h264", testMP4(0, video), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "h264", FrameRate: 30}, false},
+		{"version 1 headers", testMP4(1, video), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "h264", FrameRate: 30}, false},
+		{"hevc", testMP4(0, hevc), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "hevc", FrameRate: 30}, false},
+		{"rotated", testMP4(0, rotated), Info{Width: 1080, Height: 1920, Duration: 10 * time.Second, Codec: "h264", FrameRate: 30}, false},
+		{"unknown codec", testMP4(0, unknown), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "xyz1", FrameRate: 30}, false},
+		{"frame rate", testMP4(0, slow), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "h264", FrameRate: 24}, false},
+		{"audio track first", testMP4(0, audio, video), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "h264", FrameRate: 30}, false},
+		{"64-bit box size", append(largeMdat, testMP4(0, video)...), Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "h264", FrameRate: 30}, false},
+		{"last box without size", openEnded, Info{Width: 1920, Height: 1080, Duration: 10 * time.Second, Codec: "h264", FrameRate: 30}, false},
+		{"no moov", mp4Box("ftyp", []byte("isom")), Info{}, true},
+		{"no video track", testMP4(0, audio), Info{}, true},
+		{"no mvhd", mp4Box("moov", video.box(0)), Info{}, true},
+		{"truncated mvhd", mp4Box("moov", mp4Box("mvhd", u32(0, 0))), Info{}, true},
+		{"box past the end", testMP4(0, video)[:60], Info{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var info Info
+			err := inspectMP4(bytes.NewReader(tt.data), int64(len(tt.data)), &info)
+			if tt.failed {
+				if err == nil {
+					t.Fatalf("inspectMP4() = %+v, want an error", info)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("inspectMP4() error = %v", err)
+			}
+			if info != tt.want {
+				t.Errorf("inspectMP4() = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"mime/multipart"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Fit decides how an image with an aspect ratio outside the platform's
+// range is brought into it.
+type Fit string
+
+const (
+	// FitPad adds white bars, keeping the whole image.
+	FitPad Fit = "pad"
+	// FitCrop cuts the image down around its center.
+	FitCrop Fit = "crop"
+)
+
+func ParseFit(value string) (Fit, error) {
+	switch Fit(value) {
+	case FitPad, FitCrop:
+		return Fit(value), nil
+	}
+	return "", fmt.Errorf("invalid normalize value %q, expected pad or crop", value)
+}
+
+type Transform string
+
+const (
+	TransformConverted Transform = "converted_to_jpeg"
+	TransformReencoded Transform = "reencoded"
+	TransformDownsized Transform = "downsized"
+	TransformPadded    Transform = "padded"
+	TransformCropped   Transform = "cropped"
+)
+
+// Normalization lists what was done to one file, by its index in the
+// files passed to NormalizeAll.
+type Normalization struct {
+	Index      int         `json:"index"`
+	Filename   string      `json:"filename"`
+	Transforms []Transform `json:"transforms"`
+}
+
+const (
+	jpegQuality = 90
+	// minImageSide stops downsizing before an image becomes useless.
+	minImageSide = 320
+	// maxDecodePixels is the largest image that is decoded, 200 MB as RGBA.
+	maxDecodePixels = 50000000
+)
+
+// jpegQualities are tried in order when a re-encoded JPEG is still over the
+// size limit, before the image is downsized.
+var jpegQualities = []int{jpegQuality, 80, 70, 60}
+
+// NormalizeAll rewrites the images that the platform would reject because
+// of their format, aspect ratio or size. Files that already fit, videos and
+// GIFs (which would lose their animation) are passed through unchanged. The
+// returned list only has entries for files that were changed.
+func NormalizeAll(files []*multipart.FileHeader, limits Limits, fit Fit) ([]*multipart.FileHeader, []Normalization, error) {
+	normalized := make([]*multipart.FileHeader, len(files))
+	changes := []Normalization{}
+	for idx, fh := range files {
+		out, transforms, err := Normalize(fh, limits, fit)
+		if err != nil {
+			return nil, nil, &ConstraintError{Filename: fh.Filename, Message: err.Error()}
+		}
+		normalized[idx] = out
+		if len(transforms) > 0 {
+			changes = append(changes, Normalization{Index: idx, Filename: fh.Filename, Transforms: transforms})
+		}
+	}
+	return normalized, changes, nil
+}
+
+// Normalize rewrites a single file, see NormalizeAll.
+func Normalize(fh *multipart.FileHeader, limits Limits, fit Fit) (*multipart.FileHeader, []Transform, error) {
+	info, err := Inspect(fh)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Kind != KindImage || info.MimeType == "image/gif" {
+		return fh, nil, nil
+	}
+
+	convert := !contains(limits.ImageTypes, info.MimeType)
+	if convert && !contains(limits.ImageTypes, "image/jpeg") {
+		return fh, nil, nil
+	}
+	ratio := info.AspectRatio()
+	reshape := limits.MinAspectRatio > 0 && ratio < limits.MinAspectRatio ||
+		limits.MaxAspectRatio > 0 && ratio > limits.MaxAspectRatio
+	shrink := limits.MaxImageBytes > 0 && info.Size > limits.MaxImageBytes
+	if !convert && !reshape && !shrink {
+		return fh, nil, nil
+	}
+
+	img, err := decodeImage(fh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transforms := []Transform{}
+	outputType := "image/jpeg"
+	if info.MimeType == "image/png" && !convert {
+		outputType = "image/png"
+	}
+	if outputType == "image/jpeg" && info.MimeType != "image/jpeg" {
+		transforms = append(transforms, TransformConverted)
+	}
+
+	if reshape {
+		if fit == FitCrop {
+			img = cropToRatio(img, limits.MinAspectRatio, limits.MaxAspectRatio)
+			transforms = append(transforms, TransformCropped)
+		} else {
+			img = padToRatio(img, limits.MinAspectRatio, limits.MaxAspectRatio)
+			transforms = append(transforms, TransformPadded)
+		}
+	}
+
+	data, downsized, err := encodeWithin(img, outputType, limits.MaxImageBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if downsized {
+		transforms = append(transforms, TransformDownsized)
+	} else if len(transforms) == 0 {
+		transforms = append(transforms, TransformReencoded)
+	}
+
+	filename := fh.Filename
+	if outputType == "image/jpeg" && info.MimeType != "image/jpeg" {
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"
+	}
+	out, err := NewFileHeader(filename, outputType, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, transforms, nil
+}
+
+func decodeImage(fh *multipart.FileHeader) (image.Image, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	return decodeBounded(f)
+}
+
+// decodeBounded decodes an image once its header shows it is within
+// maxDecodePixels, so that a small file that expands to a huge image can't
+// exhaust the memory.
+func decodeBounded(r io.ReadSeeker) (image.Image, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if pixels := int64(config.Width) * int64(config.Height); pixels > maxDecodePixels {
+		return nil, fmt.Errorf("image is %dx%d, at most %d megapixels can be processed", config.Width, config.Height, maxDecodePixels/1000000)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// encodeWithin encodes img, lowering the JPEG quality and then the
+// resolution until it fits in maxBytes. It reports whether the image had to
+// be downsized.
+func encodeWithin(img image.Image, mimeType string, maxBytes int64) ([]byte, bool, error) {
+	qualities := jpegQualities
+	if maxBytes == 0 {
+		qualities = qualities[:1]
+	}
+
+	downsized := false
+	for {
+		for _, quality := range qualities {
+			data, err := encode(img, mimeType, quality)
+			if err != nil {
+				return nil, false, err
+			}
+			if maxBytes == 0 || int64(len(data)) <= maxBytes {
+				return data, downsized, nil
+			}
+			if mimeType != "image/jpeg" {
+				break
+			}
+		}
+
+		bounds := img.Bounds()
+		width, height := bounds.Dx()*4/5, bounds.Dy()*4/5
+		if width < minImageSide || height < minImageSide {
+			return nil, false, fmt.Errorf("image can't be made smaller than %s", formatBytes(maxBytes))
+		}
+		img = resize(img, width, height)
+		downsized = true
+	}
+}
+
+func encode(img image.Image, mimeType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if mimeType == "image/png" {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+	} else {
+		// JPEG has no alpha channel, so transparent areas become white
+		// instead of black.
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func flatten(img image.Image) image.Image {
+	if _, ok := img.(*image.YCbCr); ok {
+		return img
+	}
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Over)
+	return out
+}
+
+func resize(img image.Image, width int, height int) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(out, out.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return out
+}
+
+// padToRatio centers img on a white canvas just large enough to bring its
+// aspect ratio within [minRatio, maxRatio].
+func padToRatio(img image.Image, minRatio float64, maxRatio float64) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	ratio := float64(width) / float64(height)
+	switch {
+	case minRatio > 0 && ratio < minRatio:
+		width = int(math.Ceil(float64(height) * minRatio))
+	case maxRatio > 0 && ratio > maxRatio:
+		height = int(math.Ceil(float64(width) / maxRatio))
+	default:
+		return img
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	offset := image.Pt((width-bounds.Dx())/2, (height-bounds.Dy())/2)
+	draw.Draw(out, bounds.Sub(bounds.Min).Add(offset), img, bounds.Min, draw.Over)
+	return out
+}
+
+// cropToRatio cuts the largest centered region out of img whose aspect
+// ratio is within [minRatio, maxRatio].
+func cropToRatio(img image.Image, minRatio float64, maxRatio float64) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	ratio := float64(width) / float64(height)
+	switch {
+	case minRatio > 0 && ratio < minRatio:
+		height = int(math.Floor(float64(width) / minRatio))
+	case maxRatio > 0 && ratio > maxRatio:
+		width = int(math.Floor(float64(height) * maxRatio))
+	default:
+		return img
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	origin := bounds.Min.Add(image.Pt((bounds.Dx()-width)/2, (bounds.Dy()-height)/2))
+	draw.Draw(out, out.Bounds(), img, origin, draw.Src)
+	return out
+}
@@ -0,0 +1,87 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// Source is a file that is read from somewhere other than the request, such
+// as an object in the bucket.
+type Source struct {
+	FileName    string
+	ContentType string
+	Open        func() (io.ReadCloser, error)
+}
+
+// SpoolFiles streams sources into multipart file headers, so that stored
+// media can go through the same code paths as uploaded files. Up to
+// maxMemory bytes are kept in memory; larger files are copied to temporary
+// files on disk as they are read, so memory use does not grow with the file
+// size. The returned form must be cleaned up with RemoveAll once the files
+// are no longer needed.
+func SpoolFiles(sources []Source, maxMemory int64) ([]*multipart.FileHeader, *multipart.Form, error) {
+	if len(sources) == 0 {
+		return nil, nil, nil
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeSources(writer, sources))
+	}()
+
+	form, err := multipart.NewReader(pr, writer.Boundary()).ReadForm(maxMemory)
+	// Unblock the writer if the form could not be read to the end.
+	pr.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to spool media: %w", err)
+	}
+	return form.File[formField], form, nil
+}
+
+func writeSources(writer *multipart.Writer, sources []Source) error {
+	for _, source := range sources {
+		if err := writeSource(writer, source); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeSource(writer *multipart.Writer, source Source) error {
+	r, err := source.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, formField, quoteEscaper.Replace(source.FileName)))
+	header.Set("Content-Type", source.ContentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("failed to create file part: %w", err)
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", source.FileName, err)
+	}
+	return nil
+}
+
+// DetachFiles copies uploaded files out of their request, whose temporary
+// files are removed as soon as the handler returns, so that they can be
+// used after the response is sent. The returned form must be cleaned up with
+// RemoveAll once the files are no longer needed.
+func DetachFiles(files []*multipart.FileHeader, maxMemory int64) ([]*multipart.FileHeader, *multipart.Form, error) {
+	sources := make([]Source, len(files))
+	for idx, fh := range files {
+		sources[idx] = Source{
+			FileName:    fh.Filename,
+			ContentType: fh.Header.Get("Content-Type"),
+			Open:        func() (io.ReadCloser, error) { return fh.Open() },
+		}
+	}
+	return SpoolFiles(sources, maxMemory)
+}
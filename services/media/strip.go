@@ -0,0 +1,502 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxStripBytes bounds the images that are read into memory to be
+	// stripped. It is the largest image any platform accepts, Mastodon's
+	// 16 MB; larger images are left for normalization to shrink.
+	maxStripBytes = 16 << 20
+	// maxHeaderBytes is how much of a large image is read for its EXIF data
+	// and ICC profile, which come before the image data.
+	maxHeaderBytes = 256 << 10
+)
+
+// StripAll removes EXIF, XMP and IPTC metadata from the JPEG, PNG and WebP
+// images in files, see Strip. Other files are passed through unchanged.
+func StripAll(files []*multipart.FileHeader) ([]*multipart.FileHeader, error) {
+	stripped := make([]*multipart.FileHeader, len(files))
+	for idx, fh := range files {
+		out, err := Strip(fh)
+		if err != nil {
+			return nil, &ConstraintError{Filename: fh.Filename, Message: err.Error()}
+		}
+		stripped[idx] = out
+	}
+	return stripped, nil
+}
+
+// Strip removes the metadata of a single image. Segments and chunks are
+// dropped without re-encoding, so the pixels are untouched, unless the EXIF
+// orientation is not the default. The rotation is then applied to the
+// pixels before the tag is dropped, so the image still displays upright.
+// Images over maxStripBytes are always encoded again, see stripLarge.
+func Strip(fh *multipart.FileHeader) (*multipart.FileHeader, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read media header: %w", err)
+	}
+	mimeType := http.DetectContentType(head[:n])
+	if mimeType != "image/jpeg" && mimeType != "image/png" && mimeType != "image/webp" {
+		return fh, nil
+	}
+	if fh.Size > maxStripBytes {
+		return stripLarge(fh, f, mimeType)
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, fh.Size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", fh.Filename, err)
+	}
+
+	var out []byte
+	orientation := 1
+	switch mimeType {
+	case "image/jpeg":
+		out, orientation, err = stripJPEG(data)
+	case "image/png":
+		out, orientation, err = stripPNG(data)
+	case "image/webp":
+		// WebP viewers ignore the EXIF orientation, so there is nothing to
+		// apply.
+		out, err = stripWebP(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if orientation > 1 && orientation <= 8 {
+		if out, err = reorient(out, mimeType, orientation); err != nil {
+			return nil, err
+		}
+	} else if len(out) == len(data) {
+		return fh, nil
+	}
+	return NewFileHeader(fh.Filename, mimeType, out)
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP13 (IPTC) and comment segments,
+// and every other application segment except JFIF, ICC color profiles and
+// the Adobe color transform, which decoders need to show the right colors.
+func stripJPEG(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, fmt.Errorf("invalid JPEG header")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	orientation := 1
+	pos := 2
+	for pos < len(data) {
+		if data[pos] != 0xFF {
+			return nil, 0, fmt.Errorf("malformed JPEG segment at offset %d", pos)
+		}
+		marker := pos + 1
+		for marker < len(data) && data[marker] == 0xFF {
+			marker++
+		}
+		if marker >= len(data) {
+			break
+		}
+		code := data[marker]
+
+		// Markers without a payload.
+		if code == 0x01 || (code >= 0xD0 && code <= 0xD7) {
+			out = append(out, 0xFF, code)
+			pos = marker + 1
+			continue
+		}
+		// The entropy coded data follows the start of scan; copy the rest.
+		if code == 0xDA || code == 0xD9 {
+			out = append(out, 0xFF)
+			out = append(out, data[marker:]...)
+			return out, orientation, nil
+		}
+
+		if marker+3 > len(data) {
+			return nil, 0, fmt.Errorf("truncated JPEG segment")
+		}
+		length := int(binary.BigEndian.Uint16(data[marker+1 : marker+3]))
+		end := marker + 1 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, fmt.Errorf("truncated JPEG segment")
+		}
+		payload := data[marker+3 : end]
+
+		if code == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			orientation = exifOrientation(payload[6:])
+		}
+		if keepJPEGSegment(code, payload) {
+			out = append(out, 0xFF)
+			out = append(out, data[marker:end]...)
+		}
+		pos = end
+	}
+	return out, orientation, nil
+}
+
+func keepJPEGSegment(code byte, payload []byte) bool {
+	switch {
+	case code == 0xE0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case code == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case code == 0xEE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case code >= 0xE1 && code <= 0xEF, code == 0xFE:
+		return false
+	}
+	return true
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripPNG drops the eXIf, text (where XMP lives) and timestamp chunks.
+func stripPNG(data []byte) ([]byte, int, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, 0, fmt.Errorf("invalid PNG header")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	orientation := 1
+	for pos := len(pngSignature); pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		typ := string(data[pos+4 : pos+8])
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, 0, fmt.Errorf("truncated PNG chunk %q", typ)
+		}
+		switch typ {
+		case "eXIf":
+			orientation = exifOrientation(data[pos+8 : pos+8+length])
+		case "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out, orientation, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the
+// VP8X header.
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("invalid WebP header")
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	for pos := 12; pos+8 <= len(data); {
+		typ := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size + size%2
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk %q", typ)
+		}
+		switch typ {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, data[pos:end]...)
+			if size > 0 {
+				out[start+8] &^= 0x08 | 0x04
+			}
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// exifOrientation reads the orientation tag from the first IFD of TIFF
+// formatted EXIF data. It returns 1, the default, when there is none.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for idx := 0; idx < entries; idx++ {
+		entry := ifd + 2 + idx*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8 : entry+10]))
+		}
+	}
+	return 1
+}
+
+// reorient decodes an image, applies the EXIF orientation to its pixels
+// and encodes it again in the same format. The encoders write no color
+// profile, so the ICC profile of the original is copied into the result.
+func reorient(data []byte, mimeType string, orientation int) ([]byte, error) {
+	img, err := decodeBounded(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img = applyOrientation(img, orientation)
+
+	var buf bytes.Buffer
+	if mimeType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	_, profile := imageHeader(data, mimeType)
+	return insertProfile(buf.Bytes(), mimeType, profile), nil
+}
+
+// stripLarge strips an image over maxStripBytes without reading the file
+// into memory. Only the head of the file is read for the orientation and
+// the ICC profile; the pixels are decoded within maxDecodePixels and
+// encoded again, which leaves every other piece of metadata behind. WebP
+// images become JPEGs, since there is no WebP encoder.
+func stripLarge(fh *multipart.FileHeader, f multipart.File, mimeType string) (*multipart.FileHeader, error) {
+	head := make([]byte, maxHeaderBytes)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read %s: %w", fh.Filename, err)
+	}
+	orientation, profile := imageHeader(head[:n], mimeType)
+
+	img, err := decodeBounded(io.NewSectionReader(f, 0, fh.Size))
+	if err != nil {
+		return nil, err
+	}
+	if orientation > 1 && orientation <= 8 {
+		img = applyOrientation(img, orientation)
+	}
+
+	outputType, filename := mimeType, fh.Filename
+	if mimeType == "image/webp" {
+		outputType = "image/jpeg"
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".jpg"
+	}
+	data, err := encode(img, outputType, 95)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileHeader(filename, outputType, insertProfile(data, outputType, profile))
+}
+
+// imageHeader reads the EXIF orientation and the ICC profile from the
+// segments or chunks in front of the image data. data may be cut off
+// anywhere; whatever is complete is read. The profile is returned as the
+// segments or chunk that hold it, ready for insertProfile.
+func imageHeader(data []byte, mimeType string) (int, []byte) {
+	orientation := 1
+	var profile []byte
+	switch mimeType {
+	case "image/jpeg":
+		for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+			marker := pos + 1
+			for marker < len(data) && data[marker] == 0xFF {
+				marker++
+			}
+			if marker+3 > len(data) {
+				break
+			}
+			code := data[marker]
+			if code == 0xDA || code == 0xD9 {
+				break
+			}
+			if code == 0x01 || (code >= 0xD0 && code <= 0xD7) {
+				pos = marker + 1
+				continue
+			}
+			end := marker + 1 + int(binary.BigEndian.Uint16(data[marker+1:marker+3]))
+			if end > len(data) {
+				break
+			}
+			payload := data[marker+3 : end]
+			switch {
+			case code == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+				orientation = exifOrientation(payload[6:])
+			case code == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+				profile = append(profile, 0xFF)
+				profile = append(profile, data[marker:end]...)
+			}
+			pos = end
+		}
+	case "image/png":
+		for pos := len(pngSignature); pos+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+			typ := string(data[pos+4 : pos+8])
+			end := pos + 12 + length
+			if typ == "IDAT" || length < 0 || end > len(data) {
+				break
+			}
+			switch typ {
+			case "eXIf":
+				orientation = exifOrientation(data[pos+8 : pos+8+length])
+			case "iCCP":
+				profile = data[pos:end]
+			}
+			pos = end
+		}
+	}
+	return orientation, profile
+}
+
+// insertProfile puts profile, from imageHeader, into an encoded image: the
+// APP2 segments right after the start of image of a JPEG, or the iCCP chunk
+// right after the IHDR chunk of a PNG, where it has to come before any
+// image data.
+func insertProfile(data []byte, mimeType string, profile []byte) []byte {
+	at := 2
+	if mimeType == "image/png" {
+		// IHDR is always the first chunk and 13 bytes long.
+		at = len(pngSignature) + 12 + 13
+	}
+	if len(profile) == 0 || len(data) < at {
+		return data
+	}
+
+	out := make([]byte, 0, len(data)+len(profile))
+	out = append(out, data[:at]...)
+	out = append(out, profile...)
+	return append(out, data[at:]...)
+}
+
+// applyOrientation moves every pixel to where EXIF orientation 2-8 says it
+// should be displayed: mirrored, rotated by 180 degrees, or transposed and
+// rotated by 90 or 270 degrees. The pixel buffers are copied directly, so
+// JPEGs stay in YCbCr and PNGs keep their color model; other images are
+// converted to NRGBA first.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	outRect := image.Rect(0, 0, width, height)
+	if orientation >= 5 {
+		outRect = image.Rect(0, 0, height, width)
+	}
+
+	switch src := img.(type) {
+	case *image.YCbCr:
+		ratio, ok := orientedRatio(src.SubsampleRatio, orientation)
+		if !ok {
+			break
+		}
+		out := image.NewYCbCr(outRect, ratio)
+		orientPixels(out.Y, out.YStride, src.Y[src.YOffset(bounds.Min.X, bounds.Min.Y):], src.YStride, width, height, 1, orientation)
+		chromaWidth, chromaHeight := width, height
+		if src.SubsampleRatio == image.YCbCrSubsampleRatio420 || src.SubsampleRatio == image.YCbCrSubsampleRatio422 {
+			chromaWidth = (width + 1) / 2
+		}
+		if src.SubsampleRatio == image.YCbCrSubsampleRatio420 || src.SubsampleRatio == image.YCbCrSubsampleRatio440 {
+			chromaHeight = (height + 1) / 2
+		}
+		offset := src.COffset(bounds.Min.X, bounds.Min.Y)
+		orientPixels(out.Cb, out.CStride, src.Cb[offset:], src.CStride, chromaWidth, chromaHeight, 1, orientation)
+		orientPixels(out.Cr, out.CStride, src.Cr[offset:], src.CStride, chromaWidth, chromaHeight, 1, orientation)
+		return out
+	case *image.Gray:
+		out := image.NewGray(outRect)
+		orientPixels(out.Pix, out.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, width, height, 1, orientation)
+		return out
+	case *image.RGBA:
+		out := image.NewRGBA(outRect)
+		orientPixels(out.Pix, out.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, width, height, 4, orientation)
+		return out
+	case *image.NRGBA:
+		out := image.NewNRGBA(outRect)
+		orientPixels(out.Pix, out.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, width, height, 4, orientation)
+		return out
+	}
+
+	converted := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(converted, converted.Bounds(), img, bounds.Min, draw.Src)
+	return applyOrientation(converted, orientation)
+}
+
+// orientedRatio is the chroma subsampling of a YCbCr image once it is
+// oriented: transposing swaps horizontal and vertical subsampling.
+func orientedRatio(ratio image.YCbCrSubsampleRatio, orientation int) (image.YCbCrSubsampleRatio, bool) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio420:
+		return ratio, true
+	case image.YCbCrSubsampleRatio422:
+		if orientation >= 5 {
+			return image.YCbCrSubsampleRatio440, true
+		}
+		return ratio, true
+	case image.YCbCrSubsampleRatio440:
+		if orientation >= 5 {
+			return image.YCbCrSubsampleRatio422, true
+		}
+		return ratio, true
+	}
+	return 0, false
+}
+
+// orientPixels copies a width by height plane of size byte pixels from src
+// to where the orientation puts them in dst.
+func orientPixels(dst []byte, dstStride int, src []byte, srcStride int, width int, height int, size int, orientation int) {
+	for y := 0; y < height; y++ {
+		row := src[y*srcStride:]
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst[dy*dstStride+dx*size:dy*dstStride+dx*size+size], row[x*size:x*size+size])
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"testing"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// testImage is 4x2 pixels with a red left column, so that rotations can be
+// told apart.
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 4 {
+			img.Set(x, y, blue)
+			if x == 0 {
+				img.Set(x, y, red)
+			}
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exifTIFF is TIFF data whose first IFD holds only the orientation tag.
+func exifTIFF(order binary.AppendByteOrder, orientation uint16) []byte {
+	tiff := []byte("MM")
+	if order == binary.LittleEndian {
+		tiff = []byte("II")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	return order.AppendUint32(tiff, 0)
+}
+
+func jpegSegment(code byte, payload string) []byte {
+	segment := []byte{0xFF, code}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(payload)))
+	return append(segment, payload...)
+}
+
+// withSegments puts segments right after the start of image of a JPEG.
+func withSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+func pngChunk(typ string, payload string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// withChunks puts chunks right after the IHDR chunk of a PNG.
+func withChunks(data []byte, chunks ...[]byte) []byte {
+	at := len(pngSignature) + 12 + 13
+	out := append([]byte{}, data[:at]...)
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return append(out, data[at:]...)
+}
+
+func webpChunk(typ string, payload string) []byte {
+	chunk := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := append([]byte("WEBP"), bytes.Join(chunks, nil)...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+const (
+	iccPayload  = "ICC_PROFILE\x00\x01\x01fake profile"
+	xmpPayload  = "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"
+	iptcPayload = "Photoshop 3.0\x00IPTC"
+)
+
+func TestStripJPEG(t *testing.T) {
+	base := encodeJPEG(t, testImage())
+	exif := "Exif\x00\x00" + string(exifTIFF(binary.BigEndian, 6))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		orientation int
+		kept        []string
+		dropped     []string
+	}{
+		{"no metadata", base, 1, nil, nil},
+		{
+			name:        "exif and xmp",
+			data:        withSegments(base, jpegSegment(0xE1, exif), jpegSegment(0xE1, xmpPayload)),
+			orientation: 6,
+			dropped:     []string{"Exif", "xmpmeta"},
+		},
+		{
+			name:        "little endian exif",
+			data:        withSegments(base, jpegSegment(0xE1, "Exif\x00\x00"+string(exifTIFF(binary.LittleEndian, 3)))),
+			orientation: 3,
+			dropped:     []string{"Exif"},
+		},
+		{
+			name:        "iptc and comment",
+			data:        withSegments(base, jpegSegment(0xED, iptcPayload), jpegSegment(0xFE, "shot on my phone")),
+			orientation: 1,
+			dropped:     []string{"Photoshop", "my phone"},
+		},
+		{
+			name:        "color data is kept",
+			data:        withSegments(base, jpegSegment(0xE0, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"), jpegSegment(0xE2, iccPayload), jpegSegment(0xEE, "Adobe\x00\x64"), jpegSegment(0xE1, exif)),
+			orientation: 6,
+			kept:        []string{"JFIF", "fake profile", "Adobe"},
+			dropped:     []string{"Exif"},
+		},
+		{
+			name:        "other application segments",
+			data:        withSegments(base, jpegSegment(0xE0, "AVI1"), jpegSegment(0xE9, "vendor data")),
+			orientation: 1,
+			dropped:     []string{"AVI1", "vendor data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, orientation, err := stripJPEG(tt.data)
+			if err != nil {
+				t.Fatalf("stripJPEG() error = %v", err)
+			}
+			if orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", orientation, tt.orientation)
+			}
+			for _, s := range tt.kept {
+				if !bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q was dropped", s)
+				}
+			}
+			for _, s := range tt.dropped {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q was kept", s)
+				}
+			}
+			if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("stripped JPEG does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripJPEGInvalid(t *testing.T) {
+	base := encodeJPEG(t, testImage())
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not a jpeg", []byte("GIF89a......")},
+		{"truncated segment", append(append([]byte{}, base[:2]...), 0xFF, 0xE1, 0x10, 0x00, 'E')},
+		{"garbage between segments", append(append([]byte{}, base[:2]...), 0x00, 0x01)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := stripJPEG(tt.data); err == nil {
+				t.Errorf("stripJPEG() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	base := encodePNG(t, testImage())
+	tests := []struct {
+		name        string
+		data        []byte
+		orientation int
+		kept        []string
+		dropped     []string
+	}{
+		{"no metadata", base, 1, nil, nil},
+		{
+			name:        "text chunks",
+			data:        withChunks(base, pngChunk("tEXt", "Author\x00me"), pngChunk("iTXt", "XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"), pngChunk("tIME", "\x07\xe9\x01\x01\x00\x00\x00")),
+			orientation: 1,
+			dropped:     []string{"Author", "xmpmeta", "tIME"},
+		},
+		{
+			name:        "exif",
+			data:        withChunks(base, pngChunk("eXIf", string(exifTIFF(binary.BigEndian, 8)))),
+			orientation: 8,
+			dropped:     []string{"eXIf"},
+		},
+		{
+			name:        "color profile is kept",
+			data:        withChunks(base, pngChunk("iCCP", "icc\x00\x00fake profile"), pngChunk("zTXt", "Comment\x00\x00x")),
+			orientation: 1,
+			kept:        []string{"iCCP"},
+			dropped:     []string{"zTXt"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, orientation, err := stripPNG(tt.data)
+			if err != nil {
+				t.Fatalf("stripPNG() error = %v", err)
+			}
+			if orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", orientation, tt.orientation)
+			}
+			for _, s := range tt.kept {
+				if !bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q was dropped", s)
+				}
+			}
+			for _, s := range tt.dropped {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("%q was kept", s)
+				}
+			}
+			if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+				t.Errorf("stripped PNG does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripWebP(t *testing.T) {
+	// VP8X with the EXIF (0x08) and XMP (0x04) flags set.
+	vp8x := webpChunk("VP8X", "\x0c\x00\x00\x00\x03\x00\x00\x01\x00\x00")
+	pixels := webpChunk("VP8L", "pixels")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		invalid bool
+	}{
+		{"no metadata", webpFile(pixels), webpFile(pixels), false},
+		{
+			name: "exif and xmp",
+			data: webpFile(vp8x, pixels, webpChunk("EXIF", "Exif\x00\x00MM"), webpChunk("XMP ", "<x:xmpmeta/>")),
+			want: webpFile(webpChunk("VP8X", "\x00\x00\x00\x00\x03\x00\x00\x01\x00\x00"), pixels),
+		},
+		{"odd chunk size is padded", webpFile(pixels, webpChunk("EXIF", "odd")), webpFile(pixels), false},
+		{"not a webp", []byte("RIFF\x00\x00\x00\x00WAVE"), nil, true},
+		{"truncated chunk", webpFile(pixels)[:20], nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := stripWebP(tt.data)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("stripWebP() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stripWebP() error = %v", err)
+			}
+			if !bytes.Equal(out, tt.want) {
+				t.Errorf("stripWebP() = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"big endian", exifTIFF(binary.BigEndian, 6), 6},
+		{"little endian", exifTIFF(binary.LittleEndian, 8), 8},
+		{"empty", nil, 1},
+		{"bad byte order", append([]byte("XX"), exifTIFF(binary.BigEndian, 6)[2:]...), 1},
+		{"ifd out of range", append(exifTIFF(binary.BigEndian, 6)[:4], 0, 0, 1, 0), 1},
+		{"truncated entry", exifTIFF(binary.BigEndian, 6)[:16], 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.tiff); got != tt.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageHeader(t *testing.T) {
+	jpegData := withSegments(encodeJPEG(t, testImage()),
+		jpegSegment(0xE1, "Exif\x00\x00"+string(exifTIFF(binary.BigEndian, 6))),
+		jpegSegment(0xE2, iccPayload),
+	)
+	iccp := pngChunk("iCCP", "icc\x00\x00fake profile")
+	pngData := withChunks(encodePNG(t, testImage()), pngChunk("eXIf", string(exifTIFF(binary.BigEndian, 3))), iccp)
+	exifEnd := 2 + 4 + len("Exif\x00\x00") + len(exifTIFF(binary.BigEndian, 6))
+
+	tests := []struct {
+		name        string
+		data        []byte
+		mimeType    string
+		orientation int
+		profile     []byte
+	}{
+		{"jpeg", jpegData, "image/jpeg", 6, jpegSegment(0xE2, iccPayload)},
+		{"jpeg cut in the profile", jpegData[:exifEnd+8], "image/jpeg", 6, nil},
+		{"jpeg cut in the exif", jpegData[:10], "image/jpeg", 1, nil},
+		{"png", pngData, "image/png", 3, iccp},
+		{"png cut", pngData[:len(pngSignature)+12+13+4], "image/png", 1, nil},
+		{"webp", webpFile(webpChunk("VP8L", "pixels")), "image/webp", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orientation, profile := imageHeader(tt.data, tt.mimeType)
+			if orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", orientation, tt.orientation)
+			}
+			if !bytes.Equal(profile, tt.profile) {
+				t.Errorf("profile = %q, want %q", profile, tt.profile)
+			}
+		})
+	}
+}
+
+func stripFile(t *testing.T, name string, mimeType string, data []byte) (*multipart.FileHeader, *multipart.FileHeader) {
+	t.Helper()
+	fh, err := NewFileHeader(name, mimeType, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Strip(fh)
+	if err != nil {
+		t.Fatalf("Strip() error = %v", err)
+	}
+	return fh, out
+}
+
+func readFile(t *testing.T, fh *multipart.FileHeader) []byte {
+	t.Helper()
+	f, err := fh.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStrip(t *testing.T) {
+	plainJPEG := encodeJPEG(t, testImage())
+	plainPNG := encodePNG(t, testImage())
+	rotate := func(orientation uint16) []byte {
+		return pngChunk("eXIf", string(exifTIFF(binary.BigEndian, orientation)))
+	}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		mimeType  string
+		unchanged bool
+		width     int
+		height    int
+		// topLeft is the color expected in the top left corner.
+		topLeft color.Color
+	}{
+		{"plain jpeg is passed through", plainJPEG, "image/jpeg", true, 4, 2, nil},
+		{"plain png is passed through", plainPNG, "image/png", true, 4, 2, nil},
+		{"not an image", []byte("just some text"), "text/plain", true, 0, 0, nil},
+		{"metadata is dropped", withChunks(plainPNG, pngChunk("tEXt", "GPS\x001,2")), "image/png", false, 4, 2, red},
+		{"upright exif", withChunks(plainPNG, rotate(1)), "image/png", false, 4, 2, red},
+		{"rotated 90 degrees", withChunks(plainPNG, rotate(6)), "image/png", false, 2, 4, red},
+		{"rotated 180 degrees", withChunks(plainPNG, rotate(3)), "image/png", false, 4, 2, blue},
+		{"rotated 270 degrees", withChunks(plainPNG, rotate(8)), "image/png", false, 2, 4, blue},
+		{"mirrored", withChunks(plainPNG, rotate(2)), "image/png", false, 4, 2, blue},
+		{"rotated jpeg", withSegments(plainJPEG, jpegSegment(0xE1, "Exif\x00\x00"+string(exifTIFF(binary.BigEndian, 6)))), "image/jpeg", false, 2, 4, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fh, out := stripFile(t, "photo", tt.mimeType, tt.data)
+			if tt.unchanged {
+				if out != fh {
+					t.Errorf("Strip() returned a new file, want the original")
+				}
+				return
+			}
+			if out == fh {
+				t.Fatalf("Strip() returned the original file")
+			}
+
+			data := readFile(t, out)
+			if bytes.Contains(data, []byte("eXIf")) || bytes.Contains(data, []byte("Exif")) || bytes.Contains(data, []byte("GPS")) {
+				t.Errorf("metadata was kept")
+			}
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("stripped image does not decode: %v", err)
+			}
+			if size := img.Bounds().Size(); size.X != tt.width || size.Y != tt.height {
+				t.Errorf("size = %v, want %dx%d", size, tt.width, tt.height)
+			}
+			if tt.topLeft != nil {
+				r, g, b, a := img.At(0, 0).RGBA()
+				wr, wg, wb, wa := tt.topLeft.RGBA()
+				if r != wr || g != wg || b != wb || a != wa {
+					t.Errorf("top left = %v, want %v", img.At(0, 0), tt.topLeft)
+				}
+			}
+		})
+	}
+}
+
+func TestStripLarge(t *testing.T) {
+	data := withSegments(encodeJPEG(t, testImage()),
+		jpegSegment(0xE1, "Exif\x00\x00"+string(exifTIFF(binary.BigEndian, 6))),
+		jpegSegment(0xE1, xmpPayload),
+		jpegSegment(0xE2, iccPayload),
+	)
+	fh, err := NewFileHeader("photo.jpg", "image/jpeg", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	out, err := stripLarge(fh, f, "image/jpeg")
+	if err != nil {
+		t.Fatalf("stripLarge() error = %v", err)
+	}
+	stripped := readFile(t, out)
+	if bytes.Contains(stripped, []byte("Exif")) || bytes.Contains(stripped, []byte("xmpmeta")) {
+		t.Errorf("metadata was kept")
+	}
+	if !bytes.HasPrefix(stripped[2:], jpegSegment(0xE2, iccPayload)) {
+		t.Errorf("color profile does not follow the start of image")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatalf("stripped image does not decode: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 2 || size.Y != 4 {
+		t.Errorf("size = %v, want 2x4", size)
+	}
+}
+
+func TestInsertProfile(t *testing.T) {
+	iccp := pngChunk("iCCP", "icc\x00\x00fake profile")
+	pngData := encodePNG(t, testImage())
+
+	out := insertProfile(pngData, "image/png", iccp)
+	at := len(pngSignature) + 12 + 13
+	if !bytes.Equal(out[at:at+len(iccp)], iccp) {
+		t.Errorf("iCCP chunk does not follow IHDR")
+	}
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("PNG with profile does not decode: %v", err)
+	}
+	if got := insertProfile(pngData, "image/png", nil); !bytes.Equal(got, pngData) {
+		t.Errorf("insertProfile() without a profile changed the image")
+	}
+}
@@ -0,0 +1,117 @@
+package post
+
+import (
+	"backend/models"
+	repo_post "backend/repositories/post"
+	"backend/services/publisher"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type PostService interface {
+	Publish(ctx context.Context, userID string, mediaCount int, targets []publisher.Target) []publisher.Outcome
+	Record(userID string, mediaCount int, targets []publisher.Target) ([]*models.PostDelivery, error)
+	Deliver(ctx context.Context, delivery *models.PostDelivery, target publisher.Target) publisher.Outcome
+	ListDeliveries(userID string, filter repo_post.DeliveryFilter) ([]models.PostDelivery, int, error)
+}
+
+type postServiceImpl struct {
+	repo_post repo_post.PostRepository
+	registry  *publisher.Registry
+}
+
+func NewPostService(repoPost repo_post.PostRepository, registry *publisher.Registry) PostService {
+	return &postServiceImpl{
+		repo_post: repoPost,
+		registry:  registry,
+	}
+}
+
+// Publish records the post and one delivery per target before publishing,
+// then stores the platform ID and permalink (or the error) of each target.
+// A thread that failed halfway keeps the ID and permalink of its first
+// tweet, so the published part can still be found.
+// Nothing is published if the post cannot be recorded, so every live post
+// has a row in the history. The outcomes are recorded even when ctx is
+// cancelled halfway through.
+func (s *postServiceImpl) Publish(ctx context.Context, userID string, mediaCount int, targets []publisher.Target) []publisher.Outcome {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	deliveries, err := s.Record(userID, mediaCount, targets)
+	if err != nil {
+		log.Printf("[POST_SERVICE] --- %v", err)
+		outcomes := make([]publisher.Outcome, len(targets))
+		for idx, target := range targets {
+			outcomes[idx] = publisher.Outcome{Platform: target.Platform, Err: err}
+		}
+		return outcomes
+	}
+
+	outcomes := s.registry.PublishAll(ctx, targets)
+	for idx, outcome := range outcomes {
+		s.store(deliveries[idx], outcome)
+	}
+	return outcomes
+}
+
+// Deliver publishes a single target of a post recorded with Record and
+// stores its outcome, so that the targets of one post can be published as
+// separate jobs.
+func (s *postServiceImpl) Deliver(ctx context.Context, delivery *models.PostDelivery, target publisher.Target) publisher.Outcome {
+	outcome := s.registry.PublishAll(ctx, []publisher.Target{target})[0]
+	s.store(delivery, outcome)
+	return outcome
+}
+
+func (s *postServiceImpl) store(delivery *models.PostDelivery, outcome publisher.Outcome) {
+	var err error
+	var partialErr *publisher.PartialError
+	if errors.As(outcome.Err, &partialErr) && len(partialErr.Published) > 0 {
+		first := partialErr.Published[0]
+		err = s.repo_post.PartialDelivery(delivery.ID, first.RemoteID, first.Permalink, outcome.Err.Error(), time.Now())
+	} else if outcome.Err != nil {
+		err = s.repo_post.FailDelivery(delivery.ID, outcome.Err.Error())
+	} else {
+		err = s.repo_post.CompleteDelivery(delivery.ID, outcome.Result.RemoteID, outcome.Result.Permalink, time.Now())
+	}
+	if err != nil {
+		log.Printf("[POST_SERVICE] --- %v", err)
+	}
+}
+
+// Record records the post and one delivery per target, in the order of
+// targets. Nothing may be published if it fails.
+func (s *postServiceImpl) Record(userID string, mediaCount int, targets []publisher.Target) ([]*models.PostDelivery, error) {
+	post, err := s.repo_post.CreatePost(&models.Post{
+		UserID:     userID,
+		MediaCount: mediaCount,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record post: %w", err)
+	}
+
+	deliveries := make([]*models.PostDelivery, len(targets))
+	for idx, target := range targets {
+		delivery, err := s.repo_post.CreateDelivery(&models.PostDelivery{
+			PostID:       post.ID,
+			UserID:       userID,
+			Platform:     target.Platform,
+			Status:       models.DeliveryStatusPublishing,
+			PlatformData: target.Request.PlatformData,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to record post: %w", err)
+		}
+		deliveries[idx] = delivery
+	}
+	return deliveries, nil
+}
+
+func (s *postServiceImpl) ListDeliveries(userID string, filter repo_post.DeliveryFilter) ([]models.PostDelivery, int, error) {
+	return s.repo_post.ListDeliveries(userID, filter)
+}
@@ -0,0 +1,211 @@
+package progress
+
+import (
+	"backend/repositories/provider"
+	"backend/services/publisher"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// retention is how long a finished job can still be streamed, so a
+	// client that connects late or reconnects still gets the outcome.
+	retention     = 15 * time.Minute
+	sweepInterval = time.Minute
+)
+
+// Event is one entry of a job's progress stream. Seq numbers the events of
+// a job from 1, so a client that reconnects can skip what it has seen. The
+// last event is done, with the Result, or failed, with the Error and the
+// parts that were published before it. A failure reported by the platform
+// also carries its class and, when known, when to try again.
+type Event struct {
+	Seq int `json:"seq"`
+	publisher.Progress
+	Result     *publisher.Result `json:"result,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass provider.Class    `json:"error_class,omitempty"`
+	RetryAt    *time.Time        `json:"retry_at,omitempty"`
+	Published  []publisher.Part  `json:"published,omitempty"`
+}
+
+// Job is a publish running in the background.
+type Job struct {
+	ID       string
+	UserID   string
+	Platform string
+
+	mu         sync.Mutex
+	events     []Event
+	changed    chan struct{}
+	finishedAt time.Time
+	cancel     context.CancelFunc
+}
+
+// Events returns the events after seq and whether the job has finished.
+// The returned channel is closed when the next event is added.
+func (j *Job) Events(after int) ([]Event, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var events []Event
+	if after < len(j.events) {
+		events = append(events, j.events[max(after, 0):]...)
+	}
+	return events, !j.finishedAt.IsZero(), j.changed
+}
+
+func (j *Job) add(event Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Reports that race with the end of the publish are dropped, so the
+	// terminal event is always the last one.
+	if !j.finishedAt.IsZero() {
+		return
+	}
+	event.Seq = len(j.events) + 1
+	j.events = append(j.events, event)
+	if event.Stage == publisher.StageDone || event.Stage == publisher.StageFailed {
+		j.finishedAt = time.Now()
+	}
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+func (j *Job) report(progress publisher.Progress) {
+	if progress.Platform == "" {
+		progress.Platform = j.Platform
+	}
+	j.add(Event{Progress: progress})
+}
+
+func (j *Job) finish(result *publisher.Result, err error) {
+	if err != nil {
+		event := Event{
+			Progress: publisher.Progress{Platform: j.Platform, Stage: publisher.StageFailed},
+			Error:    err.Error(),
+		}
+		var partialErr *publisher.PartialError
+		if errors.As(err, &partialErr) {
+			event.Published = partialErr.Published
+		}
+		if providerErr, ok := provider.As(err); ok {
+			event.ErrorClass = providerErr.Class
+			if !providerErr.RetryAt.IsZero() {
+				event.RetryAt = &providerErr.RetryAt
+			}
+		}
+		j.add(event)
+		return
+	}
+	j.add(Event{
+		Progress: publisher.Progress{Platform: j.Platform, Stage: publisher.StageDone},
+		Result:   result,
+	})
+}
+
+// Tracker runs publishes in the background and keeps their progress in
+// memory, so a client can follow them from any request served by this
+// process.
+type Tracker struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{jobs: map[string]*Job{}}
+}
+
+// Start runs publish as a new job and returns without waiting for it.
+// publish gets a context that carries the values of ctx but outlives it;
+// it is cancelled when the tracker stops, and reports its progress to the
+// job.
+func (t *Tracker) Start(ctx context.Context, userID string, platform string, publish func(ctx context.Context) (*publisher.Result, error)) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+
+	job := &Job{
+		ID:       hex.EncodeToString(id),
+		UserID:   userID,
+		Platform: platform,
+		changed:  make(chan struct{}),
+	}
+	ctx, job.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	ctx = publisher.WithProgress(ctx, job.report)
+
+	t.mu.Lock()
+	t.jobs[job.ID] = job
+	t.mu.Unlock()
+
+	job.report(publisher.Progress{Stage: publisher.StageQueued})
+	go func() {
+		defer job.cancel()
+		result, err := publish(ctx)
+		job.finish(result, err)
+	}()
+	return job, nil
+}
+
+// Get returns a job of the user that is running or finished recently.
+func (t *Tracker) Get(userID string, jobID string) (*Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job, ok := t.jobs[jobID]
+	if !ok || job.UserID != userID {
+		return nil, false
+	}
+	return job, true
+}
+
+// Run forgets finished jobs once they are past retention until ctx is
+// cancelled. It then cancels the jobs that are still running.
+func (t *Tracker) Run(ctx context.Context) {
+	log.Println("[PROGRESS_TRACKER] --- Worker started")
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.cancelAll()
+			log.Println("[PROGRESS_TRACKER] --- Worker stopped")
+			return
+		case <-ticker.C:
+			t.sweep(time.Now().Add(-retention))
+		}
+	}
+}
+
+func (t *Tracker) sweep(before time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, job := range t.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && job.finishedAt.Before(before)
+		job.mu.Unlock()
+		if expired {
+			delete(t.jobs, id)
+		}
+	}
+}
+
+func (t *Tracker) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, job := range t.jobs {
+		job.cancel()
+	}
+}
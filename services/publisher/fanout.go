@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"backend/repositories/ratelimit"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Target is one platform a post is sent to as part of a fan-out.
+type Target struct {
+	Platform string
+	Request  *Request
+}
+
+// Outcome is the result of publishing to a single Target. Exactly one of
+// Result and Err is set.
+type Outcome struct {
+	Platform string
+	Result   *Result
+	Err      error
+}
+
+// PublishAll publishes every target concurrently and waits for all of them.
+// A failing target does not stop the others; outcomes are returned in the
+// same order as targets. Cancelling ctx stops the targets that are still
+// in flight.
+func (r *Registry) PublishAll(ctx context.Context, targets []Target) []Outcome {
+	outcomes := make([]Outcome, len(targets))
+
+	var wg sync.WaitGroup
+	for idx, target := range targets {
+		wg.Add(1)
+		go func(idx int, target Target) {
+			defer wg.Done()
+			outcomes[idx] = r.publishOne(ctx, target)
+		}(idx, target)
+	}
+	wg.Wait()
+
+	return outcomes
+}
+
+func (r *Registry) publishOne(ctx context.Context, target Target) (outcome Outcome) {
+	outcome.Platform = target.Platform
+
+	// A panic in one platform must not take the other goroutines down with it.
+	defer func() {
+		if rec := recover(); rec != nil {
+			outcome.Result = nil
+			outcome.Err = fmt.Errorf("publishing to %s panicked: %v", target.Platform, rec)
+		}
+	}()
+
+	p, ok := r.Get(target.Platform)
+	if !ok {
+		outcome.Err = Invalid("Unsupported platform: %s", target.Platform)
+		return outcome
+	}
+
+	if err := CheckRateLimit(p, target.Request); err != nil {
+		outcome.Err = err
+		return outcome
+	}
+
+	// The platform calls are counted against the user's rate limits.
+	ctx = ratelimit.WithAccount(ctx, target.Request.UserID)
+
+	// Progress is tagged with the platform, so the targets of a fan-out can
+	// share one reporter.
+	parent := ctx
+	ctx = WithProgress(ctx, func(progress Progress) {
+		progress.Platform = target.Platform
+		ReportProgress(parent, progress)
+	})
+	outcome.Result, outcome.Err = p.Publish(ctx, target.Request)
+	return outcome
+}
@@ -0,0 +1,57 @@
+package publisher
+
+import "context"
+
+// Stage is a step of a publish, as reported to the client.
+type Stage string
+
+const (
+	StageQueued     Stage = "queued"
+	StageUploading  Stage = "uploading"
+	StageProcessing Stage = "processing"
+	StagePublishing Stage = "publishing"
+	StageDone       Stage = "done"
+	StageFailed     Stage = "failed"
+)
+
+// Progress is a stage transition of a publish in flight. While uploading,
+// Current and Total count the files; while processing, Percent is how far
+// the platform says it got, when it says so.
+type Progress struct {
+	Platform string `json:"platform,omitempty"`
+	Stage    Stage  `json:"stage"`
+	Current  int    `json:"current,omitempty"`
+	Total    int    `json:"total,omitempty"`
+	Percent  int    `json:"percent,omitempty"`
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context whose publishes send their progress to
+// report. report may be called from several goroutines at once.
+func WithProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// ReportProgress sends progress to the reporter of ctx, if there is one.
+func ReportProgress(ctx context.Context, progress Progress) {
+	if report, ok := ctx.Value(progressKey{}).(func(Progress)); ok {
+		report(progress)
+	}
+}
+
+// Uploading reports that current of total files have been uploaded.
+func Uploading(ctx context.Context, current int, total int) {
+	ReportProgress(ctx, Progress{Stage: StageUploading, Current: current, Total: total})
+}
+
+// Processing reports that the platform is processing the uploaded media.
+// percent is 0 when the platform does not tell.
+func Processing(ctx context.Context, percent int) {
+	ReportProgress(ctx, Progress{Stage: StageProcessing, Percent: percent})
+}
+
+// Publishing reports that the media is ready and the post is being created.
+func Publishing(ctx context.Context) {
+	ReportProgress(ctx, Progress{Stage: StagePublishing})
+}
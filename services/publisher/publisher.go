@@ -0,0 +1,271 @@
+package publisher
+
+import (
+	"backend/services/media"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ErrNotLinked is returned by Publish when the user has no usable
+// credentials for the platform.
+var ErrNotLinked = errors.New("account not linked or tokens are missing")
+
+// ValidationError reports a problem with the post itself, as opposed to a
+// failure talking to the platform.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func Invalid(format string, args ...any) error {
+	return &ValidationError{Message: fmt.Sprintf(format, args...)}
+}
+
+// PartialError is returned when a post made of several parts, such as a
+// thread, failed after some of its parts were already published.
+type PartialError struct {
+	Published []Part
+	Err       error
+}
+
+func (e *PartialError) Error() string {
+	ids := make([]string, len(e.Published))
+	for idx, part := range e.Published {
+		ids[idx] = part.RemoteID
+	}
+	return fmt.Sprintf("%v (published before the failure: %s)", e.Err, strings.Join(ids, ", "))
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// Capabilities describes what a platform accepts in a single post.
+type Capabilities struct {
+	Text          bool `json:"text"`
+	Images        bool `json:"images"`
+	Videos        bool `json:"videos"`
+	RequiresMedia bool `json:"requires_media"`
+	MaxMedia      int  `json:"max_media"`
+	MaxTextLength int  `json:"max_text_length"`
+	// Threads means text over MaxTextLength is split into a chain of
+	// posts, each with its own MaxMedia limit.
+	Threads bool `json:"threads"`
+	// MaxAltTextLength is the longest description a media item can have.
+	MaxAltTextLength int `json:"max_alt_text_length"`
+	// Media lists the file types, sizes and dimensions the platform accepts.
+	Media media.Limits `json:"media"`
+}
+
+type Request struct {
+	UserID       string
+	PlatformData json.RawMessage
+	Files        []*multipart.FileHeader
+}
+
+// Result describes a published post. RemoteID is the platform's own ID
+// for it and Permalink a public link, when the platform provides one. Posts
+// made of several parts, such as threads, list every part in Parts and use
+// the first one for RemoteID and Permalink.
+type Result struct {
+	Message   string `json:"message"`
+	RemoteID  string `json:"remote_id,omitempty"`
+	Permalink string `json:"permalink,omitempty"`
+	Parts     []Part `json:"parts,omitempty"`
+	// MediaTransforms is filled in by the caller when the images were
+	// normalized for the platform before publishing.
+	MediaTransforms []media.Normalization `json:"media_transforms,omitempty"`
+}
+
+// Part is one published piece of a multi-part post.
+type Part struct {
+	RemoteID  string `json:"remote_id"`
+	Permalink string `json:"permalink,omitempty"`
+}
+
+// Publisher is implemented by every platform that content can be posted to.
+type Publisher interface {
+	Platform() string
+	Capabilities() Capabilities
+	// Validate checks the request before anything is uploaded. It must not
+	// make network calls.
+	Validate(req *Request) error
+	// Publish posts the request. It stops uploading and polling the
+	// platform as soon as ctx is cancelled.
+	Publish(ctx context.Context, req *Request) (*Result, error)
+	IsLinked(ctx context.Context, userID string) bool
+}
+
+// Reconnector is implemented by publishers whose credentials are renewed in
+// the background and can stop working without any action from the user.
+type Reconnector interface {
+	// NeedsReconnect reports whether the user has to link the account again.
+	NeedsReconnect(ctx context.Context, userID string) bool
+}
+
+// RateLimiter is implemented by publishers that track the platform's rate
+// limits per account.
+type RateLimiter interface {
+	// CheckRateLimit returns a *provider.Error if publishing req now
+	// would exceed one of the user's limits on the platform. Like Validate,
+	// it must not make network calls.
+	CheckRateLimit(req *Request) error
+}
+
+// CheckRateLimit checks req against the platform's rate limits, if p
+// tracks them.
+func CheckRateLimit(p Publisher, req *Request) error {
+	if limiter, ok := p.(RateLimiter); ok {
+		return limiter.CheckRateLimit(req)
+	}
+	return nil
+}
+
+// LinkRoutes holds the HTTP routes that link a user's account on a
+// platform. Register adds the routes under the authenticated /api group;
+// Callback is served at CallbackPath outside of it, and both may be left
+// empty for platforms without a redirect-based flow.
+type LinkRoutes struct {
+	Register     func(api *echo.Group)
+	CallbackPath string
+	Callback     echo.HandlerFunc
+}
+
+type entry struct {
+	publisher Publisher
+	routes    *LinkRoutes
+}
+
+type Registry struct {
+	entries map[string]entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: map[string]entry{}}
+}
+
+func (r *Registry) Register(p Publisher, routes *LinkRoutes) {
+	r.entries[p.Platform()] = entry{publisher: p, routes: routes}
+}
+
+func (r *Registry) Get(platform string) (Publisher, bool) {
+	e, ok := r.entries[platform]
+	return e.publisher, ok
+}
+
+// Platforms returns the registered platform names in a stable order.
+func (r *Registry) Platforms() []string {
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) Publishers() []Publisher {
+	publishers := make([]Publisher, 0, len(r.entries))
+	for _, name := range r.Platforms() {
+		publishers = append(publishers, r.entries[name].publisher)
+	}
+	return publishers
+}
+
+func (r *Registry) LinkRoutes() []*LinkRoutes {
+	routes := []*LinkRoutes{}
+	for _, name := range r.Platforms() {
+		if lr := r.entries[name].routes; lr != nil {
+			routes = append(routes, lr)
+		}
+	}
+	return routes
+}
+
+// CallbackPaths lists the public OAuth callback paths of all platforms.
+func (r *Registry) CallbackPaths() []string {
+	paths := []string{}
+	for _, lr := range r.LinkRoutes() {
+		if lr.CallbackPath != "" {
+			paths = append(paths, lr.CallbackPath)
+		}
+	}
+	return paths
+}
+
+// CheckMedia inspects the uploaded files and checks them against the
+// platform's media limits, so that bad media is rejected before it is
+// uploaded anywhere.
+func CheckMedia(platform string, limits media.Limits, files []*multipart.FileHeader) error {
+	if err := limits.Check(platform, files); err != nil {
+		return Invalid("%v", err)
+	}
+	return nil
+}
+
+// CheckAltText checks the alt text given for each file, matched by index,
+// against the platform's length limit. With imagesOnly, alt text on a video
+// is rejected, since the platform has nowhere to put it.
+func CheckAltText(platform string, altText []string, files []*multipart.FileHeader, maxLength int, imagesOnly bool) error {
+	if len(altText) > len(files) {
+		return Invalid("%d alt texts were given for %d media items.", len(altText), len(files))
+	}
+	for i, text := range altText {
+		if text == "" {
+			continue
+		}
+		if utf8.RuneCountInString(text) > maxLength {
+			return Invalid("%s alt text can be at most %d characters long (item %d).", platform, maxLength, i+1)
+		}
+		if !imagesOnly {
+			continue
+		}
+		// Files that cannot be inspected are reported by CheckMedia.
+		info, err := media.Inspect(files[i])
+		if err == nil && info.Kind != media.KindImage {
+			return Invalid("%s only supports alt text on images (item %d).", platform, i+1)
+		}
+	}
+	return nil
+}
+
+// AltTextFor returns altText with exactly one entry per file, so that
+// services can index it alongside the files. Missing entries are empty.
+func AltTextFor(altText []string, files int) []string {
+	out := make([]string, files)
+	copy(out, altText)
+	return out
+}
+
+// NormalizeMedia rewrites the images of req that the platform would reject
+// because of their format, aspect ratio or size, and returns what was done
+// to each of them.
+func NormalizeMedia(p Publisher, req *Request, fit media.Fit) ([]media.Normalization, error) {
+	files, changes, err := media.NormalizeAll(req.Files, p.Capabilities().Media, fit)
+	if err != nil {
+		return nil, Invalid("%v", err)
+	}
+	req.Files = files
+	return changes, nil
+}
+
+// DecodePlatformData unmarshals the per-platform form payload into v.
+func DecodePlatformData(data json.RawMessage, v any) error {
+	if len(data) == 0 {
+		return Invalid("platformData is required")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return Invalid("Invalid format for platformData")
+	}
+	return nil
+}
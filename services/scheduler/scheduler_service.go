@@ -0,0 +1,334 @@
+package scheduler
+
+import (
+	"backend/models"
+	repo_job "backend/repositories/job"
+	"backend/repositories/provider"
+	repo_storage "backend/repositories/storage"
+	service_media "backend/services/media"
+	service_post "backend/services/post"
+	"backend/services/publisher"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+const (
+	pollInterval = 30 * time.Second
+	batchSize    = 10
+	// A running job whose worker has not sent a heartbeat for this long is
+	// assumed to belong to a worker that died and is handed out again, up
+	// to maxAttempts claims in total.
+	staleAfter        = 5 * time.Minute
+	heartbeatInterval = time.Minute
+	maxAttempts       = 3
+	// Files larger than this are spooled to disk when a job is rebuilt.
+	maxFormMemory = 32 << 20
+)
+
+type SchedulerService interface {
+	Schedule(ctx context.Context, userID string, platform string, platformData string, files []*multipart.FileHeader, scheduledAt time.Time) (*models.ScheduledJob, error)
+	ListJobs(userID string) ([]models.ScheduledJob, error)
+	CancelJob(ctx context.Context, userID string, jobID string) error
+	Run(ctx context.Context)
+}
+
+type schedulerServiceImpl struct {
+	repo_job     repo_job.JobRepository
+	repo_storage repo_storage.ObjectStore
+	registry     *publisher.Registry
+	postService  service_post.PostService
+}
+
+func NewSchedulerService(repoJob repo_job.JobRepository, repoStorage repo_storage.ObjectStore, registry *publisher.Registry, postService service_post.PostService) SchedulerService {
+	return &schedulerServiceImpl{
+		repo_job:     repoJob,
+		repo_storage: repoStorage,
+		registry:     registry,
+		postService:  postService,
+	}
+}
+
+// Schedule stages the uploaded media in object storage and persists a
+// pending job, so the post survives until the worker picks it up even if
+// the server restarts in between.
+func (s *schedulerServiceImpl) Schedule(ctx context.Context, userID string, platform string, platformData string, files []*multipart.FileHeader, scheduledAt time.Time) (*models.ScheduledJob, error) {
+	if _, ok := s.registry.Get(platform); !ok {
+		return nil, fmt.Errorf("unsupported platform: %s", platform)
+	}
+	if !json.Valid([]byte(platformData)) {
+		return nil, fmt.Errorf("invalid format for platformData")
+	}
+
+	media := make([]models.JobMedia, 0, len(files))
+	for idx, fh := range files {
+		staged, err := s.stageFile(ctx, userID, idx, fh)
+		if err != nil {
+			s.deleteMedia(context.WithoutCancel(ctx), media)
+			return nil, err
+		}
+		media = append(media, staged)
+	}
+
+	job, err := s.repo_job.Create(&models.ScheduledJob{
+		UserID:       userID,
+		Platform:     platform,
+		PlatformData: json.RawMessage(platformData),
+		Media:        media,
+		ScheduledAt:  scheduledAt,
+	})
+	if err != nil {
+		s.deleteMedia(context.WithoutCancel(ctx), media)
+		return nil, err
+	}
+
+	log.Printf("[SCHEDULER] --- Job %s scheduled for %s on %s", job.ID, job.ScheduledAt.Format(time.RFC3339), platform)
+	return job, nil
+}
+
+func (s *schedulerServiceImpl) stageFile(ctx context.Context, userID string, idx int, fh *multipart.FileHeader) (models.JobMedia, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return models.JobMedia{}, fmt.Errorf("failed to open file %s: %w", fh.Filename, err)
+	}
+	defer f.Close()
+
+	buffer := make([]byte, 512)
+	bytesRead, _ := f.Read(buffer)
+	mimeType := http.DetectContentType(buffer[:bytesRead])
+
+	ext := ""
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		ext = exts[0]
+	}
+
+	key := fmt.Sprintf("scheduled/%s/%d_%d%s", userID, time.Now().UnixNano(), idx, ext)
+	if _, err := s.repo_storage.UploadFile(ctx, f, key, mimeType); err != nil {
+		return models.JobMedia{}, fmt.Errorf("failed to stage file %s: %w", fh.Filename, err)
+	}
+
+	return models.JobMedia{
+		Key:         key,
+		FileName:    fh.Filename,
+		ContentType: mimeType,
+	}, nil
+}
+
+func (s *schedulerServiceImpl) ListJobs(userID string) ([]models.ScheduledJob, error) {
+	return s.repo_job.ListByUser(userID, 100)
+}
+
+func (s *schedulerServiceImpl) CancelJob(ctx context.Context, userID string, jobID string) error {
+	job, err := s.repo_job.GetByID(userID, jobID)
+	if err != nil {
+		return err
+	}
+
+	cancelled, err := s.repo_job.Cancel(userID, jobID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return fmt.Errorf("job is already %s", job.Status)
+	}
+
+	s.deleteMedia(ctx, job.Media)
+	return nil
+}
+
+// Run polls for due jobs until ctx is cancelled. Every poll also requeues
+// running jobs that have stopped sending heartbeats, so that a job left
+// behind by a worker that died is picked up again, whenever that is.
+func (s *schedulerServiceImpl) Run(ctx context.Context) {
+	log.Println("[SCHEDULER] --- Worker started")
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.requeueStale()
+		s.runDueJobs(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("[SCHEDULER] --- Worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *schedulerServiceImpl) requeueStale() {
+	now := time.Now()
+	requeued, failed, err := s.repo_job.RequeueStale(now.Add(-staleAfter), maxAttempts, now)
+	if failed > 0 {
+		log.Printf("[SCHEDULER] --- Failed %d stale jobs after %d attempts", failed, maxAttempts)
+	}
+	if err != nil {
+		log.Printf("[SCHEDULER] --- Failed to requeue stale jobs: %v", err)
+	} else if requeued > 0 {
+		log.Printf("[SCHEDULER] --- Requeued %d stale jobs", requeued)
+	}
+}
+
+// heartbeat keeps the job's heartbeat fresh until the returned function is
+// called, so that a long publish is not requeued while it is still running.
+func (s *schedulerServiceImpl) heartbeat(ctx context.Context, jobID string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.repo_job.Heartbeat(jobID, time.Now()); err != nil {
+					log.Printf("[SCHEDULER] --- %v", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (s *schedulerServiceImpl) runDueJobs(ctx context.Context) {
+	jobs, err := s.repo_job.ListDue(time.Now(), batchSize)
+	if err != nil {
+		log.Printf("[SCHEDULER] --- Failed to list due jobs: %v", err)
+		return
+	}
+
+	for idx := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job := &jobs[idx]
+		claimed, err := s.repo_job.Claim(job, time.Now())
+		if err != nil {
+			log.Printf("[SCHEDULER] --- %v", err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		s.runJob(ctx, job)
+	}
+}
+
+func (s *schedulerServiceImpl) runJob(ctx context.Context, job *models.ScheduledJob) {
+	log.Printf("[SCHEDULER] --- Running job %s (%s, attempt %d)", job.ID, job.Platform, job.Attempts)
+
+	stopHeartbeat := s.heartbeat(ctx, job.ID)
+	result, err := s.publish(ctx, job)
+	stopHeartbeat()
+	if err != nil && ctx.Err() != nil {
+		// The worker is shutting down. The job stays running, so it is
+		// requeued as stale instead of failing for good.
+		log.Printf("[SCHEDULER] --- Job %s interrupted: %v", job.ID, err)
+		return
+	}
+	var partialErr *publisher.PartialError
+	if retryAt, ok := deferUntil(err); ok && !errors.As(err, &partialErr) {
+		// Nothing was published, so the job is simply run again once the
+		// platform's rate limit or quota has reset.
+		log.Printf("[SCHEDULER] --- Job %s deferred until %s: %v", job.ID, retryAt.Format(time.RFC3339), err)
+		if err := s.repo_job.Defer(job, retryAt, err.Error()); err != nil {
+			log.Printf("[SCHEDULER] --- %v", err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("[SCHEDULER] --- Job %s failed: %v", job.ID, err)
+		if err := s.repo_job.Fail(job.ID, err.Error(), time.Now()); err != nil {
+			log.Printf("[SCHEDULER] --- %v", err)
+		}
+		return
+	}
+
+	resultBytes, _ := json.Marshal(result)
+	if err := s.repo_job.Complete(job.ID, resultBytes, time.Now()); err != nil {
+		log.Printf("[SCHEDULER] --- %v", err)
+		return
+	}
+
+	s.deleteMedia(context.WithoutCancel(ctx), job.Media)
+	log.Printf("[SCHEDULER] --- Job %s published", job.ID)
+}
+
+// deferUntil returns when a job that failed with err can be run again, if
+// it failed on a rate limit or quota that the platform said when it resets.
+func deferUntil(err error) (time.Time, bool) {
+	providerErr, ok := provider.As(err)
+	if !ok || providerErr.RetryAt.IsZero() {
+		return time.Time{}, false
+	}
+	if providerErr.Class != provider.RateLimited && providerErr.Class != provider.QuotaExceeded {
+		return time.Time{}, false
+	}
+	return providerErr.RetryAt, true
+}
+
+func (s *schedulerServiceImpl) publish(ctx context.Context, job *models.ScheduledJob) (*publisher.Result, error) {
+	files, form, err := s.loadMedia(ctx, job.Media)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		defer form.RemoveAll()
+	}
+
+	req := &publisher.Request{
+		UserID:       job.UserID,
+		PlatformData: job.PlatformData,
+		Files:        files,
+	}
+	// A job that would run into a rate limit is deferred before it is
+	// recorded in the post history.
+	if p, ok := s.registry.Get(job.Platform); ok {
+		if err := publisher.CheckRateLimit(p, req); err != nil {
+			return nil, err
+		}
+	}
+
+	outcome := s.postService.Publish(ctx, job.UserID, len(files), []publisher.Target{{
+		Platform: job.Platform,
+		Request:  req,
+	}})[0]
+	return outcome.Result, outcome.Err
+}
+
+// loadMedia streams the staged files back into multipart file headers,
+// which is what the platform services expect. The returned form must be
+// cleaned up with RemoveAll once the files are no longer needed.
+func (s *schedulerServiceImpl) loadMedia(ctx context.Context, media []models.JobMedia) ([]*multipart.FileHeader, *multipart.Form, error) {
+	sources := make([]service_media.Source, len(media))
+	for idx, m := range media {
+		key := m.Key
+		sources[idx] = service_media.Source{
+			FileName:    m.FileName,
+			ContentType: m.ContentType,
+			Open:        func() (io.ReadCloser, error) { return s.repo_storage.OpenFile(ctx, key) },
+		}
+	}
+	return service_media.SpoolFiles(sources, maxFormMemory)
+}
+
+func (s *schedulerServiceImpl) deleteMedia(ctx context.Context, media []models.JobMedia) {
+	for _, m := range media {
+		if err := s.repo_storage.DeleteFile(ctx, m.Key); err != nil {
+			log.Printf("[SCHEDULER] --- %v", err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package staging
+
+import (
+	"backend/models"
+	repo_media "backend/repositories/media"
+	repo_staging "backend/repositories/staging"
+	repo_storage "backend/repositories/storage"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	sweepInterval = 30 * time.Minute
+	sweepBatch    = 100
+)
+
+// ttls is how long objects of each purpose are kept when they are never
+// released. Instagram containers expire after a day, so their media is of
+// no use after that either. Direct uploads are presigned for an hour.
+var ttls = map[models.StagePurpose]time.Duration{
+	models.StagePurposeInstagram:    24 * time.Hour,
+	models.StagePurposeDirectUpload: 6 * time.Hour,
+}
+
+// StagingService keeps track of the temporary objects put in the bucket, so
+// that none of them stays there forever.
+type StagingService interface {
+	// Stage uploads file under key and tracks it. It returns the object's
+	// public URL.
+	Stage(ctx context.Context, userID string, purpose models.StagePurpose, file io.Reader, key string, mimeType string) (string, error)
+	// Track records an object that is uploaded by someone else, such as
+	// the browser through a presigned request.
+	Track(ctx context.Context, userID string, purpose models.StagePurpose, key string) error
+	// Release deletes a staged object once it is no longer needed.
+	Release(ctx context.Context, key string) error
+	// Run deletes expired objects until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+type stagingServiceImpl struct {
+	repo_staging repo_staging.StagingRepository
+	repo_media   repo_media.MediaRepository
+	repo_storage repo_storage.ObjectStore
+}
+
+func NewStagingService(repoStaging repo_staging.StagingRepository, repoMedia repo_media.MediaRepository, repoStorage repo_storage.ObjectStore) StagingService {
+	return &stagingServiceImpl{
+		repo_staging: repoStaging,
+		repo_media:   repoMedia,
+		repo_storage: repoStorage,
+	}
+}
+
+func (s *stagingServiceImpl) Stage(ctx context.Context, userID string, purpose models.StagePurpose, file io.Reader, key string, mimeType string) (string, error) {
+	// The object is tracked before it is written, so that it is swept even
+	// if the process dies halfway through the upload.
+	if err := s.Track(ctx, userID, purpose, key); err != nil {
+		return "", err
+	}
+
+	publicURL, err := s.repo_storage.UploadFile(ctx, file, key, mimeType)
+	if err != nil {
+		// The upload may have been cancelled, but the partial object must
+		// still go.
+		if err := s.Release(context.WithoutCancel(ctx), key); err != nil {
+			log.Printf("[STAGING_SERVICE] --- %v", err)
+		}
+		return "", err
+	}
+	return publicURL, nil
+}
+
+func (s *stagingServiceImpl) Track(ctx context.Context, userID string, purpose models.StagePurpose, key string) error {
+	ttl, ok := ttls[purpose]
+	if !ok {
+		return fmt.Errorf("unknown staging purpose %q", purpose)
+	}
+	return s.repo_staging.Track(&models.StagedObject{
+		Key:       key,
+		Purpose:   purpose,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	})
+}
+
+// Release deletes the object before it stops tracking it, so a failed
+// delete is retried by the janitor.
+func (s *stagingServiceImpl) Release(ctx context.Context, key string) error {
+	if err := s.repo_storage.DeleteFile(ctx, key); err != nil {
+		return err
+	}
+	return s.repo_staging.Untrack(key)
+}
+
+func (s *stagingServiceImpl) Run(ctx context.Context) {
+	log.Println("[STAGING_JANITOR] --- Worker started")
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			log.Println("[STAGING_JANITOR] --- Worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes expired objects batch by batch. It stops early when a
+// whole batch fails, leaving those objects for the next run.
+func (s *stagingServiceImpl) sweep(ctx context.Context) {
+	for ctx.Err() == nil {
+		objects, err := s.repo_staging.ListExpired(time.Now(), sweepBatch)
+		if err != nil {
+			log.Printf("[STAGING_JANITOR] --- %v", err)
+			return
+		}
+
+		removed := 0
+		for _, object := range objects {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := s.expire(ctx, &object); err != nil {
+				log.Printf("[STAGING_JANITOR] --- %v", err)
+				continue
+			}
+			removed++
+		}
+		if removed > 0 {
+			log.Printf("[STAGING_JANITOR] --- Removed %d expired objects", removed)
+		}
+		if len(objects) < sweepBatch || removed == 0 {
+			return
+		}
+	}
+}
+
+// expire deletes an expired object, unless an asset in a media library
+// refers to it. Library objects are never deleted here; they are only
+// untracked.
+func (s *stagingServiceImpl) expire(ctx context.Context, object *models.StagedObject) error {
+	assets, err := s.repo_media.CountByObjectKey(object.Key)
+	if err != nil {
+		return err
+	}
+	if assets > 0 {
+		log.Printf("[STAGING_JANITOR] --- Keeping %s, it is in a media library", object.Key)
+		return s.repo_staging.Untrack(object.Key)
+	}
+	return s.Release(ctx, object.Key)
+}
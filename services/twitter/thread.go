@@ -0,0 +1,88 @@
+package repo_twitter
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// threadSeparator on a line of its own starts a new tweet in a thread.
+const threadSeparator = "---"
+
+// splitThread turns the post content into the text of each tweet. Lines
+// consisting of threadSeparator split the content explicitly, and any part
+// that is still longer than limit is split at word boundaries. The content
+// is NFC-normalized first, the way Twitter counts it.
+func splitThread(content string, limit int) []string {
+	tweets := []string{}
+	for _, section := range splitSections(norm.NFC.String(content)) {
+		tweets = append(tweets, splitLong(section, limit)...)
+	}
+	return tweets
+}
+
+func splitSections(content string) []string {
+	sections := []string{}
+	current := []string{}
+
+	flush := func() {
+		if section := strings.TrimSpace(strings.Join(current, "\n")); section != "" {
+			sections = append(sections, section)
+		}
+		current = current[:0]
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == threadSeparator {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return sections
+}
+
+// splitLong breaks text into pieces of at most limit, cutting at the last
+// whitespace that fits. A single word longer than limit is cut mid-word;
+// URLs and emoji sequences are never cut. The text is measured once and
+// the pieces are filled unit by unit.
+func splitLong(text string, limit int) []string {
+	units := tweetUnits(text)
+	startOf := func(idx int) int {
+		if idx == 0 {
+			return 0
+		}
+		return units[idx-1].End
+	}
+
+	pieces := []string{}
+	pieceStart, weight, lastSpace := 0, 0, -1
+	for idx := 0; idx < len(units); {
+		unit := units[idx]
+		unitStart := startOf(idx)
+		if weight+unit.Weight > limit*weightScale && unitStart > pieceStart {
+			next := idx
+			if lastSpace >= 0 {
+				next = lastSpace
+			}
+			pieces = append(pieces, strings.TrimRightFunc(text[pieceStart:startOf(next)], unicode.IsSpace))
+			for next < len(units) && units[next].Space {
+				next++
+			}
+			idx, pieceStart, weight, lastSpace = next, startOf(next), 0, -1
+			continue
+		}
+		if unit.Space && unitStart > pieceStart {
+			lastSpace = idx
+		}
+		weight += unit.Weight
+		idx++
+	}
+	if pieceStart < len(text) {
+		pieces = append(pieces, text[pieceStart:])
+	}
+	return pieces
+}
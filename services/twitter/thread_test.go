@@ -0,0 +1,61 @@
+package repo_twitter
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitThread(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		want    []string
+	}{
+		{"single tweet", "hello", 280, []string{"hello"}},
+		{"separator", "one\n---\ntwo", 280, []string{"one", "two"}},
+		{"separator with spaces", "one\n  ---  \ntwo", 280, []string{"one", "two"}},
+		{"empty sections are dropped", "---\none\n---\n\n---\ntwo\n---", 280, []string{"one", "two"}},
+		{"separator inside a line is text", "one --- two", 280, []string{"one --- two"}},
+		{"sections are trimmed", "\n one \n---\n two \n", 280, []string{"one", "two"}},
+		{"cut at last space", "aaaa bbbb cccc", 10, []string{"aaaa bbbb", "cccc"}},
+		{"runs of spaces are dropped", "aaaa    bbbb", 5, []string{"aaaa", "bbbb"}},
+		{"newlines are spaces", "aaaa\nbbbb", 5, []string{"aaaa", "bbbb"}},
+		{"long word is cut", "abcdefghijkl", 5, []string{"abcde", "fghij", "kl"}},
+		{"url is not cut", "look https://example.com/abc end", 30, []string{"look https://example.com/abc", "end"}},
+		{"emoji is not cut", "a👍👍", 3, []string{"a👍", "👍"}},
+		{"cjk is weighted", "字字字 字字", 6, []string{"字字字", "字字"}},
+		{"content is normalized", "cafe\u0301", 280, []string{"café"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitThread(tt.content, tt.limit)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("splitThread(%q, %d) = %q, want %q", tt.content, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitThreadFitsLimit(t *testing.T) {
+	words := []string{"lorem", "ipsum", "https://example.com/a/long/path", "日本語", "👨‍👩‍👧", "dolor", "sit", "amet,", "example.org"}
+	parts := []string{}
+	for idx := range 2000 {
+		parts = append(parts, words[idx%len(words)])
+	}
+	content := strings.Join(parts, " ")
+
+	tweets := splitThread(content, maxTweetLength)
+	for idx, tweet := range tweets {
+		if length := tweetLength(tweet); length > maxTweetLength {
+			t.Fatalf("tweet %d is %d characters long, want at most %d", idx, length, maxTweetLength)
+		}
+		if tweet == "" || strings.TrimSpace(tweet) != tweet {
+			t.Fatalf("tweet %d = %q, want it non-empty and trimmed", idx, tweet)
+		}
+	}
+	if joined := strings.Join(tweets, " "); joined != content {
+		t.Errorf("joined tweets differ from the content")
+	}
+}
@@ -0,0 +1,199 @@
+package repo_twitter
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Counting rules from twitter-text (config v3). Every code point weighs
+// defaultWeight unless it falls in one of the light ranges, every URL counts
+// as a t.co link, and an emoji sequence counts once no matter how many code
+// points it is made of. Lengths are compared after NFC normalization.
+const (
+	weightScale          = 100
+	defaultWeight        = 200
+	lightWeight          = 100
+	transformedURLLength = 23
+)
+
+var lightRanges = [][2]rune{
+	{0x0000, 0x10FF}, // Latin, Greek, Cyrillic, Hebrew, Arabic, Indic, ...
+	{0x2000, 0x200D}, // spaces and zero-width characters
+	{0x2010, 0x201F}, // dashes and quotation marks
+	{0x2032, 0x2037}, // primes
+}
+
+// urlPattern matches links with a scheme and anything that looks like a
+// bare domain, capturing its TLD and path. Whether a bare domain is a link
+// is up to isBareURL.
+var urlPattern = regexp.MustCompile(`(?i)(?:https?://[^\s<>"]+|\b(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,}|xn--[a-z0-9-]+)\b(/[^\s<>"]*)?)`)
+
+// TweetLength is the weighted length of a tweet and how far it is over the
+// limit, both in the characters Twitter shows to the user.
+type TweetLength struct {
+	Weighted int
+	Overflow int
+}
+
+func measureTweet(text string, limit int) TweetLength {
+	weighted := tweetLength(text)
+	overflow := weighted - limit
+	if overflow < 0 {
+		overflow = 0
+	}
+	return TweetLength{Weighted: weighted, Overflow: overflow}
+}
+
+// tweetLength returns the weighted length Twitter counts for text.
+func tweetLength(text string) int {
+	weight := 0
+	for _, unit := range tweetUnits(norm.NFC.String(text)) {
+		weight += unit.Weight
+	}
+	return weight / weightScale
+}
+
+// tweetUnit is a part of a tweet that is counted as a whole: a URL, an
+// emoji sequence or a single code point. End is the byte offset in the text
+// right after it, and Weight is in weightScale units.
+type tweetUnit struct {
+	End    int
+	Weight int
+	Space  bool
+}
+
+// tweetUnits breaks NFC-normalized text into the units its weighted length
+// is the sum of.
+func tweetUnits(text string) []tweetUnit {
+	units := []tweetUnit{}
+	last := 0
+	for _, loc := range urlPattern.FindAllStringSubmatchIndex(text, -1) {
+		if loc[2] >= 0 && !isBareURL(text, loc) {
+			continue
+		}
+		start, end := loc[0], trimURLEnd(text, loc[0], loc[1])
+		units = appendTextUnits(units, text, last, start)
+		units = append(units, tweetUnit{End: end, Weight: transformedURLLength * weightScale})
+		last = end
+	}
+	return appendTextUnits(units, text, last, len(text))
+}
+
+// isBareURL applies the twitter-text rules for domains without a scheme to
+// a urlPattern match. The TLD has to be a generic or country code TLD on the
+// IANA list, which the ICANN part of the public suffix list mirrors. A
+// single label on a country code TLD, such as "readme.md", is only a link
+// with a path, except on .co and .tv.
+func isBareURL(text string, loc []int) bool {
+	tld := strings.ToLower(text[loc[2]:loc[3]])
+	// The name in front lets wildcard rules such as *.ck match.
+	if _, icann := publicsuffix.PublicSuffix("example." + tld); !icann {
+		return false
+	}
+	hasPath := loc[4] >= 0
+	labels := strings.Count(text[loc[0]:loc[3]], ".")
+	if len(tld) == 2 && tld != "co" && tld != "tv" && labels == 1 && !hasPath {
+		return false
+	}
+	return true
+}
+
+// trimURLEnd drops trailing punctuation that belongs to the sentence rather
+// than the link, e.g. the period in "see example.com.".
+func trimURLEnd(text string, start int, end int) int {
+	for end > start && strings.ContainsRune(".,;:!?'\")]", rune(text[end-1])) {
+		end--
+	}
+	return end
+}
+
+// appendTextUnits appends the emoji sequences and code points of
+// text[start:end], which holds no URL.
+func appendTextUnits(units []tweetUnit, text string, start int, end int) []tweetUnit {
+	for pos := start; pos < end; {
+		if n := emojiSequenceLength(text[pos:end]); n > 0 {
+			pos += n
+			units = append(units, tweetUnit{End: pos, Weight: defaultWeight})
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text[pos:end])
+		pos += size
+		units = append(units, tweetUnit{End: pos, Weight: runeWeight(r), Space: unicode.IsSpace(r)})
+	}
+	return units
+}
+
+func runeWeight(r rune) int {
+	for _, rng := range lightRanges {
+		if r >= rng[0] && r <= rng[1] {
+			return lightWeight
+		}
+	}
+	return defaultWeight
+}
+
+// emojiSequenceLength returns the byte length of the emoji sequence at the
+// start of text, or 0 if text does not start with one. A sequence is an
+// emoji (or a keycap / text-style base followed by a presentation selector)
+// with its modifiers, and further emoji joined with zero width joiners.
+func emojiSequenceLength(text string) int {
+	first, size := utf8.DecodeRuneInString(text)
+	next, _ := utf8.DecodeRuneInString(text[size:])
+
+	switch {
+	case isRegionalIndicator(first):
+		// Flags are pairs of regional indicators.
+		if isRegionalIndicator(next) {
+			return size + utf8.RuneLen(next)
+		}
+		return size
+	case isEmoji(first):
+	case next == 0xFE0F || next == 0x20E3:
+		// Text characters such as digits, © or ♥ become emoji when followed by
+		// a presentation selector or a keycap.
+	default:
+		return 0
+	}
+
+	n := size
+	for n < len(text) {
+		r, rsize := utf8.DecodeRuneInString(text[n:])
+		switch {
+		case isEmojiModifier(r):
+			n += rsize
+		case r == 0x200D:
+			joined, jsize := utf8.DecodeRuneInString(text[n+rsize:])
+			if !isEmoji(joined) {
+				return n
+			}
+			n += rsize + jsize
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isEmoji(r rune) bool {
+	return (r >= 0x1F000 && r <= 0x1FAFF) ||
+		(r >= 0x2600 && r <= 0x27BF) ||
+		(r >= 0x2300 && r <= 0x23FF) ||
+		(r >= 0x2B00 && r <= 0x2BFF)
+}
+
+// isEmojiModifier reports variation selectors, keycaps, skin tones and tag
+// characters, which never count on their own.
+func isEmojiModifier(r rune) bool {
+	return r == 0xFE0F || r == 0xFE0E || r == 0x20E3 ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) ||
+		(r >= 0xE0020 && r <= 0xE007F)
+}
@@ -0,0 +1,67 @@
+package repo_twitter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTweetLength(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"latin", "hello world", 11},
+		{"cjk counts double", "日本語", 6},
+		{"precomposed", "café", 4},
+		{"decomposed is normalized", "cafe\u0301", 4},
+		{"light punctuation", "a—b", 3},
+		{"ellipsis is not light", "…", 2},
+		{"url", "https://example.com/some/very/long/path?query=1", 23},
+		{"short url still counts as t.co", "http://a.co", 23},
+		{"url in text", "read https://example.com now", 32},
+		{"bare domain", "example.com", 23},
+		{"trailing period is not part of the url", "see example.com.", 28},
+		{"country code domain with path", "example.md/path", 23},
+		{"country code domain without path", "readme.md", 9},
+		{"co domain without path", "example.co", 23},
+		{"unknown tld", "foo.notatld", 11},
+		{"emoji", "👍", 2},
+		{"skin tone", "👍🏽", 2},
+		{"zwj sequence", "👨‍👩‍👧", 2},
+		{"flag", "🇯🇵", 2},
+		{"keycap", "1️⃣", 2},
+		{"text style heart with selector", "♥️", 2},
+		{"emoji in text", "ok 👍", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tweetLength(tt.text); got != tt.want {
+				t.Errorf("tweetLength(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeasureTweet(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  TweetLength
+	}{
+		{"fits", "hello", 280, TweetLength{Weighted: 5}},
+		{"exactly at limit", strings.Repeat("a", 280), 280, TweetLength{Weighted: 280}},
+		{"over limit", strings.Repeat("a", 281), 280, TweetLength{Weighted: 281, Overflow: 1}},
+		{"cjk at limit", strings.Repeat("字", 140), 280, TweetLength{Weighted: 280}},
+		{"cjk over limit", strings.Repeat("字", 141), 280, TweetLength{Weighted: 282, Overflow: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := measureTweet(tt.text, tt.limit); got != tt.want {
+				t.Errorf("measureTweet() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package repo_twitter
+
+import (
+	"backend/repositories/ratelimit"
+	repo_twitter "backend/repositories/twitter"
+	"backend/services/media"
+	"backend/services/publisher"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"strings"
+)
+
+const (
+	maxTweetLength  = 280
+	maxTweetMedia   = 4
+	maxThreadTweets = 25
+	// Alt text can only be set on images and GIFs.
+	maxAltTextLength = 1000
+)
+
+// tweetMediaLimits are the limits of the media upload endpoint. A tweet has
+// up to four images, or a single video or GIF. GIFs are uploaded as
+// tweet_gif, which allows up to 15 MB.
+var tweetMediaLimits = media.Limits{
+	MaxItems:        maxTweetMedia,
+	MaxVideos:       1,
+	NoMixedMedia:    true,
+	MaxGIFs:         1,
+	MaxGIFBytes:     15 << 20,
+	ImageTypes:      []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+	MaxImageBytes:   5 << 20,
+	VideoTypes:      []string{"video/mp4"},
+	VideoCodecs:     []string{"h264"},
+	MaxVideoBytes:   512 << 20,
+	MinVideoSeconds: 0.5,
+	MaxVideoSeconds: 140,
+	MaxFrameRate:    60,
+}
+
+// twitterData is the platformData of a tweet. Content that is too long for
+// one tweet, or that contains "---" lines, is posted as a thread unless
+// Thread is set to false. ThreadMedia lists the indexes of the uploaded
+// files attached to each tweet of the thread; without it, all files go to
+// the first tweet. AltText is indexed like the uploaded files, wherever they
+// end up in the thread.
+type twitterData struct {
+	Content     string   `json:"content"`
+	Thread      *bool    `json:"thread,omitempty"`
+	ThreadMedia [][]int  `json:"thread_media,omitempty"`
+	AltText     []string `json:"alt_text,omitempty"`
+}
+
+type twitterPublisher struct {
+	twitterService TwitterService
+	repo_twitter   repo_twitter.TwitterRepository
+	limits         *ratelimit.Tracker
+}
+
+// NewTwitterPublisher exposes the Twitter service through the publisher registry.
+func NewTwitterPublisher(twitterService TwitterService, repo repo_twitter.TwitterRepository, limits *ratelimit.Tracker) publisher.Publisher {
+	return &twitterPublisher{
+		twitterService: twitterService,
+		repo_twitter:   repo,
+		limits:         limits,
+	}
+}
+
+func (p *twitterPublisher) Platform() string {
+	return "twitter"
+}
+
+func (p *twitterPublisher) Capabilities() publisher.Capabilities {
+	return publisher.Capabilities{
+		Text:          true,
+		Images:        true,
+		Videos:        true,
+		MaxMedia:      maxTweetMedia,
+		MaxTextLength: maxTweetLength,
+		Threads:       true,
+		Media:         tweetMediaLimits,
+
+		MaxAltTextLength: maxAltTextLength,
+	}
+}
+
+func (p *twitterPublisher) Validate(req *publisher.Request) error {
+	var data twitterData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return err
+	}
+	if err := publisher.CheckAltText("Twitter", data.AltText, req.Files, maxAltTextLength, true); err != nil {
+		return err
+	}
+	tweets, err := buildThread(&data, req.Files)
+	if err != nil {
+		return err
+	}
+	for idx, tweet := range tweets {
+		if err := publisher.CheckMedia("Twitter", tweetMediaLimits, tweet.Files); err != nil {
+			if len(tweets) > 1 {
+				return publisher.Invalid("Tweet %d: %v", idx+1, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckRateLimit counts the calls the post will make: one per tweet and,
+// for each file, an INIT, an APPEND per segment, a FINALIZE and the alt
+// text. Status checks depend on how long X takes to process the media, so
+// they are not counted.
+func (p *twitterPublisher) CheckRateLimit(req *publisher.Request) error {
+	var data twitterData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return err
+	}
+	tweets, err := buildThread(&data, req.Files)
+	if err != nil {
+		return err
+	}
+
+	segments, altTexts := 0, 0
+	for _, tweet := range tweets {
+		for idx, fh := range tweet.Files {
+			segments += int((fh.Size + maxChunkSize - 1) / maxChunkSize)
+			if tweet.AltText[idx] != "" {
+				altTexts++
+			}
+		}
+	}
+
+	needs := ratelimit.XNeeds(repo_twitter.EndpointTweets, len(tweets))
+	if len(req.Files) > 0 {
+		needs = append(needs, ratelimit.XNeeds(repo_twitter.EndpointMediaInit, len(req.Files))...)
+		needs = append(needs, ratelimit.XNeeds(repo_twitter.EndpointMediaAppend, segments)...)
+		needs = append(needs, ratelimit.XNeeds(repo_twitter.EndpointMediaFinalize, len(req.Files))...)
+	}
+	if altTexts > 0 {
+		needs = append(needs, ratelimit.XNeeds(repo_twitter.EndpointMediaMetadata, altTexts)...)
+	}
+	return p.limits.Check("twitter", req.UserID, needs...)
+}
+
+// buildThread splits the content into tweets and attaches the media to
+// them. A post that fits in one tweet yields a single entry.
+func buildThread(data *twitterData, files []*multipart.FileHeader) ([]ThreadTweet, error) {
+	var texts []string
+	if data.Thread != nil && !*data.Thread {
+		length := measureTweet(data.Content, maxTweetLength)
+		if length.Overflow > 0 {
+			return nil, publisher.Invalid("The tweet is %d characters over the %d character limit (weighted length %d).", length.Overflow, maxTweetLength, length.Weighted)
+		}
+		if content := strings.TrimSpace(data.Content); content != "" {
+			texts = []string{content}
+		}
+	} else {
+		// Content that can't fit in the longest thread is rejected before it
+		// is split.
+		if length := measureTweet(data.Content, maxThreadTweets*maxTweetLength); length.Overflow > 0 {
+			return nil, publisher.Invalid("The content is %d characters, a thread can hold at most %d (%d tweets of %d).", length.Weighted, maxThreadTweets*maxTweetLength, maxThreadTweets, maxTweetLength)
+		}
+		texts = splitThread(data.Content, maxTweetLength)
+	}
+	if len(texts) == 0 {
+		if len(files) == 0 {
+			return nil, publisher.Invalid("A tweet must have either text content or media.")
+		}
+		texts = []string{""}
+	}
+	if len(texts) > maxThreadTweets {
+		return nil, publisher.Invalid("A thread can have at most %d tweets, the content splits into %d.", maxThreadTweets, len(texts))
+	}
+
+	tweets := make([]ThreadTweet, len(texts))
+	for idx, text := range texts {
+		tweets[idx].Text = text
+	}
+
+	altText := publisher.AltTextFor(data.AltText, len(files))
+	if data.ThreadMedia == nil {
+		tweets[0].Files = files
+		tweets[0].AltText = altText
+	} else {
+		if len(data.ThreadMedia) > len(tweets) {
+			return nil, publisher.Invalid("thread_media has %d entries but the content splits into %d tweets.", len(data.ThreadMedia), len(tweets))
+		}
+		used := make([]bool, len(files))
+		for tweetIdx, indexes := range data.ThreadMedia {
+			for _, fileIdx := range indexes {
+				if fileIdx < 0 || fileIdx >= len(files) {
+					return nil, publisher.Invalid("Media index %d is out of range.", fileIdx)
+				}
+				if used[fileIdx] {
+					return nil, publisher.Invalid("Media index %d is attached to more than one tweet.", fileIdx)
+				}
+				used[fileIdx] = true
+				tweets[tweetIdx].Files = append(tweets[tweetIdx].Files, files[fileIdx])
+				tweets[tweetIdx].AltText = append(tweets[tweetIdx].AltText, altText[fileIdx])
+			}
+		}
+		for fileIdx, ok := range used {
+			if !ok {
+				return nil, publisher.Invalid("Media index %d is not attached to any tweet.", fileIdx)
+			}
+		}
+	}
+
+	for idx, tweet := range tweets {
+		if len(tweet.Files) > maxTweetMedia {
+			return nil, publisher.Invalid("Tweet %d has %d media items, a tweet can have at most %d.", idx+1, len(tweet.Files), maxTweetMedia)
+		}
+		if tweet.Text == "" && len(tweet.Files) == 0 {
+			return nil, publisher.Invalid("Tweet %d has neither text content nor media.", idx+1)
+		}
+	}
+	return tweets, nil
+}
+
+func (p *twitterPublisher) Publish(ctx context.Context, req *publisher.Request) (*publisher.Result, error) {
+	accessToken, accessSecret, err := p.repo_twitter.GetCredentials(ctx, req.UserID)
+	if err != nil || accessToken == "" || accessSecret == "" {
+		return nil, fmt.Errorf("Twitter %w", publisher.ErrNotLinked)
+	}
+
+	var data twitterData
+	if err := publisher.DecodePlatformData(req.PlatformData, &data); err != nil {
+		return nil, err
+	}
+
+	tweets, err := buildThread(&data, req.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tweets) == 1 {
+		tweetID, err := p.twitterService.PostTweet(ctx, accessToken, accessSecret, tweets[0].Text, tweets[0].Files, tweets[0].AltText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to post tweet: %w", err)
+		}
+		return &publisher.Result{
+			Message:   "Tweet posted successfully!",
+			RemoteID:  tweetID,
+			Permalink: tweetPermalink(tweetID),
+		}, nil
+	}
+
+	tweetIDs, err := p.twitterService.PostThread(ctx, accessToken, accessSecret, tweets)
+	if err != nil {
+		err = fmt.Errorf("failed to post thread: %w", err)
+		var threadErr *ThreadError
+		if errors.As(err, &threadErr) && len(threadErr.Posted) > 0 {
+			return nil, &publisher.PartialError{Published: threadParts(threadErr.Posted), Err: err}
+		}
+		return nil, err
+	}
+	return &publisher.Result{
+		Message:   fmt.Sprintf("Thread of %d tweets posted successfully!", len(tweetIDs)),
+		RemoteID:  tweetIDs[0],
+		Permalink: tweetPermalink(tweetIDs[0]),
+		Parts:     threadParts(tweetIDs),
+	}, nil
+}
+
+func tweetPermalink(tweetID string) string {
+	return "https://x.com/i/web/status/" + tweetID
+}
+
+func threadParts(tweetIDs []string) []publisher.Part {
+	parts := make([]publisher.Part, len(tweetIDs))
+	for idx, tweetID := range tweetIDs {
+		parts[idx] = publisher.Part{RemoteID: tweetID, Permalink: tweetPermalink(tweetID)}
+	}
+	return parts
+}
+
+func (p *twitterPublisher) IsLinked(ctx context.Context, userID string) bool {
+	accessToken, accessSecret, err := p.repo_twitter.GetCredentials(ctx, userID)
+	if err != nil {
+		return false
+	}
+	return p.repo_twitter.CheckTokens(ctx, accessToken, accessSecret) == nil
+}
@@ -1,25 +1,59 @@
 package repo_twitter
 
 import (
+	"backend/repositories/httpclient"
+	"backend/repositories/provider"
 	repo_twitter "backend/repositories/twitter"
-	"bytes"
+	"backend/services/publisher"
+	"context"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	_ "net/http/httputil"
 	
 	_ "strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dghubble/oauth1"
 )
 
+// maxChunkSize is the size of the segments media is uploaded in.
+const maxChunkSize = 4 * 1024 * 1024
+
 type TwitterService interface {
 	GetAuthorizationURL() (string, string, error)
 	GetAccessToken(oauthToken, requestSecret, oauthVerifier string) (string, string, error)
-	PostTweet(accessToken, accessSecret, content string, files []*multipart.FileHeader) error
+	PostTweet(ctx context.Context, accessToken, accessSecret, content string, files []*multipart.FileHeader, altText []string) (string, error)
+	PostThread(ctx context.Context, accessToken, accessSecret string, tweets []ThreadTweet) ([]string, error)
+	Unlink(ctx context.Context, userID string) error
+}
+
+// ThreadTweet is one tweet of a thread and the media attached to it.
+// AltText has one entry per file.
+type ThreadTweet struct {
+	Text    string
+	Files   []*multipart.FileHeader
+	AltText []string
+}
+
+// ThreadError is returned by PostThread when a tweet could not be posted.
+// Posted holds the IDs of the tweets before it, which stay live.
+type ThreadError struct {
+	Index  int
+	Posted []string
+	Err    error
+}
+
+func (e *ThreadError) Error() string {
+	return fmt.Sprintf("tweet %d of the thread failed: %v", e.Index+1, e.Err)
+}
+
+func (e *ThreadError) Unwrap() error {
+	return e.Err
 }
 
 type twitterServiceImpl struct {
@@ -56,17 +90,26 @@ func (s *twitterServiceImpl) GetAccessToken(oauthToken, requestSecret, oauthVeri
 	return accessToken, accessSecret, nil
 }
 
-func (s *twitterServiceImpl) uploadMultipleMedia(httpClient *http.Client, files []*multipart.FileHeader) ([]string, error) {
-	// Channels and a WaitGroup to handle concurrent uploads safely.
+func (s *twitterServiceImpl) uploadMultipleMedia(ctx context.Context, httpClient *http.Client, files []*multipart.FileHeader, altText []string) ([]string, error) {
+	// The uploads that are still running are stopped when one of them fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each upload writes its media ID at the index of its file, so the media
+	// is attached in the order it was given rather than the order in which
+	// the uploads finish.
 	var wg sync.WaitGroup
-	resultChan := make(chan string, len(files))
+	mediaIDs := make([]string, len(files))
 	errChan := make(chan error, len(files))
+	var uploaded atomic.Int32
 
-	for _, fileHeader := range files {
+	publisher.Uploading(ctx, 0, len(files))
+
+	for idx, fileHeader := range files {
 		wg.Add(1) // Increment the WaitGroup counter
 
 		// Launch a new goroutine for each file upload.
-		go func(fh *multipart.FileHeader) {
+		go func(idx int, fh *multipart.FileHeader, alt string) {
 			defer wg.Done() // Decrement the counter when the goroutine finishes
 
 			file, err := fh.Open()
@@ -76,81 +119,89 @@ func (s *twitterServiceImpl) uploadMultipleMedia(httpClient *http.Client, files
 			}
 			defer file.Close()
 
-			mediaData, err := io.ReadAll(file)
-			if err != nil {
+			// Segments are read straight from the uploaded file (a temp file
+			// for anything large), so the media is never loaded into memory
+			// as a whole.
+			head := make([]byte, 512)
+			bytesRead, err := file.ReadAt(head, 0)
+			if err != nil && err != io.EOF {
 				errChan <- fmt.Errorf("failed to read file %s: %w", fh.Filename, err)
 				return
 			}
-			mediaType := http.DetectContentType(mediaData)
+			mediaType := http.DetectContentType(head[:bytesRead])
 			var mediaID string
-			mediaID, err = s.uploadSingleChunked(httpClient, mediaData, mediaType)
+			mediaID, err = s.uploadSingleChunked(ctx, httpClient, file, fh.Size, mediaType)
 			if err != nil {
 				errChan <- fmt.Errorf("failed to upload %s: %w", fh.Filename, err)
 				return
 			}
 
-			resultChan <- mediaID
-		}(fileHeader)
+			if alt != "" {
+				if err := s.repo_twitter.CreateMediaMetadata(ctx, httpClient, mediaID, alt); err != nil {
+					errChan <- fmt.Errorf("failed to set alt text of %s: %w", fh.Filename, err)
+					return
+				}
+			}
+
+			publisher.Uploading(ctx, int(uploaded.Add(1)), len(files))
+			mediaIDs[idx] = mediaID
+		}(idx, fileHeader, altText[idx])
 	}
 
-	// Wait for all goroutines to finish, then close the channels.
+	// Wait for all goroutines to finish, then close the error channel.
 	go func() {
 		wg.Wait()
-		close(resultChan)
 		close(errChan)
 	}()
 
-	var mediaIDs []string
-	// First, check for any errors. If we find one, we fail fast.
+	// Check for any errors. If we find one, we fail fast. Once the channel
+	// is closed, every upload has written its media ID.
 	if err := <-errChan; err != nil {
 		return nil, err
 	}
 
-	// If no errors, collect all the successful media IDs.
-	for id := range resultChan {
-		mediaIDs = append(mediaIDs, id)
-	}
-
-	if len(mediaIDs) != len(files) {
-		return nil, fmt.Errorf("an unknown error occurred: not all media files were uploaded")
+	for _, id := range mediaIDs {
+		if id == "" {
+			return nil, fmt.Errorf("an unknown error occurred: not all media files were uploaded")
+		}
 	}
 
 	return mediaIDs, nil
 }
 
-func (s *twitterServiceImpl) uploadSingleChunked(httpClient *http.Client, mediaData []byte, mediaType string) (string, error) {
+func (s *twitterServiceImpl) uploadSingleChunked(ctx context.Context, httpClient *http.Client, media io.ReaderAt, size int64, mediaType string) (string, error) {
 	mediaCategory := ""
 	switch mediaType {
 	case "image/gif":
 		mediaCategory = "tweet_gif"
 	case "video/mp4":
 		mediaCategory = "tweet_video"
-	case "image/jpeg", "image/png":
+	case "image/jpeg", "image/png", "image/webp":
 		mediaCategory = "tweet_image"
 	default:
 		return "", fmt.Errorf("unsupported media type: %s", mediaType)
 	}
 
 	// 1. INIT
-	mediaID, err := s.repo_twitter.InitUpload(httpClient, mediaData, mediaType, mediaCategory)
+	mediaID, err := s.repo_twitter.InitUpload(ctx, httpClient, size, mediaType, mediaCategory)
 	if err != nil {
 		return "", fmt.Errorf("chunked upload INIT failed: %w", err)
 	}
 
 	// 2. APPEND
-	err = s.appendUploads(httpClient, mediaID, mediaData)
+	err = s.appendUploads(ctx, httpClient, mediaID, media, size)
 	if err != nil {
 		return "", fmt.Errorf("chunked upload APPEND failed: %w", err)
 	}
 
 	// 3. FINALIZE
-	err = s.repo_twitter.FinalizeUpload(httpClient, mediaID)
+	err = s.repo_twitter.FinalizeUpload(ctx, httpClient, mediaID)
 	if err != nil {
 		return "", fmt.Errorf("chunked upload FINALIZE failed: %w", err)
 	}
 
 	// 4. STATUS CHECK
-	err = s.statusHandlingLoop(httpClient, mediaCategory, mediaID)
+	err = s.statusHandlingLoop(ctx, httpClient, mediaCategory, mediaID)
 	if err != nil {
 		return "", fmt.Errorf("media processing failed: %w", err)
 	}
@@ -158,23 +209,13 @@ func (s *twitterServiceImpl) uploadSingleChunked(httpClient *http.Client, mediaD
 	return mediaID, nil
 }
 
-func (s *twitterServiceImpl) appendUploads(httpClient *http.Client, mediaID string, mediaData []byte) error {
-	const maxChunkSize = 4 * 1024 * 1024
+func (s *twitterServiceImpl) appendUploads(ctx context.Context, httpClient *http.Client, mediaID string, media io.ReaderAt, size int64) error {
 	var segmentIndex int
 
-	reader := bytes.NewReader(mediaData)
-	chunk := make([]byte, maxChunkSize)
+	for offset := int64(0); offset < size; offset += maxChunkSize {
+		segment := io.NewSectionReader(media, offset, min(maxChunkSize, size-offset))
 
-	for {
-		bytesRead, err := reader.Read(chunk)
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if bytesRead == 0 {
-			break
-		}
-
-		statusCode, err := s.repo_twitter.AppendUpload(httpClient, mediaID, chunk[:bytesRead], segmentIndex)
+		statusCode, err := s.repo_twitter.AppendUpload(ctx, httpClient, mediaID, segment, segmentIndex)
 		if err != nil {
 			return fmt.Errorf("failed to append chunk %d: %w", segmentIndex, err)
 		}
@@ -186,7 +227,7 @@ func (s *twitterServiceImpl) appendUploads(httpClient *http.Client, mediaID stri
 	return nil
 }
 
-func (s *twitterServiceImpl) statusHandlingLoop(httpClient *http.Client, mediaCategory string, mediaID string) error {
+func (s *twitterServiceImpl) statusHandlingLoop(ctx context.Context, httpClient *http.Client, mediaCategory string, mediaID string) error {
 	if mediaCategory != "tweet_video" && mediaCategory != "tweet_gif" {
 		return nil // No processing needed for images
 	}
@@ -196,24 +237,28 @@ func (s *twitterServiceImpl) statusHandlingLoop(httpClient *http.Client, mediaCa
 
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
 		case <-timeout:
 			return fmt.Errorf("timed out while waiting for media processing")
 
 		case <-time.After(checkAfter):
-			statusResp, err := s.repo_twitter.StatusUpload(httpClient, mediaID)
+			statusResp, err := s.repo_twitter.StatusUpload(ctx, httpClient, mediaID)
 			if err != nil {
 				return fmt.Errorf("error during STATUS check: %w", err)
 			}
 
 			state := statusResp.Data.ProcessingInfo.State
-			fmt.Printf("DEBUG: Media processing state is '%s', progress %d%%", state, statusResp.Data.ProcessingInfo.Progress)
+			log.Printf("[TWITTER_SERVICE] --- Media %s processing state is %s, progress %d%%", mediaID, state, statusResp.Data.ProcessingInfo.Progress)
+			publisher.Processing(ctx, statusResp.Data.ProcessingInfo.Progress)
 
 			if state == "succeeded" {
 				return nil // Success
 			}
 
 			if state == "failed" {
-				return fmt.Errorf("media processing failed: %s", statusResp.Error.Message)
+				return &provider.Error{Platform: provider.X.Name, Class: provider.InvalidContent, Message: statusResp.Error.Message}
 			}
 
 			// Update the wait time for the next loop from the API's suggestion.
@@ -227,28 +272,69 @@ func (s *twitterServiceImpl) statusHandlingLoop(httpClient *http.Client, mediaCa
 }
 
 
-func (s *twitterServiceImpl) PostTweet(accessToken string, accessSecret string, content string, files []*multipart.FileHeader) error {
+func (s *twitterServiceImpl) PostTweet(ctx context.Context, accessToken string, accessSecret string, content string, files []*multipart.FileHeader, altText []string) (string, error) {
+	httpClient := repo_twitter.NewOAuthClient(s.twitterConfig, accessToken, accessSecret, httpclient.Upload.Timeout)
+
+	return s.postTweet(ctx, httpClient, content, files, altText, "")
+}
+
+// PostThread posts the tweets in order, each one as a reply to the previous
+// one, and returns their IDs. It stops at the first failure and returns a
+// *ThreadError listing the tweets that were already posted.
+func (s *twitterServiceImpl) PostThread(ctx context.Context, accessToken string, accessSecret string, tweets []ThreadTweet) ([]string, error) {
+	httpClient := repo_twitter.NewOAuthClient(s.twitterConfig, accessToken, accessSecret, httpclient.Upload.Timeout)
+
+	posted := []string{}
+	replyTo := ""
+	for idx, tweet := range tweets {
+		tweetID, err := s.postTweet(ctx, httpClient, tweet.Text, tweet.Files, tweet.AltText, replyTo)
+		if err != nil {
+			return posted, &ThreadError{Index: idx, Posted: posted, Err: err}
+		}
+		posted = append(posted, tweetID)
+		replyTo = tweetID
+	}
+	return posted, nil
+}
+
+func (s *twitterServiceImpl) postTweet(ctx context.Context, httpClient *http.Client, content string, files []*multipart.FileHeader, altText []string, replyTo string) (string, error) {
 	var mediaIDs []string
 	var err error
 
-	token := oauth1.NewToken(accessToken, accessSecret)
-	httpClient := s.twitterConfig.Client(oauth1.NoContext, token)
-
 	postURL := "https://api.x.com/2/tweets"
 	payload := map[string]interface{}{
 		"text": content,
 	}
+	if replyTo != "" {
+		payload["reply"] = map[string]interface{}{
+			"in_reply_to_tweet_id": replyTo,
+		}
+	}
 
 	if len(files) > 0 {
-		mediaIDs, err = s.uploadMultipleMedia(httpClient, files)
+		mediaIDs, err = s.uploadMultipleMedia(ctx, httpClient, files, altText)
 
 		if err != nil {
-			return fmt.Errorf("media upload failed: %w", err)
+			return "", fmt.Errorf("media upload failed: %w", err)
 		}
 		payload["media"] = map[string]interface{}{
 			"media_ids": mediaIDs,
 		}
 	}
 
-	return s.repo_twitter.PostTweet(httpClient, postURL, payload)
+	publisher.Publishing(ctx)
+	return s.repo_twitter.PostTweet(ctx, httpClient, postURL, payload)
+}
+
+// Unlink revokes the user's token on Twitter and deletes it. A failed
+// revocation is only logged, since the user still expects the account to be
+// disconnected here.
+func (s *twitterServiceImpl) Unlink(ctx context.Context, userID string) error {
+	accessToken, accessSecret, err := s.repo_twitter.GetCredentials(ctx, userID)
+	if err == nil {
+		if err := s.repo_twitter.InvalidateToken(ctx, accessToken, accessSecret); err != nil {
+			log.Printf("[TWITTER_SERVICE] --- %v", err)
+		}
+	}
+	return s.repo_twitter.DeleteToken(ctx, userID)
 }
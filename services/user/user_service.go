@@ -5,6 +5,8 @@ import (
 	repo_instagram "backend/repositories/instagram"
 	repo_twitter "backend/repositories/twitter"
 	repo_user "backend/repositories/user"
+	"backend/services/publisher"
+	"context"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
@@ -13,44 +15,39 @@ import (
 )
 
 type UserService interface {
-	CreateUser(user *models.User) error
-	LoginUser(user *models.User) (string, error)
+	CreateUser(ctx context.Context, user *models.User) error
+	LoginUser(ctx context.Context, user *models.User) (string, error)
 	GetJWTSecret() []byte
 	IsLoggedIn(c echo.Context) (string, error)
-	SaveTwitterToken(email string, accessToken string, accessSecret string) error
-	GetTwitterToken(email string) (string, string, error)
-	SaveInstagramToken(email string, accessToken string, expiresIn int) error
-	GetInstagramCredentials(email string) (string, string, error)
-	GetOAuthLinkStatus(jwtToken string) (OAuthStatus, error)
+	GetUserID(ctx context.Context, email string) (string, error)
+	SaveTwitterToken(ctx context.Context, email string, accessToken string, accessSecret string) error
+	GetTwitterToken(ctx context.Context, email string) (string, string, error)
+	SaveInstagramToken(ctx context.Context, email string, accessToken string, expiresIn int) error
+	GetInstagramCredentials(ctx context.Context, email string) (string, string, error)
+	GetOAuthLinkStatus(ctx context.Context, email string) (map[string]bool, error)
+	GetReconnectStatus(ctx context.Context, email string) (map[string]bool, error)
 }
 
 type userServiceImpl struct {
 	repo_user      repo_user.UserRepository
 	repo_instagram repo_instagram.InstagramRepository
 	repo_twitter   repo_twitter.TwitterRepository
+	registry       *publisher.Registry
 	jwtSecret      []byte
 }
 
-type OAuthStatus struct {
-	Twitter    bool
-	Instagram  bool
-	Bluesky    bool
-	Mastodon   bool
-	Artstation bool
-	Youtube    bool
-}
-
-func NewUserService(repoUser repo_user.UserRepository, repoInstagram repo_instagram.InstagramRepository, repoTwitter repo_twitter.TwitterRepository, jwtSecret []byte) UserService {
+func NewUserService(repoUser repo_user.UserRepository, repoInstagram repo_instagram.InstagramRepository, repoTwitter repo_twitter.TwitterRepository, registry *publisher.Registry, jwtSecret []byte) UserService {
 	return &userServiceImpl{
 		repo_user:      repoUser,
 		repo_instagram: repoInstagram,
 		repo_twitter:   repoTwitter,
+		registry:       registry,
 		jwtSecret:      []byte(jwtSecret),
 	}
 }
 
-func (s *userServiceImpl) CreateUser(user *models.User) error {
-	exists, err := s.repo_user.ExistsByEmail(user.Email)
+func (s *userServiceImpl) CreateUser(ctx context.Context, user *models.User) error {
+	exists, err := s.repo_user.ExistsByEmail(ctx, user.Email)
 	if err != nil {
 		return err
 	}
@@ -58,11 +55,11 @@ func (s *userServiceImpl) CreateUser(user *models.User) error {
 		return fmt.Errorf("user already exists")
 	}
 
-	return s.repo_user.Create(user)
+	return s.repo_user.Create(ctx, user)
 }
 
-func (s *userServiceImpl) LoginUser(user *models.User) (string, error) {
-	data, err := s.repo_user.FindByEmail(user.Email)
+func (s *userServiceImpl) LoginUser(ctx context.Context, user *models.User) (string, error) {
+	data, err := s.repo_user.FindByEmail(ctx, user.Email)
 	if err != nil {
 		return "", err
 	}
@@ -118,25 +115,29 @@ func (s *userServiceImpl) IsLoggedIn(c echo.Context) (string, error) {
 	return email, nil
 }
 
-func (s *userServiceImpl) SaveTwitterToken(email, accessToken, accessSecret string) error {
-	userID, err := s.repo_user.UserIDByEmail(email)
+func (s *userServiceImpl) GetUserID(ctx context.Context, email string) (string, error) {
+	return s.repo_user.UserIDByEmail(ctx, email)
+}
+
+func (s *userServiceImpl) SaveTwitterToken(ctx context.Context, email, accessToken, accessSecret string) error {
+	userID, err := s.repo_user.UserIDByEmail(ctx, email)
 	if err != nil {
 		return err
 	}
 
-	return s.repo_twitter.SaveToken(userID, accessToken, accessSecret)
+	return s.repo_twitter.SaveToken(ctx, userID, accessToken, accessSecret)
 }
 
-func (s *userServiceImpl) GetTwitterToken(email string) (string, string, error) {
-	userID, err := s.repo_user.UserIDByEmail(email)
+func (s *userServiceImpl) GetTwitterToken(ctx context.Context, email string) (string, string, error) {
+	userID, err := s.repo_user.UserIDByEmail(ctx, email)
 	if err != nil {
 		return "", "", err
 	}
-	return s.repo_twitter.GetCredentials(userID)
+	return s.repo_twitter.GetCredentials(ctx, userID)
 }
 
-func (s *userServiceImpl) SaveInstagramToken(email string, accessToken string, expiresIn int) error {
-	userID, err := s.repo_user.UserIDByEmail(email)
+func (s *userServiceImpl) SaveInstagramToken(ctx context.Context, email string, accessToken string, expiresIn int) error {
+	userID, err := s.repo_user.UserIDByEmail(ctx, email)
 	if err != nil {
 		return err
 	}
@@ -145,55 +146,55 @@ func (s *userServiceImpl) SaveInstagramToken(email string, accessToken string, e
 	//convert to string
 	expirationTimeStr := expirationTime.Format(time.RFC3339)
 
-	instagramID, err := s.repo_instagram.GetInstagramID(accessToken)
+	instagramID, err := s.repo_instagram.GetInstagramID(ctx, accessToken)
 	if err != nil {
 		return err
 	}
 
-	return s.repo_instagram.SaveToken(accessToken, userID, instagramID, expirationTimeStr)
+	return s.repo_instagram.SaveToken(ctx, accessToken, userID, instagramID, expirationTimeStr)
 }
 
-func (s *userServiceImpl) GetInstagramCredentials(email string) (string, string, error) {
-	userID, err := s.repo_user.UserIDByEmail(email)
+func (s *userServiceImpl) GetInstagramCredentials(ctx context.Context, email string) (string, string, error) {
+	userID, err := s.repo_user.UserIDByEmail(ctx, email)
 	if err != nil {
 		return "", "", err
 	}
 
-	return s.repo_instagram.GetCredentials(userID)
+	return s.repo_instagram.GetCredentials(ctx, userID)
 }
 
-func (s *userServiceImpl) GetOAuthLinkStatus(email string) (OAuthStatus, error) {
-	var status OAuthStatus
+// GetOAuthLinkStatus reports, for every registered platform, whether the
+// user has a working linked account.
+func (s *userServiceImpl) GetOAuthLinkStatus(ctx context.Context, email string) (map[string]bool, error) {
+	status := map[string]bool{}
 
-	userID, err := s.repo_user.UserIDByEmail(email)
+	userID, err := s.repo_user.UserIDByEmail(ctx, email)
 	if err != nil {
 		return status, err
 	}
 
-	status.Twitter = s.checkTwitter(userID)
-	status.Instagram = s.checkInstagram(userID)
-
-	// Placeholders for future platforms
-	status.Bluesky = false
-	status.Mastodon = false
-	status.Artstation = false
-	status.Youtube = false
+	for _, p := range s.registry.Publishers() {
+		status[p.Platform()] = p.IsLinked(ctx, userID)
+	}
 
 	return status, nil
 }
 
-func (s *userServiceImpl) checkTwitter(userID string) bool {
-	twitterAT, twitterAS, err := s.repo_twitter.GetCredentials(userID)
+// GetReconnectStatus reports, for every platform whose credentials are
+// renewed in the background, whether the user has to link the account again.
+func (s *userServiceImpl) GetReconnectStatus(ctx context.Context, email string) (map[string]bool, error) {
+	status := map[string]bool{}
+
+	userID, err := s.repo_user.UserIDByEmail(ctx, email)
 	if err != nil {
-		return false
+		return status, err
 	}
-	return s.repo_twitter.CheckTokens(twitterAT, twitterAS) == nil
-}
 
-func (s *userServiceImpl) checkInstagram(userID string) bool {
-	instagramAT, _, err := s.repo_instagram.GetCredentials(userID)
-	if err != nil {
-		return false
+	for _, p := range s.registry.Publishers() {
+		if reconnector, ok := p.(publisher.Reconnector); ok {
+			status[p.Platform()] = reconnector.NeedsReconnect(ctx, userID)
+		}
 	}
-	return s.repo_instagram.CheckTokens(instagramAT) == nil
+
+	return status, nil
 }